@@ -0,0 +1,82 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Source returns PEM encoded certificate and key.
+type Source func(ctx context.Context) (certPEM, keyPEM []byte, err error)
+
+// FileSource returns Source that reads the certificate and the key from the files.
+func FileSource(certFile, keyFile string) Source {
+	return func(context.Context) (certPEM, keyPEM []byte, err error) {
+		if certPEM, err = os.ReadFile(filepath.Clean(certFile)); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		if keyPEM, err = os.ReadFile(filepath.Clean(keyFile)); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		return certPEM, keyPEM, nil
+	}
+}
+
+// Manager serves the current certificate and replaces it with the one from Source on Rotate.
+type Manager struct {
+	source  Source
+	opts    []Option
+	current atomic.Pointer[tls.Certificate]
+}
+
+// NewManager creates Manager that serves initial until the first successful Rotate. opts are passed to ValidatePair.
+func NewManager(initial *tls.Certificate, source Source, opts ...Option) *Manager {
+	m := &Manager{source: source, opts: opts}
+	m.current.Store(initial)
+	return m
+}
+
+// Rotate fetches the pair from Source, validates it by ValidatePair and atomically replaces the served certificate.
+// The served certificate is kept if the pair can't be fetched or is not valid.
+func (m *Manager) Rotate(ctx context.Context) error {
+	certPEM, keyPEM, err := m.source(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch certificate")
+	}
+	next, err := ValidatePair(certPEM, keyPEM, m.opts...)
+	if err != nil {
+		return err
+	}
+	m.current.Store(&next)
+	return nil
+}
+
+// Certificate returns the served certificate.
+func (m *Manager) Certificate() *tls.Certificate {
+	return m.current.Load()
+}
+
+// GetCertificate returns the served certificate. It can be used as tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.current.Load(), nil
+}
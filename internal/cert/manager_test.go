@@ -0,0 +1,138 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/cert"
+)
+
+// servedLeaf returns DER of the leaf certificate served by m.
+func servedLeaf(t *testing.T, m *cert.Manager) []byte {
+	t.Helper()
+	served, err := m.GetCertificate(nil)
+	if err != nil || served == nil || len(served.Certificate) == 0 {
+		t.Fatalf("expected the served certificate, got %v, %v", served, err)
+	}
+	return served.Certificate[0]
+}
+
+func TestManagerRotate(t *testing.T) {
+	now := time.Now()
+	key, keyPEM := newKey(t)
+	initialPEM := newCertificate(t, key, now.Add(-time.Hour), now.Add(time.Hour))
+	initial, err := cert.ValidatePair(initialPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	write := func(certPEM, keyPEM []byte) {
+		if err = os.WriteFile(certFile, certPEM, 0o600); err != nil {
+			t.Fatalf("failed to write certificate: %v", err)
+		}
+		if err = os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+	}
+	m := cert.NewManager(&initial, cert.FileSource(certFile, keyFile))
+
+	// The files don't exist yet
+	if err = m.Rotate(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if !bytes.Equal(servedLeaf(t, m), initial.Certificate[0]) {
+		t.Fatal("the served certificate is replaced by the missing one")
+	}
+
+	_, anotherKeyPEM := newKey(t)
+	write(newCertificate(t, key, now.Add(-time.Hour), now.Add(2*time.Hour)), anotherKeyPEM)
+	if err = m.Rotate(context.Background()); !errors.Is(err, cert.ErrKeyMismatch) {
+		t.Fatalf("expected %v, got %v", cert.ErrKeyMismatch, err)
+	}
+	if !bytes.Equal(servedLeaf(t, m), initial.Certificate[0]) {
+		t.Fatal("the served certificate is replaced by the invalid one")
+	}
+
+	rotatedPEM := newCertificate(t, key, now.Add(-time.Hour), now.Add(3*time.Hour))
+	write(rotatedPEM, keyPEM)
+	if err = m.Rotate(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rotated, err := cert.ValidatePair(rotatedPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(servedLeaf(t, m), rotated.Certificate[0]) || m.Certificate().Leaf == nil {
+		t.Fatal("the served certificate is not rotated")
+	}
+}
+
+func TestManagerRotateWithClockSkewTolerance(t *testing.T) {
+	now := time.Now()
+	key, keyPEM := newKey(t)
+	certPEM := newCertificate(t, key, now.Add(time.Minute), now.Add(time.Hour))
+	source := func(context.Context) ([]byte, []byte, error) {
+		return certPEM, keyPEM, nil
+	}
+
+	if err := cert.NewManager(nil, source).Rotate(context.Background()); !errors.Is(err, cert.ErrNotYetValid) {
+		t.Fatalf("expected %v, got %v", cert.ErrNotYetValid, err)
+	}
+	m := cert.NewManager(nil, source, cert.WithClockSkewTolerance(5*time.Minute))
+	if err := m.Rotate(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Certificate() == nil {
+		t.Fatal("the certificate is not rotated")
+	}
+}
+
+func TestManagerRotateWithPolicy(t *testing.T) {
+	now := time.Now()
+	key, keyPEM := newKey(t)
+	certPEM := newCertificate(t, key, now.Add(-time.Hour), now.Add(time.Hour))
+	source := func(context.Context) ([]byte, []byte, error) {
+		return certPEM, keyPEM, nil
+	}
+	errPolicy := errors.New("ecdsa keys are not allowed")
+	policy := func(certificate *tls.Certificate) error {
+		if _, ok := certificate.PrivateKey.(*ecdsa.PrivateKey); ok {
+			return errPolicy
+		}
+		return nil
+	}
+
+	m := cert.NewManager(nil, source, cert.WithPolicy(policy))
+	if err := m.Rotate(context.Background()); !errors.Is(err, errPolicy) {
+		t.Fatalf("expected %v, got %v", errPolicy, err)
+	}
+	if m.Certificate() != nil {
+		t.Fatal("the certificate rejected by the policy is served")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cert contains helpers for the serving certificates of cmd-admission-webhook-k8s
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidCertificate means that the certificate PEM doesn't contain a parsable certificate
+	ErrInvalidCertificate = errors.New("invalid certificate")
+	// ErrInvalidKey means that the key PEM doesn't contain a parsable private key
+	ErrInvalidKey = errors.New("invalid private key")
+	// ErrKeyMismatch means that the private key doesn't match the public key of the certificate
+	ErrKeyMismatch = errors.New("private key doesn't match certificate")
+	// ErrExpired means that the certificate is not valid anymore
+	ErrExpired = errors.New("certificate is expired")
+	// ErrNotYetValid means that the certificate is not valid yet
+	ErrNotYetValid = errors.New("certificate is not valid yet")
+)
+
+type options struct {
+	clockSkewTolerance time.Duration
+	policy             func(*tls.Certificate) error
+}
+
+// Option is an option of ValidatePair.
+type Option func(*options)
+
+// WithClockSkewTolerance accepts the certificates that are not valid yet or already expired by at most tolerance.
+func WithClockSkewTolerance(tolerance time.Duration) Option {
+	return func(o *options) {
+		o.clockSkewTolerance = tolerance
+	}
+}
+
+// WithPolicy rejects the valid pairs for which policy returns an error, e.g. the pairs with weak keys.
+func WithPolicy(policy func(*tls.Certificate) error) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// ValidatePair checks that certPEM and keyPEM can be parsed, the key matches the certificate and the certificate
+// is currently valid. Returned errors wrap ErrInvalidCertificate, ErrInvalidKey, ErrKeyMismatch, ErrNotYetValid or
+// ErrExpired, otherwise they're returned by the policy of WithPolicy.
+func ValidatePair(certPEM, keyPEM []byte, opts ...Option) (tls.Certificate, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	leaf, err := parseLeaf(certPEM)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(ErrInvalidCertificate, err.Error())
+	}
+	if err = parseKey(keyPEM); err != nil {
+		return tls.Certificate{}, errors.Wrap(ErrInvalidKey, err.Error())
+	}
+	result, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(ErrKeyMismatch, err.Error())
+	}
+	now := time.Now()
+	if now.Add(o.clockSkewTolerance).Before(leaf.NotBefore) {
+		return tls.Certificate{}, errors.Wrapf(ErrNotYetValid, "certificate %v is valid from %v", leaf.Subject, leaf.NotBefore)
+	}
+	if now.Add(-o.clockSkewTolerance).After(leaf.NotAfter) {
+		return tls.Certificate{}, errors.Wrapf(ErrExpired, "certificate %v is valid until %v", leaf.Subject, leaf.NotAfter)
+	}
+	result.Leaf = leaf
+	if o.policy != nil {
+		if err = o.policy(&result); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return result, nil
+}
+
+func parseLeaf(certPEM []byte) (*x509.Certificate, error) {
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+	return nil, errors.New("no CERTIFICATE block found")
+}
+
+func parseKey(keyPEM []byte) error {
+	for block, rest := pem.Decode(keyPEM); block != nil; block, rest = pem.Decode(rest) {
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+		if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			switch key.(type) {
+			case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+				return nil
+			}
+			return errors.Errorf("unsupported private key type %T", key)
+		}
+		if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			return nil
+		}
+		_, err := x509.ParseECPrivateKey(block.Bytes)
+		return errors.Wrap(err, "failed to parse private key")
+	}
+	return errors.New("no PRIVATE KEY block found")
+}
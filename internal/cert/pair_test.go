@@ -0,0 +1,128 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/cert"
+)
+
+// newKey returns a new ECDSA key and its PKCS8 PEM encoding.
+func newKey(t *testing.T) (key crypto.Signer, keyPEM []byte) {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return k, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// newCertificate returns PEM encoded self signed certificate of key valid from notBefore to notAfter.
+func newCertificate(t *testing.T, key crypto.Signer, notBefore, notAfter time.Time) []byte {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "admission-webhook-svc.nsm-system.svc"},
+		DNSNames:     []string{"admission-webhook-svc.nsm-system.svc"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestValidatePair(t *testing.T) {
+	key, keyPEM := newKey(t)
+	_, anotherKeyPEM := newKey(t)
+	now := time.Now()
+	valid := newCertificate(t, key, now.Add(-time.Hour), now.Add(time.Hour))
+
+	for _, tc := range []struct {
+		name    string
+		certPEM []byte
+		keyPEM  []byte
+		opts    []cert.Option
+		wantErr error
+	}{
+		{name: "valid", certPEM: valid, keyPEM: keyPEM},
+		{name: "invalid certificate", certPEM: keyPEM, keyPEM: keyPEM, wantErr: cert.ErrInvalidCertificate},
+		{name: "invalid key", certPEM: valid, keyPEM: valid, wantErr: cert.ErrInvalidKey},
+		{name: "mismatched key", certPEM: valid, keyPEM: anotherKeyPEM, wantErr: cert.ErrKeyMismatch},
+		{
+			name:    "expired",
+			certPEM: newCertificate(t, key, now.Add(-2*time.Hour), now.Add(-time.Hour)),
+			keyPEM:  keyPEM,
+			wantErr: cert.ErrExpired,
+		},
+		{
+			name:    "not yet valid",
+			certPEM: newCertificate(t, key, now.Add(time.Hour), now.Add(2*time.Hour)),
+			keyPEM:  keyPEM,
+			wantErr: cert.ErrNotYetValid,
+		},
+		{
+			name:    "expired within clock skew tolerance",
+			certPEM: newCertificate(t, key, now.Add(-time.Hour), now.Add(-time.Minute)),
+			keyPEM:  keyPEM,
+			opts:    []cert.Option{cert.WithClockSkewTolerance(5 * time.Minute)},
+		},
+		{
+			name:    "not yet valid within clock skew tolerance",
+			certPEM: newCertificate(t, key, now.Add(time.Minute), now.Add(time.Hour)),
+			keyPEM:  keyPEM,
+			opts:    []cert.Option{cert.WithClockSkewTolerance(5 * time.Minute)},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			pair, err := cert.ValidatePair(tc.certPEM, tc.keyPEM, tc.opts...)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pair.Leaf == nil || len(pair.Certificate) != 1 {
+				t.Fatalf("expected the parsed certificate, got %+v", pair)
+			}
+		})
+	}
+}
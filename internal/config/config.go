@@ -20,21 +20,43 @@
 package config
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/cert"
 )
 
 // Config represents env configuration for cmd-admission-webhook-k8s
@@ -47,7 +69,7 @@ type Config struct {
 	NSURLEnvName          string            `default:"NSM_NETWORK_SERVICES" desc:"Name of env that contains NSURL in initContainers/Containers" split_words:"true"`
 	InitContainerImages   []string          `desc:"List of init containers that should be appended for each deployment that has Config.Annotation" split_words:"true"`
 	ContainerImages       []string          `desc:"List of containers that should be appended for each deployment that has Config.Annotation" split_words:"true"`
-	Envs                  []string          `desc:"Additional Envs that should be appended for each Config.ContainerImages and Config.InitContainerImages" split_words:"true"`
+	Envs                  []string          `desc:"Additional Envs that should be appended for each Config.ContainerImages and Config.InitContainerImages. Values can reference $(POD_NAME), $(POD_NAMESPACE), $(POD_IP), $(NODE_NAME) that are expanded by k8s" split_words:"true"`
 	WebhookMode           Mode              `default:"spire" desc:"Default 'spire' mode uses spire certificates and external webhook configuration. Set to 'selfregister' to use the automatically generated webhook configuration" split_words:"true"`
 	CertFilePath          string            `desc:"Path to certificate. Preferred use if specified" split_words:"true"`
 	KeyFilePath           string            `desc:"Path to RSA/Ed25519 related to Config.CertFilePath. Preferred use if specified" split_words:"true"`
@@ -58,14 +80,194 @@ type Config struct {
 	SidecarLimitsCPU      string            `default:"200m" desc:"Lower bound of the NSM sidecar CPU limit (in k8s resource management units)" split_words:"true"`
 	SidecarRequestsMemory string            `default:"40Mi" desc:"Lower bound of the NSM sidecar requests memory limits (in k8s resource management units)" split_words:"true"`
 	SidecarRequestsCPU    string            `default:"100m" desc:"Lower bound of the NSM sidecar requests CPU limits (in k8s resource management units)" split_words:"true"`
+	OmitSidecarResources  bool              `default:"false" desc:"Injects the containers without resources instead of Config.SidecarLimitsMemory, Config.SidecarLimitsCPU, Config.SidecarRequestsMemory and Config.SidecarRequestsCPU" split_words:"true"`
 	PprofEnabled          bool              `default:"false" desc:"is pprof enabled" split_words:"true"`
 	PprofListenOn         string            `default:"localhost:6060" desc:"pprof URL to ListenAndServe" split_words:"true"`
 	// QPS for 50 NSC
 	KubeletQPS int `default:"50" desc:"kubelet QPS config" split_words:"true"`
-	envs       []corev1.EnvVar
-	caBundle   []byte
-	cert       tls.Certificate
-	once       sync.Once
+	// Admission warnings
+	DeprecatedAnnotations     []string `desc:"List of deprecated annotation names that are still handled as Config.Annotation" split_words:"true"`
+	WarnDeprecatedAnnotations bool     `default:"true" desc:"Add an admission warning when the resource uses one of Config.DeprecatedAnnotations" split_words:"true"`
+	WarnFloatingImageTags     bool     `default:"true" desc:"Add an admission warning when the resource uses an image without a tag or with the 'latest' tag" split_words:"true"`
+	// Admission concurrency
+	MaxConcurrentAdmissions int            `default:"0" desc:"Maximum number of concurrently processed admission requests. 0 means no limit" split_words:"true"`
+	AdmissionOverloadPolicy OverloadPolicy `default:"queue" desc:"Set to 'queue' to make excess admission requests wait or to 'reject' to respond with 503" split_words:"true"`
+	// Mutation errors
+	OnMutationError ErrorPolicy `default:"allow" desc:"Set to 'allow' to admit or to 'deny' to reject the resource that failed to be mutated" split_words:"true"`
+	OnDecodeError   ErrorPolicy `default:"deny" desc:"Set to 'allow' to admit or to 'deny' to reject the resource whose admission review failed to be decoded" split_words:"true"`
+	// Handled resources
+	EnabledResources []string `default:"pods,deployments,statefulsets,daemonsets,replicasets" desc:"List of resources that should be handled by admission webhook" split_words:"true"`
+	// Certificate policy
+	MinRSAKeyBits      int      `default:"2048" desc:"Minimal RSA key size of the certificate related to Config.CertFilePath" split_words:"true"`
+	AllowedKeyTypes    []string `default:"rsa,ecdsa,ed25519" desc:"List of allowed key types of the certificate related to Config.CertFilePath" split_words:"true"`
+	AllowedECDSACurves []string `default:"P-256,P-384,P-521" desc:"List of allowed ECDSA curves of the certificate related to Config.CertFilePath" split_words:"true"`
+	MaxCertChainDepth  int      `default:"0" desc:"Maximal number of certificates in the chain related to Config.CertFilePath. 0 means no limit" split_words:"true"`
+	// Annotations
+	InjectAnnotations    map[string]string `default:"" desc:"Map of annotations and their values that should be appended for each deployment that has Config.Annotation" split_words:"true"`
+	OverwriteAnnotations bool              `default:"false" desc:"Overwrite existing annotations with the values from Config.InjectAnnotations" split_words:"true"`
+	// Tracing
+	EnableTracing bool `default:"false" desc:"Export OpenTelemetry spans for each admission request" split_words:"true"`
+	// Pod selection
+	InjectPodSelector string `default:"" desc:"Label selector that pods should match to be mutated. Empty selector matches all pods" split_words:"true"`
+	// Image tags
+	DefaultImageTag      string `default:"" desc:"Tag that is applied to Config.InitContainerImages and Config.ContainerImages without tag or digest" split_words:"true"`
+	RejectUntaggedImages bool   `default:"false" desc:"Fail on start if Config.InitContainerImages or Config.ContainerImages contain an image without tag or digest" split_words:"true"`
+	// SPIRE liveness
+	SpireLivenessCheckInterval    time.Duration `default:"10s" desc:"Interval between checks that spire x509 source provides valid SVID. 0 disables the checks" split_words:"true"`
+	SpireLivenessFailureThreshold int           `default:"3" desc:"Number of consecutive failed spire x509 source checks that makes admission webhook not ready" split_words:"true"`
+	// SPIRE reconnect
+	SpireReconnectBackoff     time.Duration `default:"1s" desc:"Initial interval between attempts to reconnect spire x509 source after Config.SpireLivenessFailureThreshold failed checks. It doubles after every failed attempt" split_words:"true"`
+	SpireReconnectMaxInterval time.Duration `default:"30s" desc:"Max interval between attempts to reconnect spire x509 source. It also limits the duration of a single attempt" split_words:"true"`
+	// SPIRE SVID wait
+	SpireSVIDWaitTimeout time.Duration `default:"60s" desc:"Max duration to wait for the first valid SVID of spire x509 source before serving. The start fails if it's exceeded. 0 waits without a limit" split_words:"true"`
+	// Generated webhook configuration
+	MatchPolicy string `default:"Equivalent" desc:"matchPolicy of the generated webhook configuration: 'Equivalent' or 'Exact'" split_words:"true"`
+	WebhookURL  string `default:"" desc:"HTTPS URL of the mutate endpoint used in the generated webhook configuration instead of the service reference" split_words:"true"`
+	// The apiserver uses the first listed version it supports
+	AdmissionReviewVersions []string `default:"v1" desc:"Ordered list of admissionReviewVersions of the generated webhook configuration: 'v1', 'v1beta1'" split_words:"true"`
+	// Env sources
+	EnvFromConfigMaps []string `desc:"List of config maps that should be used as env sources for each Config.ContainerImages and Config.InitContainerImages" split_words:"true"`
+	EnvFromSecrets    []string `desc:"List of secrets that should be used as env sources for each Config.ContainerImages and Config.InitContainerImages" split_words:"true"`
+	// NSURL env names
+	NSURLEnvNames map[string]string `default:"" desc:"Map of injected container names and names of env that contains NSURL. Config.NSURLEnvName is used for not listed containers" split_words:"true"`
+	// Label envs
+	LabelToEnvMapping map[string]string `default:"" desc:"Map of pod labels and names of env of the injected containers that contains them, e.g. 'app:NSM_LABELS'. Labels mapped to the same env are joined: 'app:<value>,tier:<value>'" split_words:"true"`
+	// Host network
+	AdjustForHostNetwork bool `default:"false" desc:"Drop pod IP envs and use ClusterFirstWithHostNet DNS policy for host network pods" split_words:"true"`
+	// Cold start
+	ColdStartPolicy  ColdStartPolicy `default:"wait" desc:"Set to 'wait' to wait for initialization up to Config.ColdStartTimeout or to 'passthrough' to admit requests received before initialization without changes" split_words:"true"`
+	ColdStartTimeout time.Duration   `default:"5s" desc:"Maximal time to wait for initialization with 'wait' Config.ColdStartPolicy" split_words:"true"`
+	// Per-workload overrides
+	SidecarResourcesAnnotation string `default:"networkservicemesh.io/sidecar-resources" desc:"Name of annotation that overrides resources of the injected containers, e.g. 'cpu=500m,memory=128Mi'. 'none' omits the resources" split_words:"true"`
+	SidecarImageAnnotation     string `default:"networkservicemesh.io/sidecar-image" desc:"Name of annotation that overrides images from Config.ContainerImages with the same name, e.g. 'registry/cmd-nsc:v1.2.3'" split_words:"true"`
+	AppVolumeMountsAnnotation  string `default:"networkservicemesh.io/app-volume-mounts" desc:"Name of annotation that overrides Config.AppContainerVolumeMounts, e.g. 'nsm-socket:/var/lib/networkservicemesh'" split_words:"true"`
+	SkipContainersAnnotation   string `default:"networkservicemesh.io/skip-containers" desc:"Name of annotation that lists the existing containers that are not changed in addition to Config.ProtectedContainers, e.g. 'sidecar-a,sidecar-b'" split_words:"true"`
+	// Validation
+	StrictConfigValidation bool `default:"false" desc:"Fail on start if the configuration is inconsistent instead of logging warnings" split_words:"true"`
+	// Env target
+	EnvTarget EnvTarget `default:"injected-only" desc:"Containers that get injected envs: 'all', 'initContainers', 'containers' or 'injected-only'" split_words:"true"`
+	// Pod spec
+	InjectTopologySpreadConstraints TopologySpreadConstraints `default:"" desc:"JSON list of topology spread constraints that should be appended to each pod that has Config.Annotation" split_words:"true"`
+	// Sidecar probes
+	SidecarReadinessProbe *Probe `desc:"JSON readiness probe of the containers from Config.ContainerImages" split_words:"true"`
+	SidecarLivenessProbe  *Probe `desc:"JSON liveness probe of the containers from Config.ContainerImages" split_words:"true"`
+	SidecarStartupProbe   *Probe `desc:"JSON startup probe of the containers from Config.ContainerImages. Liveness and readiness probes are disabled until it succeeds" split_words:"true"`
+	// Server
+	ServerPort    int    `default:"443" desc:"Port of the admission webhook server and of the service reference in the registered MutatingWebhookConfiguration" split_words:"true"`
+	ListenAddress string `default:"0.0.0.0" desc:"IP address the admission webhook server binds. The unspecified address binds all interfaces" split_words:"true"`
+	// Self signed certificate
+	AllowSelfSignedFallback bool         `default:"true" desc:"Generates the self signed certificate in selfregister mode if Config.CertFilePath and Config.KeyFilePath are not set. If false, the start fails instead" split_words:"true"`
+	IncludePodIPSAN         bool         `default:"false" desc:"Adds the pod IP to the IP SANs of the self signed certificate. The pod IP is taken from POD_IP env or from the in-cluster client" split_words:"true"`
+	CertExtKeyUsages        []string     `default:"serverAuth" desc:"List of extended key usages of the self signed certificate: 'serverAuth', 'clientAuth'" split_words:"true"`
+	KeyPEMFormat            KeyPEMFormat `default:"pkcs1" desc:"Encoding of the self signed certificate key: 'pkcs1' (RSA PRIVATE KEY) or 'pkcs8' (PRIVATE KEY)" split_words:"true"`
+	// TLS
+	DisableSessionTickets bool `default:"false" desc:"Disables TLS session tickets. Every connection then requires a full handshake, which increases latency and CPU usage" split_words:"true"`
+	// Container placement
+	ContainerInjectionPosition ContainerPosition `default:"append" desc:"Placement of the containers from Config.ContainerImages: 'append', 'prepend' or 'native' (restartable init containers)" split_words:"true"`
+	UseNativeSidecars          bool              `default:"false" desc:"Injects the containers from Config.ContainerImages as native sidecars if the cluster supports them (v1.28+)" split_words:"true"`
+	// CA rotation
+	CARotationWindow time.Duration `default:"0" desc:"Duration for which CA bundles of the previous admission webhook instances stay in the registered MutatingWebhookConfiguration. 0 means the previous CA bundles are not kept" split_words:"true"`
+	// Subresources
+	IgnoredSubresources []string `default:"status,exec,log" desc:"List of subresources which admission requests are passed through without mutation and warning logs" split_words:"true"`
+	// Projected service account token
+	InjectProjectedSATokenVolume      bool   `default:"false" desc:"Adds the projected service account token volume to the pods and mounts it to the injected containers" split_words:"true"`
+	ProjectedSATokenAudience          string `desc:"Audience of the projected service account token, e.g. 'spire-server'" split_words:"true"`
+	ProjectedSATokenExpirationSeconds int64  `default:"3600" desc:"Expiration of the projected service account token in seconds, at least 600" split_words:"true"`
+	ProjectedSATokenMountPath         string `default:"/var/run/secrets/tokens" desc:"Mount path of the projected service account token volume in the injected containers" split_words:"true"`
+	// CA bundle directory
+	CABundleDir string `desc:"Path to directory with .pem and .crt files that are concatenated into cabundle. Merged with the other CA bundle sources" split_words:"true"`
+	// CA bundle from env
+	CABundleBase64 string `desc:"Base64 encoded PEM cabundle related to Config.CertFilePath. Merged with the other CA bundle sources" split_words:"true"`
+	// CA bundle watch
+	CABundleWatchInterval time.Duration `default:"10s" desc:"Interval between checks of Config.CABundleFilePath and Config.CABundleDir in selfregister mode. The changed CA bundle is published to the registered MutatingWebhookConfiguration. The CA bundle is also checked when the serving certificate is rotated. 0 disables the periodic checks" split_words:"true"`
+	// Audit
+	EmitAuditAnnotations bool `default:"false" desc:"Adds audit annotations describing the injection to the admission responses" split_words:"true"`
+	// Registered webhook configuration
+	WebhookConfigName        string        `desc:"Stable name of MutatingWebhookConfiguration registered in selfregister mode. Existing configuration with this name is updated. Config.Name is used if empty" split_words:"true"`
+	WebhookReconcileInterval time.Duration `default:"1m" desc:"Interval of recreating deleted or restoring changed MutatingWebhookConfiguration registered in selfregister mode. 0 disables reconciliation" split_words:"true"`
+	RegisterRetryAttempts    int           `default:"5" desc:"Maximal number of attempts to publish MutatingWebhookConfiguration and its caBundle on conflicts and transient apiserver errors" split_words:"true"`
+	// Service account
+	RequireServiceAccount string               `desc:"Service account that the pods with Config.Annotation must use. Empty means any service account" split_words:"true"`
+	ServiceAccountPolicy  ServiceAccountPolicy `default:"reject" desc:"Set to 'reject' to deny the pods with another service account or to 'patch' to replace it with Config.RequireServiceAccount" split_words:"true"`
+	// Update requests
+	MutateOnUpdate bool `default:"false" desc:"Mutates UPDATE requests of workloads that don't have the desired injection yet. Pods are mutated only on CREATE" split_words:"true"`
+	// Sidecar removal
+	AllowSidecarRemoval       bool   `default:"false" desc:"Doesn't inject the containers from Config.ContainerImages again if an UPDATE request removes them. They're recorded in Config.RemovedSidecarsAnnotation of the workload" split_words:"true"`
+	RemovedSidecarsAnnotation string `default:"networkservicemesh.io/removed-sidecars" desc:"Name of annotation that lists the containers from Config.ContainerImages that are not injected if Config.AllowSidecarRemoval is set, e.g. 'cmd-nsc'" split_words:"true"`
+	// Protected containers
+	ProtectedContainers []string `desc:"List of names of the existing containers that are never changed by admission webhook" split_words:"true"`
+	// Gradual rollout
+	InjectionPercentage int `default:"100" desc:"Percentage of the matching resources that are mutated. The decision is stable for the resource name or, for generateName, for the controller owner" split_words:"true"`
+	// Default network service
+	DefaultNetworkService string `desc:"NSURL that is used if Config.Annotation of the resource is present but empty" split_words:"true"`
+	RequireNetworkService bool   `default:"false" desc:"Rejects the resources with empty Config.Annotation if Config.DefaultNetworkService is not set. Otherwise they are not mutated" split_words:"true"`
+	// Handler timeout
+	WebhookTimeoutSeconds int32         `default:"10" desc:"timeoutSeconds of the generated webhook configuration, 1-30. In spire mode it should match the applied webhook configuration" split_words:"true"`
+	HandlerTimeout        time.Duration `default:"0" desc:"Deadline of processing of a single admission request. On timeout Config.OnMutationError is applied. It must be less than Config.WebhookTimeoutSeconds. 0 means Config.WebhookTimeoutSeconds minus 1s" split_words:"true"`
+	// Excluded namespaces
+	ExcludeOwnNamespace bool     `default:"true" desc:"Excludes Config.Namespace from the namespace selector of MutatingWebhookConfiguration registered in selfregister mode" split_words:"true"`
+	ExcludedNamespaces  []string `desc:"List of namespaces excluded from the namespace selector of MutatingWebhookConfiguration registered in selfregister mode, e.g. 'kube-system,spire'" split_words:"true"`
+	// Health checks
+	HealthCheckInterval time.Duration `default:"30s" desc:"Interval of the serving certificate and registered MutatingWebhookConfiguration readiness checks. 0 means checking only on start" split_words:"true"`
+	// Termination
+	SidecarPreStop                   *LifecycleHandler `desc:"JSON preStop hook (exec or httpGet) of the containers from Config.ContainerImages" split_words:"true"`
+	MinTerminationGracePeriodSeconds int64             `default:"0" desc:"Minimal terminationGracePeriodSeconds of the pods that have Config.Annotation. Lower values are increased. 0 means no minimum" split_words:"true"`
+	// Namespace annotation
+	HonorNamespaceAnnotation bool          `default:"false" desc:"Config.Annotation of the namespace enables injection for all workloads of the namespace, not only for pods" split_words:"true"`
+	NamespaceCacheTTL        time.Duration `default:"30s" desc:"Duration for which the namespaces fetched for Config.HonorNamespaceAnnotation are cached" split_words:"true"`
+	// SPIRE wait init container
+	InjectSpireWaitInitContainer bool          `default:"false" desc:"Injects the init container that waits for the SPIRE agent socket before the other injected containers" split_words:"true"`
+	SpireWaitImage               string        `default:"busybox:1.36" desc:"Image of the SPIRE wait init container. It must contain sh" split_words:"true"`
+	SpireWaitTimeout             time.Duration `default:"60s" desc:"Duration for which the SPIRE wait init container waits for the SPIRE agent socket before it fails" split_words:"true"`
+	// Logging
+	LogLevel string `default:"info" desc:"Log level: 'debug', 'info', 'warn' or 'error'. JSON patches of the mutated resources are logged at 'debug'" split_words:"true"`
+	// Bypass
+	Bypass bool `default:"false" desc:"Passes through all admission requests without mutation. The mode is toggled at runtime by SIGUSR1" split_words:"true"`
+	// GOMAXPROCS
+	InjectGOMAXPROCS bool `default:"false" desc:"Adds GOMAXPROCS env equal to the CPU limit rounded up to the injected containers" split_words:"true"`
+	// Patch size
+	PatchSizeWarningBytes int `default:"262144" desc:"Size of JSON patch in bytes above which a warning is logged. 0 disables the warning" split_words:"true"`
+	// Process namespace
+	InjectShareProcessNamespace bool `default:"false" desc:"Sets shareProcessNamespace of the pods that have Config.Annotation unless it's set explicitly or hostPID is used" split_words:"true"`
+	// Patch tests
+	EmitPatchTests bool `default:"false" desc:"Prepends JSON patch test operations asserting that the replaced containers, init containers and volumes weren't changed since admission" split_words:"true"`
+	// Required CRD
+	RequireCRD string `default:"" desc:"Group/version/kind that must be served by the apiserver for the injection, e.g. 'networkservicemesh.io/v1/NetworkService'. While it's absent, all requests are passed through and readiness reports the optional condition as failed. It's checked every Config.HealthCheckInterval" split_words:"true"`
+	// Namespace metric label
+	NamespaceMetricLabel bool `default:"false" desc:"Adds namespace label to the admission review, skip and patch size metrics. It increases the metrics cardinality by the number of namespaces" split_words:"true"`
+	// App container volume mounts
+	AppContainerVolumeMounts []string `desc:"List of volume mounts added to the app containers in the format 'volume:mountPath', e.g. 'nsm-socket:/var/lib/networkservicemesh'. Mounts of the volumes that the pod doesn't have and mounts over the existing mount paths are skipped" split_words:"true"`
+	// DaemonSet sidecar resources
+	DaemonSetSidecarResources string `default:"" desc:"Resources of the containers injected into DaemonSets and their pods in the format of Config.SidecarResourcesAnnotation, e.g. 'cpu=50m,memory=32Mi'. Applied over the default resources" split_words:"true"`
+	// Clock skew
+	ClockSkewTolerance time.Duration `default:"1m" desc:"Tolerance of the validity period check of the certificate related to Config.CertFilePath for the clock skew between nodes" split_words:"true"`
+	// Certificate rotation
+	CertRotationInterval time.Duration `default:"1m" desc:"Interval between reloads of Config.CertFilePath and Config.KeyFilePath. The changed pair that passes the validation replaces the serving certificate without restart. 0 disables the reloads" split_words:"true"`
+	// Namespace mutation
+	EnableNamespaceMutation bool `default:"false" desc:"Adds Config.Labels to the created namespaces that have Config.Annotation" split_words:"true"`
+	// Large objects
+	LargeObjectBytes      int               `default:"1048576" desc:"Size of the admitted object in bytes above which Config.LargeObjectPolicy is applied. 0 means no limit" split_words:"true"`
+	LargeObjectContainers int               `default:"100" desc:"Number of init containers and containers of the admitted object above which Config.LargeObjectPolicy is applied. 0 means no limit" split_words:"true"`
+	LargeObjectPolicy     LargeObjectPolicy `default:"process" desc:"Set to 'skip' to admit the large objects without mutation and with a warning or to 'process' to mutate them as usual" split_words:"true"`
+	// Ephemeral containers
+	EnableEphemeralInjection bool   `default:"false" desc:"Injects the debug container to the annotated pods as an ephemeral container on pods/ephemeralcontainers requests" split_words:"true"`
+	EphemeralContainerImage  string `desc:"Image of the injected ephemeral container. The first of Config.ContainerImages is used if empty" split_words:"true"`
+	// Injected containers limit
+	MaxInjectedContainers       int         `default:"0" desc:"Maximal total number of the injected init containers and containers including the SPIRE wait init container. 0 means no limit" split_words:"true"`
+	MaxInjectedContainersPolicy LimitPolicy `default:"reject" desc:"Set to 'reject' to deny the resources exceeding Config.MaxInjectedContainers or to 'truncate' to inject only the first containers with a warning" split_words:"true"`
+
+	envs                   []corev1.EnvVar
+	envFrom                []corev1.EnvFromSource
+	initContainerImages    []string
+	containerImages        []string
+	caBundle               []byte
+	previousCABundle       []byte
+	previousCABundleExpiry time.Time
+	caBundleMutex          sync.Mutex
+	cert                   tls.Certificate
+	podSelector            labels.Selector
+	initErr                error
+	once                   sync.Once
 }
 
 // Mode internal webhook mode type.
@@ -81,7 +283,7 @@ func (md *Mode) Decode(mode string) error {
 		*md = SpireMode
 		return nil
 	}
-	return errors.Errorf("not a valid webhook mode: %s", mode)
+	return errors.Wrapf(ErrInvalidMode, "%q", mode)
 }
 
 // These are the different mode of webhook setup.
@@ -92,90 +294,1244 @@ const (
 	SelfregisterMode
 )
 
+// String returns the name of the webhook mode.
+func (md Mode) String() string {
+	if md == SelfregisterMode {
+		return "selfregister"
+	}
+	return "spire"
+}
+
+// OverloadPolicy defines how admission requests exceeding Config.MaxConcurrentAdmissions are handled.
+type OverloadPolicy uint8
+
+// Decode takes a string policy and returns the OverloadPolicy constant.
+func (p *OverloadPolicy) Decode(policy string) error {
+	switch strings.ToLower(policy) {
+	case "queue":
+		*p = QueueOverloadPolicy
+		return nil
+	case "reject":
+		*p = RejectOverloadPolicy
+		return nil
+	}
+	return errors.Errorf("not a valid overload policy: %s", policy)
+}
+
+// These are the different ways to handle excess admission requests.
+const (
+	// QueueOverloadPolicy makes excess requests wait until one of the processed requests is done.
+	QueueOverloadPolicy OverloadPolicy = iota
+	// RejectOverloadPolicy responds to excess requests with 503 Service Unavailable and Retry-After header.
+	RejectOverloadPolicy
+)
+
+// ErrorPolicy defines whether the resource is admitted when the admission webhook fails to handle it.
+type ErrorPolicy uint8
+
+// Decode takes a string policy and returns the ErrorPolicy constant.
+func (p *ErrorPolicy) Decode(policy string) error {
+	switch strings.ToLower(policy) {
+	case "allow":
+		*p = AllowErrorPolicy
+		return nil
+	case "deny":
+		*p = DenyErrorPolicy
+		return nil
+	}
+	return errors.Errorf("not a valid error policy: %s", policy)
+}
+
+// These are the different ways to respond when the resource can't be handled.
+const (
+	// AllowErrorPolicy admits the resource without changes.
+	AllowErrorPolicy ErrorPolicy = iota
+	// DenyErrorPolicy rejects the resource.
+	DenyErrorPolicy
+)
+
+// ColdStartPolicy defines how admission requests received before the initialization is done are handled.
+type ColdStartPolicy uint8
+
+// Decode takes a string policy and returns the ColdStartPolicy constant.
+func (p *ColdStartPolicy) Decode(policy string) error {
+	switch strings.ToLower(policy) {
+	case "wait":
+		*p = WaitColdStartPolicy
+		return nil
+	case "passthrough":
+		*p = PassthroughColdStartPolicy
+		return nil
+	}
+	return errors.Errorf("not a valid cold start policy: %s", policy)
+}
+
+// These are the different ways to handle requests received before the initialization is done.
+const (
+	// WaitColdStartPolicy waits for the initialization up to Config.ColdStartTimeout and admits the resource without changes on timeout.
+	WaitColdStartPolicy ColdStartPolicy = iota
+	// PassthroughColdStartPolicy admits the resource without changes.
+	PassthroughColdStartPolicy
+)
+
+// EnvTarget defines which containers get the injected envs.
+type EnvTarget uint8
+
+// Decode takes a string target and returns the EnvTarget constant.
+func (t *EnvTarget) Decode(target string) error {
+	switch strings.ToLower(target) {
+	case "injected-only":
+		*t = InjectedOnlyEnvTarget
+		return nil
+	case "all":
+		*t = AllEnvTarget
+		return nil
+	case "initcontainers":
+		*t = InitContainersEnvTarget
+		return nil
+	case "containers":
+		*t = ContainersEnvTarget
+		return nil
+	}
+	return errors.Errorf("not a valid env target: %s", target)
+}
+
+// These are the different sets of containers that get the injected envs.
+const (
+	// InjectedOnlyEnvTarget sets envs only to the containers added by admission webhook.
+	InjectedOnlyEnvTarget EnvTarget = iota
+	// AllEnvTarget sets envs to all init containers and containers.
+	AllEnvTarget
+	// InitContainersEnvTarget sets envs to all init containers.
+	InitContainersEnvTarget
+	// ContainersEnvTarget sets envs to all containers.
+	ContainersEnvTarget
+)
+
+// ServiceAccountPolicy defines how the pods with a service account different from Config.RequireServiceAccount are handled.
+type ServiceAccountPolicy uint8
+
+// Decode takes a string policy and returns the ServiceAccountPolicy constant.
+func (p *ServiceAccountPolicy) Decode(policy string) error {
+	switch strings.ToLower(policy) {
+	case "reject":
+		*p = RejectServiceAccountPolicy
+		return nil
+	case "patch":
+		*p = PatchServiceAccountPolicy
+		return nil
+	}
+	return errors.Errorf("not a valid service account policy: %s", policy)
+}
+
+// These are the different ways to handle the pods with an unexpected service account.
+const (
+	// RejectServiceAccountPolicy denies the pods with an unexpected service account.
+	RejectServiceAccountPolicy ServiceAccountPolicy = iota
+	// PatchServiceAccountPolicy replaces the service account of the pods. Note that the pods get RBAC permissions and
+	// SPIFFE identity of Config.RequireServiceAccount, so anyone who can create annotated pods gets them as well.
+	PatchServiceAccountPolicy
+)
+
+// ContainerPosition defines where the containers from Config.ContainerImages are injected.
+type ContainerPosition uint8
+
+// Decode takes a string position and returns the ContainerPosition constant.
+func (p *ContainerPosition) Decode(position string) error {
+	switch strings.ToLower(position) {
+	case "append":
+		*p = AppendContainerPosition
+		return nil
+	case "prepend":
+		*p = PrependContainerPosition
+		return nil
+	case "native":
+		*p = NativeContainerPosition
+		return nil
+	}
+	return errors.Errorf("not a valid container position: %s", position)
+}
+
+// These are the different placements of the injected containers.
+const (
+	// AppendContainerPosition adds the injected containers after the app containers.
+	AppendContainerPosition ContainerPosition = iota
+	// PrependContainerPosition adds the injected containers before the app containers.
+	PrependContainerPosition
+	// NativeContainerPosition adds the injected containers as native sidecars (init containers with restartPolicy: Always)
+	// after the injected init containers.
+	NativeContainerPosition
+)
+
+// KeyPEMFormat defines the encoding of the self signed certificate key.
+type KeyPEMFormat uint8
+
+// Decode takes a string format and returns the KeyPEMFormat constant.
+func (f *KeyPEMFormat) Decode(format string) error {
+	switch strings.ToLower(format) {
+	case "pkcs1":
+		*f = PKCS1KeyPEMFormat
+		return nil
+	case "pkcs8":
+		*f = PKCS8KeyPEMFormat
+		return nil
+	}
+	return errors.Errorf("not a valid key PEM format: %s", format)
+}
+
+// These are the different encodings of the private key.
+const (
+	// PKCS1KeyPEMFormat encodes the key as PKCS#1 "RSA PRIVATE KEY" PEM block.
+	PKCS1KeyPEMFormat KeyPEMFormat = iota
+	// PKCS8KeyPEMFormat encodes the key as PKCS#8 "PRIVATE KEY" PEM block.
+	PKCS8KeyPEMFormat
+)
+
+// LargeObjectPolicy defines how the objects exceeding Config.LargeObjectBytes or Config.LargeObjectContainers are handled.
+type LargeObjectPolicy uint8
+
+// Decode takes a string policy and returns the LargeObjectPolicy constant.
+func (p *LargeObjectPolicy) Decode(policy string) error {
+	switch strings.ToLower(policy) {
+	case "process":
+		*p = ProcessLargeObjectPolicy
+		return nil
+	case "skip":
+		*p = SkipLargeObjectPolicy
+		return nil
+	}
+	return errors.Errorf("not a valid large object policy: %s", policy)
+}
+
+// These are the different ways to handle the large objects.
+const (
+	// ProcessLargeObjectPolicy mutates the object as usual.
+	ProcessLargeObjectPolicy LargeObjectPolicy = iota
+	// SkipLargeObjectPolicy admits the object without mutation.
+	SkipLargeObjectPolicy
+)
+
+// LimitPolicy defines how the resources exceeding Config.MaxInjectedContainers are handled.
+type LimitPolicy uint8
+
+// Decode takes a string policy and returns the LimitPolicy constant.
+func (p *LimitPolicy) Decode(policy string) error {
+	switch strings.ToLower(policy) {
+	case "reject":
+		*p = RejectLimitPolicy
+		return nil
+	case "truncate":
+		*p = TruncateLimitPolicy
+		return nil
+	}
+	return errors.Errorf("not a valid limit policy: %s", policy)
+}
+
+// These are the different ways to handle the exceeded limit.
+const (
+	// RejectLimitPolicy denies the resource.
+	RejectLimitPolicy LimitPolicy = iota
+	// TruncateLimitPolicy injects only the containers within the limit, init containers first.
+	TruncateLimitPolicy
+)
+
+// TopologySpreadConstraints is a list of topology spread constraints decoded from JSON.
+type TopologySpreadConstraints []corev1.TopologySpreadConstraint
+
+// Decode takes a JSON list and returns TopologySpreadConstraints.
+func (t *TopologySpreadConstraints) Decode(value string) error {
+	if strings.TrimSpace(value) == "" {
+		*t = nil
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal([]byte(value), (*[]corev1.TopologySpreadConstraint)(t)), "not a valid topology spread constraints list")
+}
+
+// Probe is a container probe decoded from JSON.
+type Probe corev1.Probe
+
+// Decode takes a JSON object and returns Probe.
+func (p *Probe) Decode(value string) error {
+	return errors.Wrap(json.Unmarshal([]byte(value), (*corev1.Probe)(p)), "not a valid probe")
+}
+
+// Get returns a copy of the probe or nil if it's not set. envconfig allocates the pointers to structs, so the probe
+// that is not set is empty rather than nil.
+func (p *Probe) Get() *corev1.Probe {
+	if p == nil || *p == (Probe{}) {
+		return nil
+	}
+	return (*corev1.Probe)(p).DeepCopy()
+}
+
+// validate checks that the probe has exactly one handler.
+func (p *Probe) validate(name string) error {
+	if p.Get() == nil {
+		return nil
+	}
+	var handlers int
+	for _, set := range []bool{p.Exec != nil, p.HTTPGet != nil, p.TCPSocket != nil, p.GRPC != nil} {
+		if set {
+			handlers++
+		}
+	}
+	if handlers != 1 {
+		return errors.Errorf("not a valid %s: exactly one of exec, httpGet, tcpSocket and grpc is expected", name)
+	}
+	if p.HTTPGet != nil && p.HTTPGet.Port.IntValue() == 0 && p.HTTPGet.Port.StrVal == "" {
+		return errors.Errorf("not a valid %s: httpGet port is required", name)
+	}
+	if p.TCPSocket != nil && p.TCPSocket.Port.IntValue() == 0 && p.TCPSocket.Port.StrVal == "" {
+		return errors.Errorf("not a valid %s: tcpSocket port is required", name)
+	}
+	return nil
+}
+
+// LifecycleHandler is a container lifecycle hook decoded from JSON.
+type LifecycleHandler corev1.LifecycleHandler
+
+// Decode takes a JSON object and returns LifecycleHandler.
+func (h *LifecycleHandler) Decode(value string) error {
+	return errors.Wrap(json.Unmarshal([]byte(value), (*corev1.LifecycleHandler)(h)), "not a valid lifecycle handler")
+}
+
+// Get returns a copy of the lifecycle handler or nil if it's not set. envconfig allocates the pointers to structs, so
+// the lifecycle handler that is not set is empty rather than nil.
+func (h *LifecycleHandler) Get() *corev1.LifecycleHandler {
+	if h == nil || *h == (LifecycleHandler{}) {
+		return nil
+	}
+	return (*corev1.LifecycleHandler)(h).DeepCopy()
+}
+
+// validate checks that the lifecycle handler has exactly one of exec and httpGet.
+func (h *LifecycleHandler) validate(name string) error {
+	if h.Get() == nil {
+		return nil
+	}
+	if (h.Exec == nil) == (h.HTTPGet == nil) || h.TCPSocket != nil {
+		return errors.Errorf("not a valid %s: exactly one of exec and httpGet is expected", name)
+	}
+	if h.Exec != nil && len(h.Exec.Command) == 0 {
+		return errors.Errorf("not a valid %s: exec command is required", name)
+	}
+	if h.HTTPGet != nil && h.HTTPGet.Port.IntValue() == 0 && h.HTTPGet.Port.StrVal == "" {
+		return errors.Errorf("not a valid %s: httpGet port is required", name)
+	}
+	return nil
+}
+
 // GetOrResolveEnvs converts on the first call passed Config.Envs into []corev1.EnvVar or returns parsed values.
 func (c *Config) GetOrResolveEnvs() []corev1.EnvVar {
-	c.once.Do(c.initialize)
+	c.mustInit()
 	return c.envs
 }
 
-// GetOrResolveCABundle tries to lookup CA bundle from passed Config.CABundleFilePath or returns ca bundle from self signed in memory certificate.
+// GetOrResolveEnvFrom converts on the first call passed Config.EnvFromConfigMaps and Config.EnvFromSecrets into []corev1.EnvFromSource or returns converted values.
+func (c *Config) GetOrResolveEnvFrom() []corev1.EnvFromSource {
+	c.mustInit()
+	return c.envFrom
+}
+
+// GetOrResolveInitContainerImages returns Config.InitContainerImages with Config.DefaultImageTag applied to untagged images.
+func (c *Config) GetOrResolveInitContainerImages() []string {
+	c.mustInit()
+	return c.initContainerImages
+}
+
+// GetOrResolveContainerImages returns Config.ContainerImages with Config.DefaultImageTag applied to untagged images.
+func (c *Config) GetOrResolveContainerImages() []string {
+	c.mustInit()
+	return c.containerImages
+}
+
+// GetOrResolvePodSelector parses on the first call passed Config.InjectPodSelector or returns parsed selector.
+func (c *Config) GetOrResolvePodSelector() labels.Selector {
+	c.mustInit()
+	return c.podSelector
+}
+
+// GetOrResolveCABundle tries to lookup CA bundle from passed Config.CABundleFilePath, Config.CABundleDir and
+// Config.CABundleBase64 or returns ca bundle from self signed in memory certificate.
+// During Config.CARotationWindow the result also contains CA bundles passed to TrustPreviousCABundle.
 func (c *Config) GetOrResolveCABundle() []byte {
-	c.once.Do(c.initialize)
-	return c.caBundle
+	c.mustInit()
+	c.caBundleMutex.Lock()
+	defer c.caBundleMutex.Unlock()
+	if len(c.previousCABundle) == 0 || time.Now().After(c.previousCABundleExpiry) {
+		return c.caBundle
+	}
+	return appendPEM(append([]byte(nil), c.caBundle...), c.previousCABundle)
+}
+
+// TrustPreviousCABundle keeps caBundle of the previous admission webhook instance in the result of GetOrResolveCABundle
+// for Config.CARotationWindow, so the requests served by the previous instance still can be verified.
+func (c *Config) TrustPreviousCABundle(caBundle []byte) {
+	if c.CARotationWindow <= 0 || len(caBundle) == 0 {
+		return
+	}
+	c.mustInit()
+	c.caBundleMutex.Lock()
+	defer c.caBundleMutex.Unlock()
+	if bytes.Contains(c.caBundle, caBundle) || bytes.Contains(c.previousCABundle, caBundle) {
+		return
+	}
+	c.previousCABundle = appendPEM(c.previousCABundle, caBundle)
+	c.previousCABundleExpiry = time.Now().Add(c.CARotationWindow)
+}
+
+// appendPEM appends PEM encoded data to the PEM encoded bundle.
+func appendPEM(bundle, data []byte) []byte {
+	if len(bundle) > 0 && !bytes.HasSuffix(bundle, []byte("\n")) {
+		bundle = append(bundle, '\n')
+	}
+	return append(bundle, data...)
 }
 
 // GetOrResolveCertificate tries to create certificate from Config.CertFilePath, Config.KeyFilePath or creates self signed in memory certificate.
 func (c *Config) GetOrResolveCertificate() tls.Certificate {
-	c.once.Do(c.initialize)
+	c.mustInit()
 	return c.cert
 }
 
+// NoResources is the value of the resources overrides that omits the resources.
+const NoResources = "none"
+
+var (
+	imageNameRegexp   = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::[0-9]+)?/)?[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	imageTagRegexp    = regexp.MustCompile(`^\w[\w.-]{0,127}$`)
+	imageDigestRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-fA-F0-9]{32,}$`)
+)
+
+// OverrideResources returns a copy of resources overridden by v, e.g. "cpu=500m,memory=128Mi" or
+// "requests.cpu=100m,limits.cpu=500m". "none" omits the resources.
+func OverrideResources(resources corev1.ResourceRequirements, v string) (corev1.ResourceRequirements, error) {
+	if strings.TrimSpace(v) == NoResources {
+		return corev1.ResourceRequirements{}, nil
+	}
+	overridden := *resources.DeepCopy()
+	if overridden.Limits == nil {
+		overridden.Limits = corev1.ResourceList{}
+	}
+	if overridden.Requests == nil {
+		overridden.Requests = corev1.ResourceList{}
+	}
+	for _, kv := range strings.Split(v, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(kv), "=")
+		quantity, err := resource.ParseQuantity(value)
+		if !found || err != nil {
+			return resources, errors.Wrapf(ErrInvalidQuantity, "malformed resources %q", v)
+		}
+		switch key {
+		case "cpu", "memory":
+			overridden.Requests[corev1.ResourceName(key)] = quantity
+			overridden.Limits[corev1.ResourceName(key)] = quantity
+		case "requests.cpu", "requests.memory":
+			overridden.Requests[corev1.ResourceName(strings.TrimPrefix(key, "requests."))] = quantity
+		case "limits.cpu", "limits.memory":
+			overridden.Limits[corev1.ResourceName(strings.TrimPrefix(key, "limits."))] = quantity
+		default:
+			return resources, errors.Errorf("unknown resource %q", key)
+		}
+	}
+	return overridden, nil
+}
+
+// ParseVolumeMounts parses the volume mounts in the format "volume:mountPath".
+func ParseVolumeMounts(values []string) ([]corev1.VolumeMount, error) {
+	var result []corev1.VolumeMount
+	for _, v := range values {
+		name, mountPath, found := strings.Cut(strings.TrimSpace(v), ":")
+		if !found || len(validation.IsDNS1123Label(name)) > 0 || !path.IsAbs(mountPath) {
+			return nil, errors.Errorf("not a valid volume mount: %s: volume:mountPath with absolute mountPath is expected", v)
+		}
+		result = append(result, corev1.VolumeMount{Name: name, MountPath: path.Clean(mountPath)})
+	}
+	return result, nil
+}
+
+// ParseGroupVersionKind parses "group/version/kind" or "version/kind" of the core group.
+func ParseGroupVersionKind(v string) (schema.GroupVersionKind, error) {
+	i := strings.LastIndex(v, "/")
+	if i <= 0 || i == len(v)-1 {
+		return schema.GroupVersionKind{}, errors.Errorf("not a valid group/version/kind: %s", v)
+	}
+	gv, err := schema.ParseGroupVersion(v[:i])
+	if err != nil || gv.Version == "" {
+		return schema.GroupVersionKind{}, errors.Errorf("not a valid group/version/kind: %s", v)
+	}
+	return gv.WithKind(v[i+1:]), nil
+}
+
+// ParseImageReference splits the image reference into name, tag and digest. Tag and digest are empty if absent.
+func ParseImageReference(img string) (name, tag, digest string, err error) {
+	name = img
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, digest = name[:i], name[i+1:]
+		if !imageDigestRegexp.MatchString(digest) {
+			return "", "", "", errors.Wrapf(ErrInvalidImage, "%s: malformed digest", img)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+		if !imageTagRegexp.MatchString(tag) {
+			return "", "", "", errors.Wrapf(ErrInvalidImage, "%s: malformed tag", img)
+		}
+	}
+	if !imageNameRegexp.MatchString(name) {
+		return "", "", "", errors.Wrapf(ErrInvalidImage, "%s: malformed name", img)
+	}
+	return name, tag, digest, nil
+}
+
+// SupportedResources is the list of resources that can be handled by admission webhook.
+var SupportedResources = []string{"pods", "deployments", "statefulsets", "daemonsets", "replicasets"}
+
+// IsResourceEnabled returns true if resource is listed in Config.EnabledResources.
+func (c *Config) IsResourceEnabled(resource string) bool {
+	return containsFold(c.EnabledResources, resource)
+}
+
+// IsSubresourceIgnored returns true if subresource is listed in Config.IgnoredSubresources.
+func (c *Config) IsSubresourceIgnored(subresource string) bool {
+	return containsFold(c.IgnoredSubresources, subresource)
+}
+
+// IsContainerProtected returns true if the container is listed in Config.ProtectedContainers.
+func (c *Config) IsContainerProtected(name string) bool {
+	for _, protected := range c.ProtectedContainers {
+		if protected == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that Config has consistent values.
+func (c *Config) Validate() error {
+	for _, validate := range []func() error{
+		c.validateResources,
+		c.validateImages,
+		c.validateEnvs,
+		c.validateTopologySpreadConstraints,
+		c.validateProjectedSAToken,
+		c.validateSidecars,
+		c.validateCertificate,
+		c.validateCertificateSources,
+		c.validateWebhook,
+		c.validateTimeouts,
+		c.validateAPI,
+		c.validateMetadata,
+	} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateResources() error {
+	for _, r := range c.EnabledResources {
+		if !containsFold(SupportedResources, r) {
+			return errors.Errorf("not a supported resource: %s, supported resources are: %v", r, strings.Join(SupportedResources, ","))
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateImages() error {
+	for _, img := range append(append([]string(nil), c.InitContainerImages...), c.ContainerImages...) {
+		_, tag, digest, err := ParseImageReference(img)
+		if err != nil {
+			return err
+		}
+		if tag == "" && digest == "" && c.RejectUntaggedImages {
+			return errors.Errorf("image %s has neither tag nor digest", img)
+		}
+	}
+	if c.DefaultImageTag != "" && !imageTagRegexp.MatchString(c.DefaultImageTag) {
+		return errors.Errorf("not a valid image tag: %s", c.DefaultImageTag)
+	}
+	if c.InjectSpireWaitInitContainer {
+		if _, _, _, err := ParseImageReference(c.SpireWaitImage); err != nil {
+			return err
+		}
+		if c.SpireWaitTimeout < time.Second {
+			return errors.Errorf("not a valid SPIRE wait timeout: %v, at least 1s is expected", c.SpireWaitTimeout)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateEnvs() error {
+	for _, envRaw := range c.Envs {
+		if _, _, err := parseEnv(envRaw); err != nil {
+			return err
+		}
+	}
+	for container, envName := range c.NSURLEnvNames {
+		if errs := validation.IsEnvVarName(envName); len(errs) > 0 {
+			return errors.Errorf("not a valid NSURL env name for %s: %s: %s", container, envName, strings.Join(errs, "; "))
+		}
+	}
+	if _, err := ParseVolumeMounts(c.AppContainerVolumeMounts); err != nil {
+		return err
+	}
+	for label, envName := range c.LabelToEnvMapping {
+		if errs := validation.IsQualifiedName(label); len(errs) > 0 {
+			return errors.Errorf("not a valid label key: %s: %s", label, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsEnvVarName(envName); len(errs) > 0 {
+			return errors.Errorf("not a valid env name for label %s: %s: %s", label, envName, strings.Join(errs, "; "))
+		}
+	}
+	for _, name := range append(append([]string(nil), c.EnvFromConfigMaps...), c.EnvFromSecrets...) {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return errors.Errorf("not a valid env source name: %s: %s", name, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateTopologySpreadConstraints() error {
+	for i := range c.InjectTopologySpreadConstraints {
+		constraint := &c.InjectTopologySpreadConstraints[i]
+		if constraint.TopologyKey == "" || constraint.MaxSkew <= 0 {
+			return errors.Errorf("not a valid topology spread constraint #%d: topologyKey and positive maxSkew are required", i)
+		}
+		if constraint.WhenUnsatisfiable != corev1.DoNotSchedule && constraint.WhenUnsatisfiable != corev1.ScheduleAnyway {
+			return errors.Errorf("not a valid topology spread constraint #%d: unsupported whenUnsatisfiable %s", i, constraint.WhenUnsatisfiable)
+		}
+		if _, err := metav1.LabelSelectorAsSelector(constraint.LabelSelector); err != nil {
+			return errors.Wrapf(err, "not a valid topology spread constraint #%d", i)
+		}
+	}
+	return nil
+}
+
+// validateProjectedSAToken checks the projected service account token settings if Config.InjectProjectedSATokenVolume is set.
+func (c *Config) validateProjectedSAToken() error {
+	if !c.InjectProjectedSATokenVolume {
+		return nil
+	}
+	if c.ProjectedSATokenAudience == "" {
+		return errors.New("projected service account token audience is required")
+	}
+	// The apiserver requires at least 10 minutes
+	if c.ProjectedSATokenExpirationSeconds < 600 {
+		return errors.Errorf("projected service account token expiration %ds is less than 600s", c.ProjectedSATokenExpirationSeconds)
+	}
+	if !path.IsAbs(c.ProjectedSATokenMountPath) {
+		return errors.Errorf("projected service account token mount path %s is not absolute", c.ProjectedSATokenMountPath)
+	}
+	return nil
+}
+
+func (c *Config) validateSidecars() error {
+	for _, value := range []string{c.SidecarLimitsMemory, c.SidecarLimitsCPU, c.SidecarRequestsMemory, c.SidecarRequestsCPU} {
+		if _, err := resource.ParseQuantity(value); err != nil {
+			return errors.Wrapf(ErrInvalidQuantity, "sidecar resources %q", value)
+		}
+	}
+	if err := c.SidecarReadinessProbe.validate("sidecar readiness probe"); err != nil {
+		return err
+	}
+	if err := c.SidecarLivenessProbe.validate("sidecar liveness probe"); err != nil {
+		return err
+	}
+	if err := c.SidecarStartupProbe.validate("sidecar startup probe"); err != nil {
+		return err
+	}
+	if c.SidecarStartupProbe != nil && c.SidecarStartupProbe.SuccessThreshold > 1 {
+		return errors.New("not a valid sidecar startup probe: successThreshold must be 1")
+	}
+	if err := c.SidecarPreStop.validate("sidecar preStop hook"); err != nil {
+		return err
+	}
+	if c.MinTerminationGracePeriodSeconds < 0 {
+		return errors.Errorf("not a valid min termination grace period: %ds", c.MinTerminationGracePeriodSeconds)
+	}
+	if c.PatchSizeWarningBytes < 0 {
+		return errors.Errorf("not a valid patch size warning bytes: %d", c.PatchSizeWarningBytes)
+	}
+	if c.LargeObjectBytes < 0 || c.LargeObjectContainers < 0 {
+		return errors.Errorf("not a valid large object thresholds: %d bytes, %d containers", c.LargeObjectBytes, c.LargeObjectContainers)
+	}
+	if c.MaxInjectedContainers < 0 {
+		return errors.Errorf("not a valid max injected containers: %d", c.MaxInjectedContainers)
+	}
+	if c.DaemonSetSidecarResources != "" {
+		if _, err := OverrideResources(corev1.ResourceRequirements{}, c.DaemonSetSidecarResources); err != nil {
+			return errors.Wrap(err, "not a valid daemon set sidecar resources")
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateCertificate() error {
+	if c.CABundleBase64 != "" {
+		if _, err := decodeCABundle(c.CABundleBase64); err != nil {
+			return err
+		}
+	}
+	if c.SpireReconnectBackoff <= 0 || c.SpireReconnectMaxInterval < c.SpireReconnectBackoff {
+		return errors.Errorf("not a valid spire reconnect backoff: %v, max interval: %v", c.SpireReconnectBackoff, c.SpireReconnectMaxInterval)
+	}
+	if c.SpireSVIDWaitTimeout < 0 {
+		return errors.Errorf("not a valid spire SVID wait timeout: %v", c.SpireSVIDWaitTimeout)
+	}
+	if c.ClockSkewTolerance < 0 {
+		return errors.Errorf("not a valid clock skew tolerance: %v", c.ClockSkewTolerance)
+	}
+	if c.CertRotationInterval < 0 {
+		return errors.Errorf("not a valid certificate rotation interval: %v", c.CertRotationInterval)
+	}
+	if len(c.CertExtKeyUsages) == 0 {
+		return errors.New("at least one certificate extended key usage is required")
+	}
+	for _, name := range c.CertExtKeyUsages {
+		if _, ok := extKeyUsagesByName[strings.ToLower(name)]; !ok {
+			return errors.Errorf("not a valid certificate extended key usage: %s, supported usages are: serverAuth,clientAuth", name)
+		}
+	}
+	return nil
+}
+
+// certificateSourcesPrecedence documents how the serving certificate is chosen.
+const certificateSourcesPrecedence = "the serving certificate is taken from Config.CertFilePath and Config.KeyFilePath if both are set, " +
+	"otherwise from SPIRE in spire mode or generated self signed in selfregister mode"
+
+// validateCertificateSources rejects the certificate sources that would be silently ignored.
+func (c *Config) validateCertificateSources() error {
+	if (c.CertFilePath == "") != (c.KeyFilePath == "") {
+		return errors.Errorf("certificate file path and key file path must be set together: %s", certificateSourcesPrecedence)
+	}
+	if !c.AllowSelfSignedFallback && !c.IsExistingCertificatesUsed() && c.WebhookMode == SelfregisterMode {
+		return errors.Errorf("certificate file path and key file path are required when self signed fallback is not allowed: %s", certificateSourcesPrecedence)
+	}
+	caBundleSet := c.CABundleFilePath != "" || c.CABundleDir != "" || c.CABundleBase64 != ""
+	if caBundleSet && !c.IsExistingCertificatesUsed() && c.WebhookMode == SelfregisterMode {
+		return errors.Errorf("CA bundle can't be used with the self signed certificate: %s", certificateSourcesPrecedence)
+	}
+	return nil
+}
+
+func (c *Config) validateWebhook() error {
+	if c.ServerPort < 1 || c.ServerPort > 65535 {
+		return errors.Errorf("not a valid server port: %d", c.ServerPort)
+	}
+	if net.ParseIP(c.ListenAddress) == nil {
+		return errors.Errorf("not a valid listen address: %s", c.ListenAddress)
+	}
+	if errs := validation.IsDNS1123Subdomain(c.WebhookConfigName); c.WebhookConfigName != "" && len(errs) > 0 {
+		return errors.Errorf("not a valid webhook config name %s: %s", c.WebhookConfigName, strings.Join(errs, ", "))
+	}
+	if c.RegisterRetryAttempts < 1 {
+		return errors.Errorf("not a valid register retry attempts: %d", c.RegisterRetryAttempts)
+	}
+	if c.MatchPolicy != "Equivalent" && c.MatchPolicy != "Exact" {
+		return errors.Errorf("not a valid match policy: %s", c.MatchPolicy)
+	}
+	if c.WebhookURL != "" {
+		if u, err := url.Parse(c.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.Errorf("not a valid webhook URL: %s: HTTPS URL is expected", c.WebhookURL)
+		}
+	}
+	return nil
+}
+
+// validateTimeouts checks that the handler deadline expires before the apiserver stops waiting for the response.
+func (c *Config) validateTimeouts() error {
+	if c.WebhookTimeoutSeconds < 1 || c.WebhookTimeoutSeconds > 30 {
+		return errors.Errorf("not a valid webhook timeout: %ds, 1-30s is expected", c.WebhookTimeoutSeconds)
+	}
+	if webhookTimeout := time.Duration(c.WebhookTimeoutSeconds) * time.Second; c.HandlerTimeout < 0 || c.HandlerTimeout >= webhookTimeout {
+		return errors.Errorf("not a valid handler timeout: %v, less than the webhook timeout %v is expected", c.HandlerTimeout, webhookTimeout)
+	}
+	return nil
+}
+
+// validateAPI checks the versions and kinds of the apiserver API.
+func (c *Config) validateAPI() error {
+	if c.RequireCRD != "" {
+		if _, err := ParseGroupVersionKind(c.RequireCRD); err != nil {
+			return err
+		}
+	}
+	if len(c.AdmissionReviewVersions) == 0 {
+		return errors.New("at least one admission review version is required")
+	}
+	listed := make(map[string]bool, len(c.AdmissionReviewVersions))
+	for _, v := range c.AdmissionReviewVersions {
+		if v != "v1" && v != "v1beta1" {
+			return errors.Errorf("not a valid admission review version: %s, supported versions are: v1,v1beta1", v)
+		}
+		if listed[v] {
+			return errors.Errorf("duplicated admission review version: %s", v)
+		}
+		listed[v] = true
+	}
+	return nil
+}
+
+func (c *Config) validateMetadata() error {
+	if c.InjectionPercentage < 0 || c.InjectionPercentage > 100 {
+		return errors.Errorf("not a valid injection percentage: %d", c.InjectionPercentage)
+	}
+	for _, name := range c.ProtectedContainers {
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			return errors.Errorf("not a valid protected container name %s: %s", name, strings.Join(errs, ", "))
+		}
+	}
+	if errs := validation.IsDNS1123Subdomain(c.RequireServiceAccount); c.RequireServiceAccount != "" && len(errs) > 0 {
+		return errors.Errorf("not a valid service account name %s: %s", c.RequireServiceAccount, strings.Join(errs, ", "))
+	}
+	if _, err := labels.Parse(c.InjectPodSelector); err != nil {
+		return errors.Wrapf(err, "not a valid pod selector: %s", c.InjectPodSelector)
+	}
+	for key := range c.InjectAnnotations {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return errors.Errorf("not a valid annotation key: %s: %s", key, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// ConsistencyWarnings cross-checks the configured images and envs and returns descriptions of found misconfigurations.
+func (c *Config) ConsistencyWarnings() []string {
+	var warnings []string
+	if len(c.InitContainerImages) == 0 && len(c.ContainerImages) == 0 {
+		warnings = append(warnings, "neither init container images nor container images are configured, resources get no NSM containers")
+	}
+
+	reserved := map[string]bool{c.NSURLEnvName: true, "NSM_NAME": true, "POD_NAME": true, "SPIFFE_ENDPOINT_SOCKET": true}
+	for _, envRaw := range c.Envs {
+		// The format of Config.Envs is checked by Validate
+		name, _, _ := strings.Cut(envRaw, "=")
+		if reserved[name] {
+			warnings = append(warnings, fmt.Sprintf("env %s is set by admission webhook and shouldn't be configured", name))
+		}
+	}
+
+	var names []string
+	for _, img := range append(append([]string(nil), c.InitContainerImages...), c.ContainerImages...) {
+		names = append(names, strings.Split(path.Base(img), ":")[0])
+	}
+	for container := range c.NSURLEnvNames {
+		if !containsFold(names, container) {
+			warnings = append(warnings, fmt.Sprintf("NSURL env name is configured for %s, but there is no such injected container", container))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// handlerTimeoutMargin is the time left to respond with Config.OnMutationError after the handler deadline.
+const handlerTimeoutMargin = time.Second
+
+// EffectiveHandlerTimeout returns Config.HandlerTimeout or, if it's not set, Config.WebhookTimeoutSeconds minus the
+// margin to respond before the apiserver gives up. The deadline isn't set for the timeouts of 1s.
+func (c *Config) EffectiveHandlerTimeout() time.Duration {
+	if c.HandlerTimeout > 0 {
+		return c.HandlerTimeout
+	}
+	return time.Duration(c.WebhookTimeoutSeconds)*time.Second - handlerTimeoutMargin
+}
+
 // IsExistingCertificatesUsed specifies whether user-provided certificates should be used.
 func (c *Config) IsExistingCertificatesUsed() bool {
 	return c.CertFilePath != "" && c.KeyFilePath != ""
 }
 
+// ServerAddress returns the address the admission webhook server listens on. Its port is the port of the service
+// reference in the registered MutatingWebhookConfiguration.
+func (c *Config) ServerAddress() string {
+	return net.JoinHostPort(c.ListenAddress, strconv.Itoa(c.ServerPort))
+}
+
+// Init resolves on the first call the values returned by the GetOrResolve methods: envs, images, the pod selector, the
+// certificate and the CA bundle. It returns the error of the first call on every call. The GetOrResolve methods panic
+// on this error, so Init is expected to be called after Validate.
+func (c *Config) Init() error {
+	c.once.Do(c.initialize)
+	return c.initErr
+}
+
+// mustInit calls Init and panics on error.
+func (c *Config) mustInit() {
+	if err := c.Init(); err != nil {
+		panic(err.Error())
+	}
+}
+
 func (c *Config) initialize() {
-	c.initializeEnvs()
-	c.initializeCert()
-	c.initializeCABundle()
+	if c.initErr = c.initializeEnvs(); c.initErr != nil {
+		return
+	}
+	c.initializeEnvFrom()
+	c.initContainerImages = c.withDefaultImageTag(c.InitContainerImages)
+	c.containerImages = c.withDefaultImageTag(c.ContainerImages)
+	if c.initErr = c.initializePodSelector(); c.initErr != nil {
+		return
+	}
+	if c.initErr = c.initializeCert(); c.initErr != nil {
+		return
+	}
+	c.initErr = c.initializeCABundle()
 }
 
-func (c *Config) initializeEnvs() {
+// downwardAPIEnvs contains field paths of the envs that can be referenced in Config.Envs values.
+var downwardAPIEnvs = map[string]string{
+	"POD_NAME":      "metadata.name",
+	"POD_NAMESPACE": "metadata.namespace",
+	"POD_IP":        "status.podIP",
+	"NODE_NAME":     "spec.nodeName",
+}
+
+// parseEnv parses the entry of Config.Envs in NAME=VALUE form. The value may contain '=' itself, e.g. A=b=c.
+func parseEnv(envRaw string) (name, value string, err error) {
+	name, value, found := strings.Cut(envRaw, "=")
+	if !found {
+		return "", "", errors.Wrapf(ErrInvalidEnv, "%q: NAME=VALUE is expected", envRaw)
+	}
+	if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+		return "", "", errors.Wrapf(ErrInvalidEnv, "%q: %s", name, strings.Join(errs, "; "))
+	}
+	return name, value, nil
+}
+
+func (c *Config) initializeEnvs() error {
+	var userEnvs []corev1.EnvVar
+	defined := make(map[string]bool)
 	for _, envRaw := range c.Envs {
-		kv := strings.Split(envRaw, "=")
-		c.envs = append(c.envs, corev1.EnvVar{
-			Name:  kv[0],
-			Value: kv[1],
+		name, value, err := parseEnv(envRaw)
+		if err != nil {
+			return err
+		}
+		userEnvs = append(userEnvs, corev1.EnvVar{
+			Name:  name,
+			Value: value,
 		})
+		defined[name] = true
 	}
-	c.envs = append(c.envs,
-		corev1.EnvVar{
-			Name:  "SPIFFE_ENDPOINT_SOCKET",
-			Value: "unix:///run/spire/sockets/agent.sock",
-		},
-		corev1.EnvVar{
-			Name: "POD_NAME",
-			ValueFrom: &corev1.EnvVarSource{
-				FieldRef: &corev1.ObjectFieldSelector{
-					FieldPath: "metadata.name",
-				},
+	// k8s expands $(VAR) only if VAR is defined before the referencing env
+	for _, name := range []string{"POD_NAME", "POD_NAMESPACE", "POD_IP", "NODE_NAME"} {
+		if defined[name] {
+			continue
+		}
+		for i := range userEnvs {
+			if strings.Contains(userEnvs[i].Value, "$("+name+")") {
+				c.envs = append(c.envs, downwardAPIEnv(name))
+				defined[name] = true
+				break
+			}
+		}
+	}
+	c.envs = append(c.envs, userEnvs...)
+	c.envs = append(c.envs, corev1.EnvVar{
+		Name:  "SPIFFE_ENDPOINT_SOCKET",
+		Value: "unix:///run/spire/sockets/agent.sock",
+	})
+	if !defined["POD_NAME"] {
+		c.envs = append(c.envs, downwardAPIEnv("POD_NAME"))
+	}
+	return nil
+}
+
+// downwardAPIEnv returns the env with the value from the downward API field of downwardAPIEnvs.
+func downwardAPIEnv(name string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				FieldPath: downwardAPIEnvs[name],
 			},
 		},
-	)
+	}
+}
+
+func (c *Config) initializeEnvFrom() {
+	configMaps := make(map[string]bool)
+	for _, name := range c.EnvFromConfigMaps {
+		if configMaps[name] {
+			continue
+		}
+		configMaps[name] = true
+		c.envFrom = append(c.envFrom, corev1.EnvFromSource{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			},
+		})
+	}
+	secrets := make(map[string]bool)
+	for _, name := range c.EnvFromSecrets {
+		if secrets[name] {
+			continue
+		}
+		secrets[name] = true
+		c.envFrom = append(c.envFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			},
+		})
+	}
+}
+
+func (c *Config) withDefaultImageTag(images []string) []string {
+	var result []string
+	for _, img := range images {
+		if _, tag, digest, err := ParseImageReference(img); err == nil && tag == "" && digest == "" && c.DefaultImageTag != "" {
+			img = img + ":" + c.DefaultImageTag
+		}
+		result = append(result, img)
+	}
+	return result
+}
+
+func (c *Config) initializePodSelector() error {
+	selector, err := labels.Parse(c.InjectPodSelector)
+	if err != nil {
+		return errors.Wrapf(err, "not a valid pod selector %q", c.InjectPodSelector)
+	}
+	c.podSelector = selector
+	return nil
 }
 
-func (c *Config) initializeCABundle() {
+// ReloadCABundle reads Config.CABundleFilePath, Config.CABundleDir and Config.CABundleBase64 again and replaces the CA
+// bundle if it's changed. The CA bundle is kept if they can't be read or parsed. It returns true if the CA bundle is replaced.
+func (c *Config) ReloadCABundle() (bool, error) {
+	if err := c.Init(); err != nil {
+		return false, err
+	}
+	r, err := c.readCABundles()
+	if err != nil {
+		return false, err
+	}
+	c.caBundleMutex.Lock()
+	defer c.caBundleMutex.Unlock()
+	if bytes.Equal(c.caBundle, r) {
+		return false, nil
+	}
+	c.caBundle = r
+	return true, nil
+}
+
+func (c *Config) initializeCABundle() error {
 	if c.WebhookMode != SelfregisterMode {
-		return
+		return nil
 	}
 
 	if len(c.caBundle) != 0 {
-		return
+		return nil
 	}
-	r, err := os.ReadFile(c.CABundleFilePath)
+	r, err := c.readCABundles()
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
 	c.caBundle = r
+	return nil
+}
+
+// readCABundles reads Config.CABundleFilePath, Config.CABundleDir and Config.CABundleBase64 and merges them into
+// a single bundle without duplicated certificates. At least one of them is required.
+func (c *Config) readCABundles() ([]byte, error) {
+	var bundles [][]byte
+	if c.CABundleFilePath != "" {
+		r, err := os.ReadFile(c.CABundleFilePath)
+		if os.IsNotExist(err) {
+			return nil, errors.Wrapf(ErrCABundleNotFound, "CA bundle file %s doesn't exist", c.CABundleFilePath)
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if _, err = ParseCABundle(r); err != nil {
+			return nil, errors.Wrapf(err, "invalid CA bundle %s", c.CABundleFilePath)
+		}
+		bundles = append(bundles, r)
+	}
+	if c.CABundleDir != "" {
+		r, err := readCABundleDir(c.CABundleDir)
+		if err != nil {
+			return nil, err
+		}
+		bundles = append(bundles, r)
+	}
+	if c.CABundleBase64 != "" {
+		r, err := decodeCABundle(c.CABundleBase64)
+		if err != nil {
+			return nil, err
+		}
+		bundles = append(bundles, r)
+	}
+	if len(bundles) == 0 {
+		return nil, errors.Wrap(ErrCABundleNotFound, "CA bundle file path, CA bundle directory or base64 CA bundle is required for the certificate file")
+	}
+	return mergeCABundles(bundles...), nil
+}
+
+// mergeCABundles returns the certificates of the PEM encoded bundles. Identical certificates are kept once.
+func mergeCABundles(bundles ...[]byte) []byte {
+	var result []byte
+	seen := make(map[string]bool)
+	for _, bundle := range bundles {
+		for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" || seen[string(block.Bytes)] {
+				continue
+			}
+			seen[string(block.Bytes)] = true
+			result = append(result, pem.EncodeToMemory(block)...)
+		}
+	}
+	return result
+}
+
+// decodeCABundle decodes base64 encoded PEM CA bundle.
+func decodeCABundle(value string) ([]byte, error) {
+	r, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, errors.Wrap(err, "CA bundle is not valid base64")
+	}
+	certs, err := ParseCABundle(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid base64 CA bundle")
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("base64 CA bundle contains no certificates")
+	}
+	return r, nil
+}
+
+// readCABundleDir concatenates .pem and .crt files of dir in the lexical order.
+func readCABundleDir(dir string) ([]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var result []byte
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); entry.IsDir() || (ext != ".pem" && ext != ".crt") {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		r, err := os.ReadFile(filepath.Clean(file))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if _, err = ParseCABundle(r); err != nil {
+			return nil, errors.Wrapf(err, "invalid CA bundle %s", file)
+		}
+		result = appendPEM(result, r)
+	}
+	if len(result) == 0 {
+		return nil, errors.Errorf("CA bundle directory %s contains no .pem or .crt files", dir)
+	}
+	return result, nil
+}
+
+// ParseCABundle parses PEM encoded CA bundle. It fails if the bundle contains no certificates or anything except certificates.
+func ParseCABundle(caBundle []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := caBundle
+	for len(bytes.TrimSpace(rest)) > 0 {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("CA bundle contains data that is not PEM encoded")
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.Errorf("CA bundle contains unexpected PEM block %s", block.Type)
+		}
+		parsed, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "CA bundle contains malformed certificate #%d", len(certs)+1)
+		}
+		certs = append(certs, parsed)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("CA bundle contains no certificates")
+	}
+	return certs, nil
 }
 
-func (c *Config) initializeCert() {
+func (c *Config) initializeCert() error {
 	if c.IsExistingCertificatesUsed() {
-		cert, err := tls.LoadX509KeyPair(c.CertFilePath, c.KeyFilePath)
+		certificate, err := c.loadCertificate()
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-		c.cert = cert
-		return
+		c.cert = certificate
+		return nil
 	}
 
 	if c.WebhookMode == SelfregisterMode {
-		c.cert = c.selfSignedInMemoryCertificate()
+		certificate, err := c.selfSignedInMemoryCertificate()
+		if err != nil {
+			return err
+		}
+		c.cert = certificate
+	}
+	return nil
+}
+
+// loadCertificate reads and validates the pair of Config.CertFilePath and Config.KeyFilePath.
+func (c *Config) loadCertificate() (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(filepath.Clean(c.CertFilePath))
+	if err != nil {
+		return tls.Certificate{}, errors.WithStack(err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Clean(c.KeyFilePath))
+	if err != nil {
+		return tls.Certificate{}, errors.WithStack(err)
 	}
+	result, err := cert.ValidatePair(certPEM, keyPEM, c.CertificateOptions()...)
+	return result, errors.Wrapf(err, "certificate %s is not valid", c.CertFilePath)
 }
 
-func (c *Config) selfSignedInMemoryCertificate() tls.Certificate {
+// CertificateOptions returns the options of cert.ValidatePair for the pair of Config.CertFilePath and
+// Config.KeyFilePath: Config.ClockSkewTolerance and the key and chain policy.
+func (c *Config) CertificateOptions() []cert.Option {
+	return []cert.Option{cert.WithClockSkewTolerance(c.ClockSkewTolerance), cert.WithPolicy(c.checkCertificatePolicy)}
+}
+
+// checkCertificatePolicy checks the key and the chain of the provided certificate against the certificate policy.
+func (c *Config) checkCertificatePolicy(certificate *tls.Certificate) error {
+	if c.MaxCertChainDepth > 0 && len(certificate.Certificate) > c.MaxCertChainDepth {
+		return errors.Errorf("certificate chain %s contains %d certificates, maximum is %d", c.CertFilePath, len(certificate.Certificate), c.MaxCertChainDepth)
+	}
+
+	var keyType string
+	switch key := certificate.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		keyType = "rsa"
+		if bits := key.N.BitLen(); bits < c.MinRSAKeyBits {
+			return errors.Errorf("RSA key %s is too weak: %d bits, minimum is %d", c.KeyFilePath, bits, c.MinRSAKeyBits)
+		}
+	case *ecdsa.PrivateKey:
+		keyType = "ecdsa"
+		if curve := key.Curve.Params().Name; !containsFold(c.AllowedECDSACurves, curve) {
+			return errors.Errorf("ECDSA key %s uses not allowed curve %s, allowed curves are: %s", c.KeyFilePath, curve, strings.Join(c.AllowedECDSACurves, ","))
+		}
+	case ed25519.PrivateKey:
+		keyType = "ed25519"
+	default:
+		return errors.Errorf("key %s has unsupported type %T", c.KeyFilePath, key)
+	}
+	if !containsFold(c.AllowedKeyTypes, keyType) {
+		return errors.Errorf("key %s has not allowed type %s, allowed types are: %s", c.KeyFilePath, keyType, strings.Join(c.AllowedKeyTypes, ","))
+	}
+	return nil
+}
+
+func (c *Config) selfSignedInMemoryCertificate() (tls.Certificate, error) {
 	now := time.Now()
 
 	template := &x509.Certificate{
@@ -187,7 +1543,7 @@ func (c *Config) selfSignedInMemoryCertificate() tls.Certificate {
 		NotAfter:              now.AddDate(1, 0, 0),
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtKeyUsage:           c.extKeyUsages(),
 		KeyUsage: x509.KeyUsageKeyEncipherment |
 			x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
 		DNSNames: []string{
@@ -195,17 +1551,24 @@ func (c *Config) selfSignedInMemoryCertificate() tls.Certificate {
 			fmt.Sprintf("%v.%v.svc", c.ServiceName, c.Namespace),
 		},
 	}
+	if c.IncludePodIPSAN {
+		ip, err := c.podIP()
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		template.IPAddresses = append(template.IPAddresses, ip)
+	}
 
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 
 	if err != nil {
-		panic(err.Error())
+		return tls.Certificate{}, errors.WithStack(err)
 	}
 
 	certRaw, err := x509.CreateCertificate(rand.Reader, template, template, privateKey.Public(), privateKey)
 
 	if err != nil {
-		panic(err.Error())
+		return tls.Certificate{}, errors.WithStack(err)
 	}
 
 	pemCert := pem.EncodeToMemory(&pem.Block{
@@ -213,17 +1576,83 @@ func (c *Config) selfSignedInMemoryCertificate() tls.Certificate {
 		Bytes: certRaw,
 	})
 
-	pemKey := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	})
+	pemKey, err := c.encodePrivateKey(privateKey)
+
+	if err != nil {
+		return tls.Certificate{}, errors.WithStack(err)
+	}
 
 	result, err := tls.X509KeyPair(pemCert, pemKey)
 
 	if err != nil {
-		panic(err.Error())
+		return tls.Certificate{}, errors.WithStack(err)
 	}
 
 	c.caBundle = pemCert
+	return result, nil
+}
+
+// encodePrivateKey returns PEM encoded privateKey according to Config.KeyPEMFormat.
+func (c *Config) encodePrivateKey(privateKey *rsa.PrivateKey) ([]byte, error) {
+	if c.KeyPEMFormat == PKCS1KeyPEMFormat {
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		}), nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	}), nil
+}
+
+// extKeyUsagesByName contains the supported extended key usages of the self signed certificate.
+var extKeyUsagesByName = map[string]x509.ExtKeyUsage{
+	"serverauth": x509.ExtKeyUsageServerAuth,
+	"clientauth": x509.ExtKeyUsageClientAuth,
+}
+
+// extKeyUsages returns the extended key usages from Config.CertExtKeyUsages.
+func (c *Config) extKeyUsages() []x509.ExtKeyUsage {
+	var result []x509.ExtKeyUsage
+	for _, name := range c.CertExtKeyUsages {
+		result = append(result, extKeyUsagesByName[strings.ToLower(name)])
+	}
 	return result
 }
+
+// podIPEnv is the env that contains the IP of the admission webhook pod, usually provided by the downward API.
+const podIPEnv = "POD_IP"
+
+// podIP returns the IP of the admission webhook pod from POD_IP env or from the in-cluster client.
+func (c *Config) podIP() (net.IP, error) {
+	value := os.Getenv(podIPEnv)
+	if value == "" {
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s env is not set and in-cluster config is not available", podIPEnv)
+		}
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		podName, err := os.Hostname()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		pod, err := clientset.CoreV1().Pods(c.Namespace).Get(context.Background(), podName, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get pod %s/%s", c.Namespace, podName)
+		}
+		value = pod.Status.PodIP
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, errors.Errorf("not a valid pod IP: %q", value)
+	}
+	return ip, nil
+}
@@ -0,0 +1,1257 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/cert"
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/config"
+)
+
+// defaultConfig returns Config with the default values. The prefix isn't used by the environment of the tests.
+func defaultConfig(t *testing.T) *config.Config {
+	t.Helper()
+	c := new(config.Config)
+	if err := envconfig.Process("nsm_config_test", c); err != nil {
+		t.Fatalf("failed to process config: %v", err)
+	}
+	return c
+}
+
+// selfSigned returns PEM encoded self signed certificate of key valid from notBefore to notAfter.
+func selfSigned(t *testing.T, key crypto.Signer, notBefore, notAfter time.Time) []byte {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "admission-webhook-svc.nsm-system.svc"},
+		DNSNames:              []string{"admission-webhook-svc.nsm-system.svc"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writePair writes the certificate chain and the PKCS#8 encoded key into the temporary directory and sets them as
+// Config.CertFilePath and Config.KeyFilePath.
+func writePair(t *testing.T, c *config.Config, chain []byte, key crypto.Signer) {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	dir := t.TempDir()
+	c.CertFilePath, c.KeyFilePath = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err = os.WriteFile(c.CertFilePath, chain, 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err = os.WriteFile(c.KeyFilePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+// newKey returns the generated key of the type.
+func newKey(t *testing.T, keyType string) crypto.Signer {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch keyType {
+	case "rsa-1024":
+		key, err = rsa.GenerateKey(rand.Reader, 1024)
+	case "rsa-2048":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa-p224":
+		key, err = ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	case "ecdsa-p256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unknown key type %s", keyType)
+	}
+	if err != nil {
+		t.Fatalf("failed to generate %s key: %v", keyType, err)
+	}
+	return key
+}
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	if err := defaultConfig(t).Validate(); err != nil {
+		t.Fatalf("default config is not valid: %v", err)
+	}
+}
+
+func TestDefaultLimits(t *testing.T) {
+	c := defaultConfig(t)
+	if c.LargeObjectPolicy != config.ProcessLargeObjectPolicy {
+		t.Fatalf("expected large objects to be processed by default, got policy %v", c.LargeObjectPolicy)
+	}
+	if c.MaxInjectedContainers != 0 {
+		t.Fatalf("expected no limit of injected containers by default, got %d", c.MaxInjectedContainers)
+	}
+}
+
+func TestSidecarProbes(t *testing.T) {
+	c := defaultConfig(t)
+	if c.SidecarReadinessProbe.Get() != nil || c.SidecarLivenessProbe.Get() != nil || c.SidecarStartupProbe.Get() != nil {
+		t.Fatal("probes that are not set must be nil")
+	}
+
+	if err := c.SidecarReadinessProbe.Decode(`{"tcpSocket": {"port": 5001}, "periodSeconds": 5}`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	probe := c.SidecarReadinessProbe.Get()
+	if probe == nil || probe.TCPSocket == nil || probe.TCPSocket.Port.IntValue() != 5001 || probe.PeriodSeconds != 5 {
+		t.Fatalf("unexpected probe: %+v", probe)
+	}
+
+	if err := c.SidecarLivenessProbe.Decode(`{"periodSeconds": 5}`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected an error for the probe without handler")
+	}
+}
+
+func TestSidecarStartupProbe(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		probe   string
+		wantErr bool
+	}{
+		{name: "valid", probe: `{"httpGet": {"path": "/healthz", "port": 8080}, "failureThreshold": 30}`},
+		{name: "success threshold", probe: `{"httpGet": {"path": "/healthz", "port": 8080}, "successThreshold": 2}`, wantErr: true},
+		{name: "no handler", probe: `{"failureThreshold": 30}`, wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			if err := c.SidecarStartupProbe.Decode(tc.probe); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := c.Validate(); (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSidecarPreStop(t *testing.T) {
+	c := defaultConfig(t)
+	if c.SidecarPreStop.Get() != nil {
+		t.Fatal("preStop hook that is not set must be nil")
+	}
+
+	if err := c.SidecarPreStop.Decode(`{"exec": {"command": ["sleep", "5"]}}`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hook := c.SidecarPreStop.Get(); hook == nil || hook.Exec == nil || len(hook.Exec.Command) != 2 {
+		t.Fatalf("unexpected preStop hook: %+v", hook)
+	}
+
+	if err := c.SidecarPreStop.Decode(`{"exec": {"command": []}}`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected an error for the preStop hook without command")
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		webhookTimeout int32
+		handlerTimeout time.Duration
+		want           time.Duration
+		wantErr        bool
+	}{
+		{name: "default", webhookTimeout: 10, want: 9 * time.Second},
+		{name: "explicit", webhookTimeout: 10, handlerTimeout: 3 * time.Second, want: 3 * time.Second},
+		{name: "no margin left", webhookTimeout: 1, want: 0},
+		{name: "equal to webhook timeout", webhookTimeout: 10, handlerTimeout: 10 * time.Second, wantErr: true},
+		{name: "greater than webhook timeout", webhookTimeout: 5, handlerTimeout: 6 * time.Second, wantErr: true},
+		{name: "negative", webhookTimeout: 10, handlerTimeout: -time.Second, wantErr: true},
+		{name: "webhook timeout too small", webhookTimeout: 0, wantErr: true},
+		{name: "webhook timeout too large", webhookTimeout: 31, wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.WebhookTimeoutSeconds = tc.webhookTimeout
+			c.HandlerTimeout = tc.handlerTimeout
+			err := c.Validate()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := c.EffectiveHandlerTimeout(); got != tc.want {
+				t.Fatalf("expected handler timeout %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWebhookTimeoutSeconds(t *testing.T) {
+	c := defaultConfig(t)
+	c.WebhookTimeoutSeconds = 7
+	configuration, err := c.BuildMutatingWebhookConfiguration(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := configuration.Webhooks[0].TimeoutSeconds; got == nil || *got != 7 {
+		t.Fatalf("expected timeoutSeconds 7, got %v", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var mode config.Mode
+	if err := mode.Decode("webhook"); !errors.Is(err, config.ErrInvalidMode) {
+		t.Fatalf("expected ErrInvalidMode, got %v", err)
+	}
+
+	if _, _, _, err := config.ParseImageReference("Registry/Image:"); !errors.Is(err, config.ErrInvalidImage) {
+		t.Fatalf("expected ErrInvalidImage, got %v", err)
+	}
+
+	c := defaultConfig(t)
+	c.SidecarLimitsMemory = "lots"
+	if err := c.Validate(); !errors.Is(err, config.ErrInvalidQuantity) {
+		t.Fatalf("expected ErrInvalidQuantity, got %v", err)
+	}
+}
+
+func TestInitErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+
+	c := &config.Config{InjectPodSelector: "app in (nsc"}
+	if err := c.Init(); err == nil {
+		t.Fatal("expected an error for the invalid pod selector")
+	}
+	if err := c.Init(); err == nil {
+		t.Fatal("expected the error of the first call")
+	}
+
+	c = &config.Config{Envs: []string{"NSM_LOG_LEVEL"}}
+	if err := c.Init(); !errors.Is(err, config.ErrInvalidEnv) {
+		t.Fatalf("expected ErrInvalidEnv, got %v", err)
+	}
+
+	c = &config.Config{CertFilePath: missing, KeyFilePath: missing}
+	if err := c.Init(); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+
+	c = &config.Config{WebhookMode: config.SelfregisterMode, ServiceName: "admission-webhook-svc", Namespace: "nsm-system"}
+	if err := c.Init(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.CABundleFilePath = missing
+	_, err := c.ReloadCABundle()
+	if !errors.Is(err, config.ErrCABundleNotFound) || !strings.Contains(err.Error(), missing) {
+		t.Fatalf("expected ErrCABundleNotFound of %s, got %v", missing, err)
+	}
+}
+
+func TestCertificatePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		keyType     string
+		allowed     []string
+		chainDepth  int
+		chainLength int
+		wantErr     bool
+	}{
+		{name: "rsa", keyType: "rsa-2048"},
+		{name: "weak rsa", keyType: "rsa-1024", wantErr: true},
+		{name: "ecdsa", keyType: "ecdsa-p256"},
+		{name: "not allowed curve", keyType: "ecdsa-p224", wantErr: true},
+		{name: "ed25519", keyType: "ed25519"},
+		{name: "not allowed type", keyType: "ed25519", allowed: []string{"rsa", "ecdsa"}, wantErr: true},
+		{name: "chain within depth", keyType: "ed25519", chainDepth: 2, chainLength: 2},
+		{name: "chain exceeds depth", keyType: "ed25519", chainDepth: 2, chainLength: 3, wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.MaxCertChainDepth = tc.chainDepth
+			if tc.allowed != nil {
+				c.AllowedKeyTypes = tc.allowed
+			}
+			key := newKey(t, tc.keyType)
+			now := time.Now()
+			chain := selfSigned(t, key, now.Add(-time.Hour), now.Add(time.Hour))
+			for i := 1; i < tc.chainLength; i++ {
+				chain = append(chain, selfSigned(t, newKey(t, "ed25519"), now.Add(-time.Hour), now.Add(time.Hour))...)
+			}
+			writePair(t, c, chain, key)
+
+			err := c.Init()
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if err == nil && len(c.GetOrResolveCertificate().Certificate) != max(tc.chainLength, 1) {
+				t.Fatalf("unexpected certificate chain length: %d", len(c.GetOrResolveCertificate().Certificate))
+			}
+
+			// The rotated pair is checked against the same policy
+			manager := cert.NewManager(nil, cert.FileSource(c.CertFilePath, c.KeyFilePath), c.CertificateOptions()...)
+			if err = manager.Rotate(context.Background()); tc.wantErr != (err != nil) {
+				t.Fatalf("expected rotation error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDefaultImageTag(t *testing.T) {
+	c := defaultConfig(t)
+	c.DefaultImageTag = "v1.14.0"
+	c.InitContainerImages = []string{"registry:5000/cmd-nsc-init"}
+	c.ContainerImages = []string{"cmd-nsc", "cmd-nsc:v1.13.0", "cmd-nsc@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := c.GetOrResolveInitContainerImages(); len(got) != 1 || got[0] != "registry:5000/cmd-nsc-init:v1.14.0" {
+		t.Fatalf("unexpected init container images: %v", got)
+	}
+	want := []string{"cmd-nsc:v1.14.0", "cmd-nsc:v1.13.0", c.ContainerImages[2]}
+	got := c.GetOrResolveContainerImages()
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("expected container images %v, got %v", want, got)
+		}
+	}
+}
+
+func TestRejectUntaggedImages(t *testing.T) {
+	c := defaultConfig(t)
+	c.RejectUntaggedImages = true
+	c.ContainerImages = []string{"cmd-nsc:v1.14.0"}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c.InitContainerImages = []string{"cmd-nsc-init"}
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected an error for the untagged image")
+	}
+
+	c.DefaultImageTag = "not a tag"
+	c.RejectUntaggedImages = false
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected an error for the invalid default tag")
+	}
+}
+
+func TestMatchPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		matchPolicy string
+		wantErr     bool
+	}{
+		{name: "equivalent", matchPolicy: "Equivalent"},
+		{name: "exact", matchPolicy: "Exact"},
+		{name: "lower case", matchPolicy: "exact", wantErr: true},
+		{name: "empty", matchPolicy: "", wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.MatchPolicy = tc.matchPolicy
+			err := c.Validate()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			configuration, err := c.BuildMutatingWebhookConfiguration(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := configuration.Webhooks[0].MatchPolicy; got == nil || string(*got) != tc.matchPolicy {
+				t.Fatalf("expected matchPolicy %s, got %v", tc.matchPolicy, got)
+			}
+		})
+	}
+}
+
+func TestDefaultMatchPolicy(t *testing.T) {
+	if got := defaultConfig(t).MatchPolicy; got != "Equivalent" {
+		t.Fatalf("expected matchPolicy Equivalent by default, got %s", got)
+	}
+}
+
+func TestWebhookClientConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		webhookURL string
+		wantErr    bool
+	}{
+		{name: "service reference"},
+		{name: "url", webhookURL: "https://webhook.example.com:8443/mutate"},
+		{name: "http url", webhookURL: "http://webhook.example.com/mutate", wantErr: true},
+		{name: "no host", webhookURL: "https:///mutate", wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.ServiceName = "admission-webhook-svc"
+			c.Namespace = "nsm-system"
+			c.WebhookURL = tc.webhookURL
+			err := c.Validate()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			configuration, err := c.BuildMutatingWebhookConfiguration(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			clientConfig := configuration.Webhooks[0].ClientConfig
+			if tc.webhookURL != "" {
+				if clientConfig.Service != nil || clientConfig.URL == nil || *clientConfig.URL != tc.webhookURL {
+					t.Fatalf("expected url %s without service reference, got %+v", tc.webhookURL, clientConfig)
+				}
+				return
+			}
+			service := clientConfig.Service
+			if clientConfig.URL != nil || service == nil {
+				t.Fatalf("expected service reference without url, got %+v", clientConfig)
+			}
+			if service.Namespace != "nsm-system" || service.Name != "admission-webhook-svc" ||
+				service.Path == nil || *service.Path != "/mutate" || service.Port == nil || *service.Port != 443 {
+				t.Fatalf("unexpected service reference: %+v", service)
+			}
+		})
+	}
+}
+
+func TestConsistencyWarnings(t *testing.T) {
+	consistent := defaultConfig(t)
+	consistent.ContainerImages = []string{"ghcr.io/networkservicemesh/cmd-nsc:v1.14.0"}
+	consistent.Envs = []string{"NSM_LOG_LEVEL=DEBUG"}
+	consistent.NSURLEnvNames = map[string]string{"cmd-nsc": "NSM_NETWORK_SERVICE"}
+	if warnings := consistent.ConsistencyWarnings(); len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+
+	inconsistent := defaultConfig(t)
+	inconsistent.Envs = []string{"NSM_NETWORK_SERVICES=kernel://icmp-responder"}
+	inconsistent.NSURLEnvNames = map[string]string{"cmd-nsc-vpp": "NSM_NETWORK_SERVICE"}
+	want := []string{
+		"NSURL env name is configured for cmd-nsc-vpp, but there is no such injected container",
+		"env NSM_NETWORK_SERVICES is set by admission webhook and shouldn't be configured",
+		"neither init container images nor container images are configured, resources get no NSM containers",
+	}
+	if warnings := inconsistent.ConsistencyWarnings(); !reflect.DeepEqual(warnings, want) {
+		t.Fatalf("expected warnings %v, got %v", want, warnings)
+	}
+}
+
+func TestEnvsValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		env     string
+		wantErr string
+	}{
+		{name: "valid", env: "NSM_LOG_LEVEL=DEBUG"},
+		{name: "empty value", env: "NSM_LOG_LEVEL="},
+		{name: "value with separator", env: "NSM_SELECTOR=app=nginx"},
+		{name: "no separator", env: "NSM_LOG_LEVEL", wantErr: "NAME=VALUE is expected"},
+		{name: "empty name", env: "=DEBUG", wantErr: "not a valid env"},
+		{name: "invalid name", env: "1NSM=DEBUG", wantErr: "not a valid env"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.Envs = []string{tc.env}
+			err := c.Validate()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, config.ErrInvalidEnv) || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected %q error, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCABundleValidation(t *testing.T) {
+	now := time.Now()
+	key := newKey(t, "ed25519")
+	ca := selfSigned(t, key, now.Add(-time.Hour), now.Add(time.Hour))
+	for _, tc := range []struct {
+		name      string
+		caBundle  []byte
+		wantCerts int
+	}{
+		{name: "single certificate", caBundle: ca, wantCerts: 1},
+		{name: "several certificates", caBundle: append(append([]byte(nil), ca...), selfSigned(t, newKey(t, "ed25519"), now, now.Add(time.Hour))...), wantCerts: 2},
+		{name: "empty", caBundle: []byte("\n")},
+		{name: "garbage", caBundle: []byte("not a certificate")},
+		{name: "truncated", caBundle: ca[:len(ca)/2]},
+		{name: "private key", caBundle: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")})},
+		{name: "malformed certificate", caBundle: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("certificate")})},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			certs, err := config.ParseCABundle(tc.caBundle)
+			if (err == nil) != (tc.wantCerts > 0) || len(certs) != tc.wantCerts {
+				t.Fatalf("expected %d certificates, got %d and error %v", tc.wantCerts, len(certs), err)
+			}
+
+			c := defaultConfig(t)
+			c.WebhookMode = config.SelfregisterMode
+			writePair(t, c, ca, key)
+			c.CABundleFilePath = filepath.Join(t.TempDir(), "ca.crt")
+			if err = os.WriteFile(c.CABundleFilePath, tc.caBundle, 0o600); err != nil {
+				t.Fatalf("failed to write CA bundle: %v", err)
+			}
+			if err = c.Init(); (err == nil) != (tc.wantCerts > 0) {
+				t.Fatalf("unexpected initialization error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTopologySpreadConstraintsValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "valid", value: `[{"maxSkew": 1, "topologyKey": "kubernetes.io/hostname", "whenUnsatisfiable": "DoNotSchedule"}]`},
+		{name: "no topology key", value: `[{"maxSkew": 1, "whenUnsatisfiable": "DoNotSchedule"}]`, wantErr: true},
+		{name: "zero max skew", value: `[{"topologyKey": "kubernetes.io/hostname", "whenUnsatisfiable": "DoNotSchedule"}]`, wantErr: true},
+		{name: "unsupported whenUnsatisfiable", value: `[{"maxSkew": 1, "topologyKey": "kubernetes.io/hostname", "whenUnsatisfiable": "Never"}]`, wantErr: true},
+		{
+			name:    "invalid label selector",
+			value:   `[{"maxSkew": 1, "topologyKey": "kubernetes.io/hostname", "whenUnsatisfiable": "DoNotSchedule", "labelSelector": {"matchExpressions": [{"key": "app", "operator": "Near"}]}}]`,
+			wantErr: true,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			if err := c.InjectTopologySpreadConstraints.Decode(tc.value); err != nil {
+				t.Fatalf("failed to decode constraints: %v", err)
+			}
+			if err := c.Validate(); (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestServerPort(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		port    int
+		wantErr bool
+	}{
+		{name: "default", port: 443},
+		{name: "unprivileged", port: 8443},
+		{name: "zero", port: 0, wantErr: true},
+		{name: "too large", port: 65536, wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.ServerPort = tc.port
+			err := c.Validate()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			configuration, err := c.BuildMutatingWebhookConfiguration(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, listenPort, err := net.SplitHostPort(c.ServerAddress())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			servicePort := configuration.Webhooks[0].ClientConfig.Service.Port
+			if servicePort == nil || listenPort != strconv.Itoa(int(*servicePort)) || listenPort != strconv.Itoa(tc.port) {
+				t.Fatalf("expected port %d, got service port %v and listen port %s", tc.port, servicePort, listenPort)
+			}
+		})
+	}
+}
+
+func TestIncludePodIPSAN(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		include bool
+		podIP   string
+		want    bool
+		wantErr bool
+	}{
+		{name: "ipv4", include: true, podIP: "10.1.2.3", want: true},
+		{name: "ipv6", include: true, podIP: "fd00::3", want: true},
+		{name: "disabled", podIP: "10.1.2.3"},
+		{name: "invalid pod ip", include: true, podIP: "pod-ip", wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("POD_IP", tc.podIP)
+			c := defaultConfig(t)
+			c.WebhookMode = config.SelfregisterMode
+			c.IncludePodIPSAN = tc.include
+			err := c.Init()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			leaf, err := x509.ParseCertificate(c.GetOrResolveCertificate().Certificate[0])
+			if err != nil {
+				t.Fatalf("failed to parse certificate: %v", err)
+			}
+			var found bool
+			for _, ip := range leaf.IPAddresses {
+				found = found || ip.Equal(net.ParseIP(tc.podIP))
+			}
+			if found != tc.want {
+				t.Fatalf("expected pod IP SAN %v, got %v", tc.want, leaf.IPAddresses)
+			}
+		})
+	}
+}
+
+func TestProjectedSATokenValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		audience   string
+		expiration int64
+		mountPath  string
+		wantErr    bool
+	}{
+		{name: "valid", audience: "spire-server", expiration: 600, mountPath: "/var/run/secrets/tokens"},
+		{name: "no audience", expiration: 3600, mountPath: "/var/run/secrets/tokens", wantErr: true},
+		{name: "short expiration", audience: "spire-server", expiration: 599, mountPath: "/var/run/secrets/tokens", wantErr: true},
+		{name: "relative mount path", audience: "spire-server", expiration: 3600, mountPath: "tokens", wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.InjectProjectedSATokenVolume = true
+			c.ProjectedSATokenAudience = tc.audience
+			c.ProjectedSATokenExpirationSeconds = tc.expiration
+			c.ProjectedSATokenMountPath = tc.mountPath
+			if err := c.Validate(); (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWebhookNamespaceSelector(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		excludeOwn bool
+		excluded   []string
+		want       []string
+	}{
+		{name: "own namespace", excludeOwn: true, want: []string{"nsm-system"}},
+		{name: "own and listed namespaces", excludeOwn: true, excluded: []string{"kube-system", "spire"}, want: []string{"kube-system", "spire", "nsm-system"}},
+		{name: "listed namespaces", excluded: []string{"kube-system"}, want: []string{"kube-system"}},
+		{name: "no exclusions"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.Namespace = "nsm-system"
+			c.ExcludeOwnNamespace = tc.excludeOwn
+			c.ExcludedNamespaces = tc.excluded
+			configuration, err := c.BuildMutatingWebhookConfiguration(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			selector := configuration.Webhooks[0].NamespaceSelector
+			if tc.want == nil {
+				if selector != nil {
+					t.Fatalf("unexpected namespace selector: %+v", selector)
+				}
+				return
+			}
+			if selector == nil || len(selector.MatchExpressions) != 1 {
+				t.Fatalf("expected single match expression, got %+v", selector)
+			}
+			expression := selector.MatchExpressions[0]
+			if expression.Key != "kubernetes.io/metadata.name" || expression.Operator != "NotIn" || !reflect.DeepEqual(expression.Values, tc.want) {
+				t.Fatalf("expected namespaces %v to be excluded, got %+v", tc.want, expression)
+			}
+		})
+	}
+}
+
+func TestExcludeOwnNamespaceByDefault(t *testing.T) {
+	if !defaultConfig(t).ExcludeOwnNamespace {
+		t.Fatal("expected own namespace to be excluded by default")
+	}
+}
+
+func TestCABundleDir(t *testing.T) {
+	now := time.Now()
+	key := newKey(t, "ed25519")
+	first := selfSigned(t, key, now.Add(-time.Hour), now.Add(time.Hour))
+	second := selfSigned(t, newKey(t, "ed25519"), now.Add(-time.Hour), now.Add(time.Hour))
+	for _, tc := range []struct {
+		name    string
+		files   map[string][]byte
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:  "lexical order",
+			files: map[string][]byte{"b.pem": second, "a.crt": first, "c.txt": []byte("not a certificate"), "d.pem/e.pem": first},
+			want:  append(append([]byte(nil), first...), second...),
+		},
+		{name: "invalid file", files: map[string][]byte{"a.crt": first, "b.pem": []byte("not a certificate")}, wantErr: true},
+		{name: "no certificate files", files: map[string][]byte{"a.txt": first}, wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.WebhookMode = config.SelfregisterMode
+			writePair(t, c, first, key)
+			c.CABundleDir = t.TempDir()
+			for name, data := range tc.files {
+				file := filepath.Join(c.CABundleDir, name)
+				if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+					t.Fatalf("failed to create directory: %v", err)
+				}
+				if err := os.WriteFile(file, data, 0o600); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+
+			err := c.Init()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := c.GetOrResolveCABundle(); string(got) != string(tc.want) {
+				t.Fatalf("expected CA bundle %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCertExtKeyUsages(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		usages  []string
+		want    []x509.ExtKeyUsage
+		wantErr bool
+	}{
+		{name: "default", want: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}},
+		{name: "server and client auth", usages: []string{"serverAuth", "ClientAuth"}, want: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}},
+		{name: "unknown usage", usages: []string{"codeSigning"}, wantErr: true},
+		{name: "no usages", usages: []string{}, wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.WebhookMode = config.SelfregisterMode
+			if tc.usages != nil {
+				c.CertExtKeyUsages = tc.usages
+			}
+			err := c.Validate()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err = c.Init(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			leaf, err := x509.ParseCertificate(c.GetOrResolveCertificate().Certificate[0])
+			if err != nil {
+				t.Fatalf("failed to parse certificate: %v", err)
+			}
+			if !reflect.DeepEqual(leaf.ExtKeyUsage, tc.want) {
+				t.Fatalf("expected extended key usages %v, got %v", tc.want, leaf.ExtKeyUsage)
+			}
+		})
+	}
+}
+
+func TestEnvInterpolation(t *testing.T) {
+	fieldRef := func(name, fieldPath string) corev1.EnvVar {
+		return corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldPath}}}
+	}
+	spiffe := corev1.EnvVar{Name: "SPIFFE_ENDPOINT_SOCKET", Value: "unix:///run/spire/sockets/agent.sock"}
+	for _, tc := range []struct {
+		name string
+		envs []string
+		want []corev1.EnvVar
+	}{
+		{
+			name: "no references",
+			envs: []string{"NSM_LOG_LEVEL=DEBUG"},
+			want: []corev1.EnvVar{{Name: "NSM_LOG_LEVEL", Value: "DEBUG"}, spiffe, fieldRef("POD_NAME", "metadata.name")},
+		},
+		{
+			name: "referenced fields are defined before",
+			envs: []string{"NSM_LABELS=ns:$(POD_NAMESPACE)", "NSM_LISTEN_ON=tcp://$(POD_IP):5001"},
+			want: []corev1.EnvVar{
+				fieldRef("POD_NAMESPACE", "metadata.namespace"),
+				fieldRef("POD_IP", "status.podIP"),
+				{Name: "NSM_LABELS", Value: "ns:$(POD_NAMESPACE)"},
+				{Name: "NSM_LISTEN_ON", Value: "tcp://$(POD_IP):5001"},
+				spiffe,
+				fieldRef("POD_NAME", "metadata.name"),
+			},
+		},
+		{
+			name: "value with separator",
+			envs: []string{"NSM_SELECTOR=app=nginx"},
+			want: []corev1.EnvVar{{Name: "NSM_SELECTOR", Value: "app=nginx"}, spiffe, fieldRef("POD_NAME", "metadata.name")},
+		},
+		{
+			name: "pod name",
+			envs: []string{"NSM_CLIENT=$(POD_NAME)-nsc"},
+			want: []corev1.EnvVar{fieldRef("POD_NAME", "metadata.name"), {Name: "NSM_CLIENT", Value: "$(POD_NAME)-nsc"}, spiffe},
+		},
+		{
+			name: "user defined field",
+			envs: []string{"NODE_NAME=worker", "NSM_NODE=$(NODE_NAME)"},
+			want: []corev1.EnvVar{
+				{Name: "NODE_NAME", Value: "worker"},
+				{Name: "NSM_NODE", Value: "$(NODE_NAME)"},
+				spiffe,
+				fieldRef("POD_NAME", "metadata.name"),
+			},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.Envs = tc.envs
+			if got := c.GetOrResolveEnvs(); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected envs %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestBuildMutatingWebhookConfiguration(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		mode         config.Mode
+		resources    []string
+		extra        bool
+		wantCABundle bool
+		wantCore     []string
+		wantApps     []string
+	}{
+		{
+			name:     "spire",
+			mode:     config.SpireMode,
+			wantCore: []string{"pods"},
+			wantApps: []string{"deployments", "statefulsets", "daemonsets", "replicasets"},
+		},
+		{
+			name:         "selfregister",
+			mode:         config.SelfregisterMode,
+			wantCABundle: true,
+			wantCore:     []string{"pods"},
+			wantApps:     []string{"deployments", "statefulsets", "daemonsets", "replicasets"},
+		},
+		{
+			name:         "selfregister with subresources and namespaces",
+			mode:         config.SelfregisterMode,
+			resources:    []string{"pods", "deployments"},
+			extra:        true,
+			wantCABundle: true,
+			wantCore:     []string{"pods", "pods/ephemeralcontainers", "namespaces"},
+			wantApps:     []string{"deployments"},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.Name = "admission-webhook-k8s-abc"
+			c.WebhookConfigName = "nsm-admission-webhook"
+			c.WebhookMode = tc.mode
+			if tc.resources != nil {
+				c.EnabledResources = tc.resources
+			}
+			c.EnableEphemeralInjection = tc.extra
+			c.EnableNamespaceMutation = tc.extra
+			if err := c.Validate(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := c.Init(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			configuration, err := c.BuildMutatingWebhookConfiguration(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if configuration.Name != "nsm-admission-webhook" || configuration.Annotations[config.OwnerInstanceAnnotation] != c.Name ||
+				!reflect.DeepEqual(configuration.Labels, c.WebhookConfigurationLabels()) {
+				t.Fatalf("unexpected metadata: %+v", configuration.ObjectMeta)
+			}
+			if len(configuration.Webhooks) != 1 {
+				t.Fatalf("expected a single webhook, got %d", len(configuration.Webhooks))
+			}
+			webhook := configuration.Webhooks[0]
+			if webhook.Name != "admission-webhook-k8s-abc.networkservicemesh.io" {
+				t.Fatalf("unexpected webhook name: %s", webhook.Name)
+			}
+			if got := len(webhook.ClientConfig.CABundle) > 0; got != tc.wantCABundle ||
+				!bytes.Equal(webhook.ClientConfig.CABundle, c.GetOrResolveCABundle()) {
+				t.Fatalf("expected CA bundle %v, got %q", tc.wantCABundle, webhook.ClientConfig.CABundle)
+			}
+			if *webhook.FailurePolicy != admissionregistrationv1.Fail || *webhook.SideEffects != admissionregistrationv1.SideEffectClassNone ||
+				*webhook.TimeoutSeconds != c.WebhookTimeoutSeconds || !reflect.DeepEqual(webhook.AdmissionReviewVersions, []string{"v1"}) {
+				t.Fatalf("unexpected webhook: %+v", webhook)
+			}
+			if len(webhook.Rules) != 2 || !reflect.DeepEqual(webhook.Rules[0].Resources, tc.wantCore) ||
+				!reflect.DeepEqual(webhook.Rules[1].Resources, tc.wantApps) || webhook.Rules[1].APIGroups[0] != "apps" {
+				t.Fatalf("unexpected rules: %+v", webhook.Rules)
+			}
+		})
+	}
+}
+
+func TestBuildMutatingWebhookConfigurationCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := defaultConfig(t).BuildMutatingWebhookConfiguration(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestCABundleBase64(t *testing.T) {
+	now := time.Now()
+	key := newKey(t, "ed25519")
+	ca := selfSigned(t, key, now.Add(-time.Hour), now.Add(time.Hour))
+	for _, tc := range []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "valid", value: base64.StdEncoding.EncodeToString(ca)},
+		{name: "trailing newline", value: base64.StdEncoding.EncodeToString(ca) + "\n"},
+		{name: "not base64", value: "not base64!", wantErr: true},
+		{name: "not PEM", value: base64.StdEncoding.EncodeToString([]byte("not a certificate")), wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.WebhookMode = config.SelfregisterMode
+			writePair(t, c, ca, key)
+			c.CABundleBase64 = tc.value
+			err := c.Validate()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err = c.Init(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := c.GetOrResolveCABundle(); !bytes.Equal(got, ca) {
+				t.Fatalf("expected CA bundle %q, got %q", ca, got)
+			}
+		})
+	}
+}
+
+func TestCertificateSources(t *testing.T) {
+	const (
+		certFile = "/etc/webhook/tls.crt"
+		keyFile  = "/etc/webhook/tls.key"
+		caFile   = "/etc/webhook/ca.crt"
+	)
+	for _, tc := range []struct {
+		name     string
+		mode     config.Mode
+		certFile string
+		keyFile  string
+		caFile   string
+		caDir    string
+		// noFallback disables the self signed certificate fallback
+		noFallback bool
+		wantErr    bool
+	}{
+		{name: "self signed", mode: config.SelfregisterMode},
+		{name: "self signed fallback disabled", mode: config.SelfregisterMode, noFallback: true, wantErr: true},
+		{name: "certificate files without fallback", mode: config.SelfregisterMode, certFile: certFile, keyFile: keyFile, noFallback: true},
+		{name: "spire without fallback", mode: config.SpireMode, noFallback: true},
+		{name: "certificate files", mode: config.SelfregisterMode, certFile: certFile, keyFile: keyFile, caFile: caFile},
+		{name: "certificate file only", mode: config.SelfregisterMode, certFile: certFile, wantErr: true},
+		{name: "key file only", mode: config.SelfregisterMode, keyFile: keyFile, wantErr: true},
+		{name: "CA bundle file with self signed", mode: config.SelfregisterMode, caFile: caFile, wantErr: true},
+		{name: "CA bundle directory with self signed", mode: config.SelfregisterMode, caDir: "/etc/webhook/ca", wantErr: true},
+		{name: "spire", mode: config.SpireMode},
+		{name: "spire with certificate file only", mode: config.SpireMode, certFile: certFile, wantErr: true},
+		{name: "spire with CA bundle", mode: config.SpireMode, caFile: caFile},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.WebhookMode = tc.mode
+			c.CertFilePath, c.KeyFilePath = tc.certFile, tc.keyFile
+			c.CABundleFilePath, c.CABundleDir = tc.caFile, tc.caDir
+			c.AllowSelfSignedFallback = !tc.noFallback
+			err := c.Validate()
+			if !tc.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "the serving certificate is taken from") {
+				t.Fatalf("expected an error with the precedence of the certificate sources, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMergedCABundles(t *testing.T) {
+	now := time.Now()
+	key := newKey(t, "ed25519")
+	first := selfSigned(t, key, now.Add(-time.Hour), now.Add(time.Hour))
+	second := selfSigned(t, newKey(t, "ed25519"), now.Add(-time.Hour), now.Add(time.Hour))
+	third := selfSigned(t, newKey(t, "ed25519"), now.Add(-time.Hour), now.Add(time.Hour))
+
+	c := defaultConfig(t)
+	c.WebhookMode = config.SelfregisterMode
+	writePair(t, c, first, key)
+	c.CABundleFilePath = filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(c.CABundleFilePath, append(append([]byte(nil), first...), second...), 0o600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+	c.CABundleDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(c.CABundleDir, "ca.pem"), append(append([]byte(nil), second...), third...), 0o600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+	c.CABundleBase64 = base64.StdEncoding.EncodeToString(first)
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Init(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := append(append(append([]byte(nil), first...), second...), third...)
+	if got := c.GetOrResolveCABundle(); !bytes.Equal(got, want) {
+		t.Fatalf("expected the distinct certificates of all sources %q, got %q", want, got)
+	}
+	certs, err := config.ParseCABundle(c.GetOrResolveCABundle())
+	if err != nil || len(certs) != 3 {
+		t.Fatalf("expected 3 certificates, got %d and error %v", len(certs), err)
+	}
+}
+
+func TestClockSkewTolerance(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		tolerance time.Duration
+		notBefore time.Duration
+		notAfter  time.Duration
+		wantErr   bool
+	}{
+		{name: "not yet valid within tolerance", tolerance: time.Minute, notBefore: 30 * time.Second, notAfter: time.Hour},
+		{name: "not yet valid beyond tolerance", tolerance: time.Minute, notBefore: 5 * time.Minute, notAfter: time.Hour, wantErr: true},
+		{name: "not yet valid without tolerance", notBefore: 30 * time.Second, notAfter: time.Hour, wantErr: true},
+		{name: "expired within tolerance", tolerance: time.Minute, notBefore: -time.Hour, notAfter: -30 * time.Second},
+		{name: "expired beyond tolerance", tolerance: time.Minute, notBefore: -time.Hour, notAfter: -5 * time.Minute, wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Now()
+			key := newKey(t, "ecdsa-p256")
+			c := defaultConfig(t)
+			c.WebhookMode = config.SelfregisterMode
+			c.ClockSkewTolerance = tc.tolerance
+			ca := selfSigned(t, key, now.Add(tc.notBefore), now.Add(tc.notAfter))
+			writePair(t, c, ca, key)
+			c.CABundleFilePath = filepath.Join(t.TempDir(), "ca.crt")
+			if err := os.WriteFile(c.CABundleFilePath, ca, 0o600); err != nil {
+				t.Fatalf("failed to write CA bundle: %v", err)
+			}
+			if err := c.Init(); (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		address string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", want: "0.0.0.0:443"},
+		{name: "IPv4", address: "10.0.0.1", want: "10.0.0.1:443"},
+		{name: "IPv6", address: "::1", want: "[::1]:443"},
+		{name: "unspecified IPv6", address: "::", want: "[::]:443"},
+		{name: "host name", address: "localhost", wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			if tc.address != "" {
+				c.ListenAddress = tc.address
+			}
+			err := c.Validate()
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "not a valid listen address") {
+					t.Fatalf("expected invalid listen address, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := c.ServerAddress(); got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAdmissionReviewVersions(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		versions []string
+		wantErr  string
+	}{
+		{name: "v1", versions: []string{"v1"}},
+		{name: "ordered", versions: []string{"v1beta1", "v1"}},
+		{name: "empty", wantErr: "at least one admission review version is required"},
+		{name: "unknown", versions: []string{"v1", "v2"}, wantErr: "not a valid admission review version: v2"},
+		{name: "duplicated", versions: []string{"v1", "v1"}, wantErr: "duplicated admission review version: v1"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig(t)
+			c.Name = "admission-webhook-k8s-abc"
+			c.AdmissionReviewVersions = tc.versions
+			err := c.Validate()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected %q error, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err = c.Init(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			configuration, err := c.BuildMutatingWebhookConfiguration(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := configuration.Webhooks[0].AdmissionReviewVersions; !reflect.DeepEqual(got, tc.versions) {
+				t.Fatalf("expected admission review versions %v, got %v", tc.versions, got)
+			}
+		})
+	}
+}
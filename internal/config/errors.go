@@ -0,0 +1,33 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "github.com/pkg/errors"
+
+// Errors of Config.Validate and Decode of the Config fields. Returned errors wrap them, so they can be checked by errors.Is.
+var (
+	// ErrInvalidMode means that Config.WebhookMode is neither 'spire' nor 'selfregister'
+	ErrInvalidMode = errors.New("not a valid webhook mode")
+	// ErrInvalidQuantity means that a resource quantity can't be parsed
+	ErrInvalidQuantity = errors.New("not a valid quantity")
+	// ErrInvalidImage means that an image reference can't be parsed
+	ErrInvalidImage = errors.New("not a valid image reference")
+	// ErrInvalidEnv means that an entry of Config.Envs is not in NAME=VALUE form or has an invalid name
+	ErrInvalidEnv = errors.New("not a valid env")
+	// ErrCABundleNotFound means that no CA bundle source is configured or the CA bundle file doesn't exist
+	ErrCABundleNotFound = errors.New("CA bundle is not found")
+)
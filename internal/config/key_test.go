@@ -0,0 +1,73 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestKeyPEMFormat(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	for _, tc := range []struct {
+		format    string
+		wantType  string
+		wantError bool
+	}{
+		{format: "pkcs1", wantType: "RSA PRIVATE KEY"},
+		{format: "PKCS8", wantType: "PRIVATE KEY"},
+		{format: "sec1", wantError: true},
+	} {
+		tc := tc
+		t.Run(tc.format, func(t *testing.T) {
+			c := new(Config)
+			err := c.KeyPEMFormat.Decode(tc.format)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			keyPEM, err := c.encodePrivateKey(key)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			block, _ := pem.Decode(keyPEM)
+			if block == nil || block.Type != tc.wantType {
+				t.Fatalf("expected %s PEM block, got %v", tc.wantType, block)
+			}
+			var decoded interface{}
+			if tc.wantType == "PRIVATE KEY" {
+				decoded, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+			} else {
+				decoded, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+			}
+			if err != nil || !key.Equal(decoded) {
+				t.Fatalf("expected the encoded key, got %v, %v", decoded, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// OwnerServiceLabel is the label of the registered MutatingWebhookConfiguration that contains Config.ServiceName
+	OwnerServiceLabel = "networkservicemesh.io/admission-webhook-service"
+	// OwnerNamespaceLabel is the label of the registered MutatingWebhookConfiguration that contains Config.Namespace
+	OwnerNamespaceLabel = "networkservicemesh.io/admission-webhook-namespace"
+	// OwnerInstanceAnnotation is the annotation of the registered MutatingWebhookConfiguration that contains Config.Name
+	// of the admission webhook instance that has registered it
+	OwnerInstanceAnnotation = "networkservicemesh.io/admission-webhook-instance"
+)
+
+// BuildMutatingWebhookConfiguration returns the MutatingWebhookConfiguration that is registered in selfregister mode.
+// It resolves the CA bundle, so it may be used without registration, e.g. to render or inspect the configuration.
+func (c *Config) BuildMutatingWebhookConfiguration(ctx context.Context) (*admissionv1.MutatingWebhookConfiguration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	policy := admissionv1.Fail
+	sideEffects := admissionv1.SideEffectClassNone
+	matchPolicy := admissionv1.MatchPolicyType(c.MatchPolicy)
+	timeoutSeconds := c.WebhookTimeoutSeconds
+	return &admissionv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   c.WebhookConfigurationName(),
+			Labels: c.WebhookConfigurationLabels(),
+			Annotations: map[string]string{
+				OwnerInstanceAnnotation: c.Name,
+			},
+		},
+		Webhooks: []admissionv1.MutatingWebhook{
+			{
+				Name:                    fmt.Sprintf("%v.%v", c.Name, c.Annotation),
+				Rules:                   c.webhookRules(),
+				NamespaceSelector:       c.webhookNamespaceSelector(),
+				MatchPolicy:             &matchPolicy,
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: append([]string(nil), c.AdmissionReviewVersions...),
+				FailurePolicy:           &policy,
+				TimeoutSeconds:          &timeoutSeconds,
+				ClientConfig:            c.webhookClientConfig(),
+			},
+		},
+	}, nil
+}
+
+// WebhookConfigurationName returns the name of the registered MutatingWebhookConfiguration: Config.WebhookConfigName
+// if it's set or Config.Name otherwise
+func (c *Config) WebhookConfigurationName() string {
+	if c.WebhookConfigName != "" {
+		return c.WebhookConfigName
+	}
+	return c.Name
+}
+
+// WebhookConfigurationLabels returns labels that identify MutatingWebhookConfigurations registered by this admission
+// webhook instance
+func (c *Config) WebhookConfigurationLabels() map[string]string {
+	return map[string]string{
+		OwnerServiceLabel:   c.ServiceName,
+		OwnerNamespaceLabel: c.Namespace,
+	}
+}
+
+// webhookClientConfig returns the client config with Config.WebhookURL if it's set or with the service reference otherwise
+func (c *Config) webhookClientConfig() admissionv1.WebhookClientConfig {
+	if c.WebhookURL != "" {
+		return admissionv1.WebhookClientConfig{
+			URL:      &c.WebhookURL,
+			CABundle: c.GetOrResolveCABundle(),
+		}
+	}
+	path := "/mutate"
+	port := int32(c.ServerPort)
+	return admissionv1.WebhookClientConfig{
+		Service: &admissionv1.ServiceReference{
+			Namespace: c.Namespace,
+			Name:      c.ServiceName,
+			Path:      &path,
+			Port:      &port,
+		},
+		CABundle: c.GetOrResolveCABundle(),
+	}
+}
+
+// webhookNamespaceSelector returns the selector that excludes Config.ExcludedNamespaces and Config.Namespace if
+// Config.ExcludeOwnNamespace is set. The webhook itself can't be started if it's required for its own namespace.
+func (c *Config) webhookNamespaceSelector() *metav1.LabelSelector {
+	excluded := append([]string(nil), c.ExcludedNamespaces...)
+	if c.ExcludeOwnNamespace {
+		excluded = append(excluded, c.Namespace)
+	}
+	if len(excluded) == 0 {
+		return nil
+	}
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      corev1.LabelMetadataName,
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   excluded,
+			},
+		},
+	}
+}
+
+// webhookRules returns webhook rules for the resources listed in Config.EnabledResources
+func (c *Config) webhookRules() []admissionv1.RuleWithOperations {
+	var coreResources, appsResources []string
+	for _, r := range SupportedResources {
+		if !c.IsResourceEnabled(r) {
+			continue
+		}
+		if r == "pods" {
+			coreResources = append(coreResources, r)
+		} else {
+			appsResources = append(appsResources, r)
+		}
+	}
+
+	if c.EnableEphemeralInjection {
+		coreResources = append(coreResources, "pods/ephemeralcontainers")
+	}
+	if c.EnableNamespaceMutation {
+		coreResources = append(coreResources, "namespaces")
+	}
+
+	var result []admissionv1.RuleWithOperations
+	if len(coreResources) > 0 {
+		result = append(result, webhookRule("", coreResources))
+	}
+	if len(appsResources) > 0 {
+		result = append(result, webhookRule("apps", appsResources))
+	}
+	return result
+}
+
+func webhookRule(group string, resources []string) admissionv1.RuleWithOperations {
+	return admissionv1.RuleWithOperations{
+		Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
+		Rule: admissionv1.Rule{
+			APIGroups:   []string{group},
+			APIVersions: []string{"v1"},
+			Resources:   resources,
+		},
+	}
+}
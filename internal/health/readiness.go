@@ -0,0 +1,101 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health contains readiness and liveness checks for cmd-admission-webhook-k8s
+package health
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Readiness tracks named readiness conditions of the admission webhook
+type Readiness struct {
+	mu         sync.RWMutex
+	conditions map[string]error
+	// optional are the names of the conditions that don't affect Check
+	optional map[string]bool
+}
+
+// Set sets the state of the named condition. nil err means that the condition is ready.
+func (r *Readiness) Set(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conditions == nil {
+		r.conditions = make(map[string]error)
+	}
+	r.conditions[name] = err
+}
+
+// SetOptional sets the state of the named condition that is reported by Status, but doesn't affect Check. It reflects
+// a degraded mode of the admission webhook that still serves the requests.
+func (r *Readiness) SetOptional(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conditions == nil {
+		r.conditions = make(map[string]error)
+	}
+	if r.optional == nil {
+		r.optional = make(map[string]bool)
+	}
+	r.conditions[name] = err
+	r.optional[name] = true
+}
+
+// Check returns an error if any of the required conditions is not ready
+func (r *Readiness) Check() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.conditions))
+	for name := range r.conditions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := r.conditions[name]; err != nil && !r.optional[name] {
+			return errors.Wrapf(err, "%s is not ready", name)
+		}
+	}
+	return nil
+}
+
+// ConditionStatus is the state of a single readiness condition
+type ConditionStatus struct {
+	Name     string `json:"name"`
+	Ready    bool   `json:"ready"`
+	Optional bool   `json:"optional,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Status returns the states of all conditions sorted by name
+func (r *Readiness) Status() []ConditionStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := make([]ConditionStatus, 0, len(r.conditions))
+	for name, err := range r.conditions {
+		status := ConditionStatus{Name: name, Ready: err == nil, Optional: r.optional[name]}
+		if err != nil {
+			status.Error = err.Error()
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/health"
+)
+
+func TestReadiness(t *testing.T) {
+	readiness := new(health.Readiness)
+	if err := readiness.Check(); err != nil {
+		t.Fatalf("expected readiness without conditions, got %v", err)
+	}
+
+	readiness.Set(health.CertificateCondition, nil)
+	readiness.SetOptional(health.RequiredKindCondition, errors.New("kind is not served"))
+	if err := readiness.Check(); err != nil {
+		t.Fatalf("optional condition must not affect readiness, got %v", err)
+	}
+
+	readiness.Set(health.WebhookConfigurationCondition, errors.New("not found"))
+	if err := readiness.Check(); err == nil {
+		t.Fatal("expected the failed condition to affect readiness")
+	}
+
+	want := []health.ConditionStatus{
+		{Name: health.CertificateCondition, Ready: true},
+		{Name: health.RequiredKindCondition, Optional: true, Error: "kind is not served"},
+		{Name: health.WebhookConfigurationCondition, Error: "not found"},
+	}
+	status := readiness.Status()
+	if len(status) != len(want) {
+		t.Fatalf("expected status %+v, got %+v", want, status)
+	}
+	for i := range want {
+		if status[i] != want[i] {
+			t.Fatalf("expected status %+v, got %+v", want, status)
+		}
+	}
+
+	readiness.Set(health.WebhookConfigurationCondition, nil)
+	if err := readiness.Check(); err != nil {
+		t.Fatalf("expected recovered readiness, got %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	readiness := new(health.Readiness)
+	failed := make(chan error, 1)
+	failed <- errors.New("not found")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		health.Watch(ctx, readiness, health.WebhookConfigurationCondition, time.Millisecond, func(context.Context) error {
+			select {
+			case err := <-failed:
+				return err
+			default:
+				return nil
+			}
+		})
+	}()
+
+	// The first check fails and the next one recovers
+	eventually(t, func() bool { return len(failed) == 0 && readiness.Check() == nil })
+	cancel()
+	<-done
+}
+
+func TestCheckCertificate(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		wantErr   bool
+	}{
+		{name: "valid", notBefore: now.Add(-time.Hour), notAfter: now.Add(time.Hour)},
+		{name: "expired", notBefore: now.Add(-2 * time.Hour), notAfter: now.Add(-time.Hour), wantErr: true},
+		{name: "not yet valid", notBefore: now.Add(time.Hour), notAfter: now.Add(2 * time.Hour), wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatalf("failed to generate key: %v", err)
+			}
+			template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: tc.notBefore, NotAfter: tc.notAfter}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+			if err != nil {
+				t.Fatalf("failed to create certificate: %v", err)
+			}
+			if err = health.CheckCertificate(&tls.Certificate{Certificate: [][]byte{der}}); (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	if err := health.CheckCertificate(&tls.Certificate{}); err == nil {
+		t.Fatal("expected an error for the empty certificate")
+	}
+}
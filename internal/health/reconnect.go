@@ -0,0 +1,139 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/metrics"
+)
+
+// reconnectJitter is the max fraction of the reconnect interval that is randomly added to it
+const reconnectJitter = 0.1
+
+// X509Source is an X509SVIDSource that holds a connection to the SPIRE workload API, e.g. *workloadapi.X509Source
+type X509Source interface {
+	X509SVIDSource
+	Close() error
+}
+
+// X509SourceFactory creates a new X509Source. It should block until the first SVID is received or ctx is done.
+type X509SourceFactory func(ctx context.Context) (X509Source, error)
+
+// ReconnectBackoff returns the backoff that starts from interval, doubles on every step up to maxInterval and adds jitter
+func ReconnectBackoff(interval, maxInterval time.Duration) wait.Backoff {
+	return wait.Backoff{
+		Duration: interval,
+		Factor:   2,
+		Jitter:   reconnectJitter,
+		Steps:    math.MaxInt32,
+		Cap:      maxInterval,
+	}
+}
+
+// ReconnectingX509Source is an X509SVIDSource that recreates the underlying X509Source on Reconnect,
+// e.g. when the workload API stream is dropped and the source stops providing valid SVIDs.
+type ReconnectingX509Source struct {
+	factory   X509SourceFactory
+	backoff   wait.Backoff
+	threshold int
+	readiness *Readiness
+	logger    *zap.SugaredLogger
+
+	mu     sync.RWMutex
+	source X509Source
+}
+
+// NewReconnectingX509Source creates the X509Source by factory. The source is recreated by Reconnect with backoff,
+// after threshold consecutive failed attempts the X509SourceCondition of readiness is failed.
+func NewReconnectingX509Source(ctx context.Context, factory X509SourceFactory, backoff wait.Backoff, threshold int, readiness *Readiness, logger *zap.SugaredLogger) (*ReconnectingX509Source, error) {
+	source, err := factory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectingX509Source{
+		factory:   factory,
+		backoff:   backoff,
+		threshold: threshold,
+		readiness: readiness,
+		logger:    logger,
+		source:    source,
+	}, nil
+}
+
+// GetX509SVID returns the SVID of the current X509Source
+func (r *ReconnectingX509Source) GetX509SVID() (*x509svid.SVID, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.source.GetX509SVID()
+}
+
+// Reconnect replaces the current X509Source with a new one. Failed attempts are retried with backoff until ctx is done.
+// Every attempt is limited by the backoff cap, so a factory blocked by the unavailable SPIRE agent doesn't block the retries.
+func (r *ReconnectingX509Source) Reconnect(ctx context.Context) error {
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		source, err := r.connect(ctx)
+		if err == nil {
+			r.mu.Lock()
+			previous := r.source
+			r.source = source
+			r.mu.Unlock()
+			if closeErr := previous.Close(); closeErr != nil {
+				r.logger.Warnf("unable to close x509 source: %v", closeErr)
+			}
+			r.logger.Infof("x509 source is reconnected after %d attempt(s)", attempt)
+			return nil
+		}
+
+		r.logger.Warnf("x509 source reconnect failed %d time(s): %v", attempt, err)
+		metrics.RecordSVIDReconnectFailure(ctx)
+		if attempt >= r.threshold {
+			r.readiness.Set(X509SourceCondition, errors.Wrapf(err, "failed to reconnect x509 source %d time(s)", attempt))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff.Step()):
+		}
+	}
+}
+
+// Close closes the current X509Source
+func (r *ReconnectingX509Source) Close() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.source.Close()
+}
+
+func (r *ReconnectingX509Source) connect(ctx context.Context) (X509Source, error) {
+	if r.backoff.Cap <= 0 {
+		return r.factory(ctx)
+	}
+	connectCtx, cancel := context.WithTimeout(ctx, r.backoff.Cap)
+	defer cancel()
+	return r.factory(connectCtx)
+}
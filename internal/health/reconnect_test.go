@@ -0,0 +1,165 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package health_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/health"
+)
+
+// closableX509Source is a fakeX509Source that tracks Close
+type closableX509Source struct {
+	fakeX509Source
+	closed bool
+}
+
+func (s *closableX509Source) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *closableX509Source) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// failingFactory returns a factory that fails failures times after the first source is created
+func failingFactory(failures int) (factory health.X509SourceFactory, sources func() []*closableX509Source, attempts func() int) {
+	var mu sync.Mutex
+	var created []*closableX509Source
+	calls := 0
+	factory = func(context.Context) (health.X509Source, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls > 1 && calls <= failures+1 {
+			return nil, errors.New("connection refused")
+		}
+		source := new(closableX509Source)
+		created = append(created, source)
+		return source, nil
+	}
+	sources = func() []*closableX509Source {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*closableX509Source(nil), created...)
+	}
+	attempts = func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls - 1
+	}
+	return factory, sources, attempts
+}
+
+func TestReconnectX509Source(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		failures  int
+		threshold int
+		ready     bool
+	}{
+		{name: "first attempt", failures: 0, threshold: 2, ready: true},
+		{name: "below threshold", failures: 1, threshold: 2, ready: true},
+		{name: "threshold reached", failures: 3, threshold: 2, ready: false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			factory, sources, attempts := failingFactory(tc.failures)
+			readiness := new(health.Readiness)
+			backoff := health.ReconnectBackoff(time.Millisecond, 4*time.Millisecond)
+			source, err := health.NewReconnectingX509Source(context.Background(), factory, backoff, tc.threshold, readiness, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := source.Reconnect(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := attempts(); got != tc.failures+1 {
+				t.Fatalf("expected %d reconnect attempts, got %d", tc.failures+1, got)
+			}
+			created := sources()
+			if len(created) != 2 {
+				t.Fatalf("expected 2 created sources, got %d", len(created))
+			}
+			if !created[0].isClosed() {
+				t.Fatal("expected the previous source to be closed")
+			}
+			if created[1].isClosed() {
+				t.Fatal("expected the new source to stay open")
+			}
+			if _, err := source.GetX509SVID(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if created[0].getCalls() != 0 || created[1].getCalls() != 1 {
+				t.Fatal("expected the SVID to be taken from the new source")
+			}
+
+			err = readiness.Check()
+			if tc.ready && err != nil {
+				t.Fatalf("expected ready x509 source, got %v", err)
+			}
+			if !tc.ready && (err == nil || !strings.Contains(err.Error(), "failed to reconnect x509 source")) {
+				t.Fatalf("expected failed reconnect readiness, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReconnectX509SourceCanceled(t *testing.T) {
+	factory, sources, _ := failingFactory(math.MaxInt32)
+	source, err := health.NewReconnectingX509Source(context.Background(), factory, health.ReconnectBackoff(time.Millisecond, 4*time.Millisecond), 1, new(health.Readiness), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := source.Reconnect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	created := sources()
+	if len(created) != 1 || created[0].isClosed() {
+		t.Fatal("expected the current source to be kept on the canceled reconnect")
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	const (
+		interval    = 100 * time.Millisecond
+		maxInterval = 350 * time.Millisecond
+	)
+	backoff := health.ReconnectBackoff(interval, maxInterval)
+	for i, expected := range []time.Duration{interval, 2 * interval, maxInterval, maxInterval} {
+		step := backoff.Step()
+		if step < expected || step > expected+expected/10 {
+			t.Fatalf("step %d: expected %v with up to 10%% jitter, got %v", i, expected, step)
+		}
+	}
+}
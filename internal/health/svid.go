@@ -0,0 +1,114 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"go.uber.org/zap"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/metrics"
+)
+
+// X509SourceCondition is the name of the readiness condition reflecting the state of the SPIRE X509 source
+const X509SourceCondition = "x509 source"
+
+const svidWaitInterval = 100 * time.Millisecond
+
+// X509SVIDSource provides X509 SVIDs, e.g. *workloadapi.X509Source
+type X509SVIDSource interface {
+	GetX509SVID() (*x509svid.SVID, error)
+}
+
+// CheckX509SVID returns an error if source doesn't provide a currently valid SVID
+func CheckX509SVID(source X509SVIDSource) error {
+	svid, err := source.GetX509SVID()
+	if err != nil {
+		return errors.Wrap(err, "failed to get x509 SVID")
+	}
+	if svid == nil || len(svid.Certificates) == 0 {
+		return errors.New("x509 SVID has no certificates")
+	}
+	if now := time.Now(); now.After(svid.Certificates[0].NotAfter) || now.Before(svid.Certificates[0].NotBefore) {
+		return errors.Errorf("x509 SVID %v is valid only from %v to %v", svid.ID, svid.Certificates[0].NotBefore, svid.Certificates[0].NotAfter)
+	}
+	return nil
+}
+
+// WaitX509SVID blocks until source provides a currently valid SVID or ctx is done. The X509SourceCondition of
+// readiness is failed while waiting.
+func WaitX509SVID(ctx context.Context, source X509SVIDSource, readiness *Readiness) error {
+	ticker := time.NewTicker(svidWaitInterval)
+	defer ticker.Stop()
+
+	for {
+		err := CheckX509SVID(source)
+		if err == nil {
+			readiness.Set(X509SourceCondition, nil)
+			return nil
+		}
+		readiness.Set(X509SourceCondition, errors.Wrap(err, "waiting for the first x509 SVID"))
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(err, "no valid x509 SVID is available")
+		case <-ticker.C:
+		}
+	}
+}
+
+// WatchX509Source checks source every interval until ctx is done. After threshold consecutive failures the
+// X509SourceCondition of readiness is failed and the source is reconnected if it's a *ReconnectingX509Source.
+// It recovers on the first successful check.
+func WatchX509Source(ctx context.Context, source X509SVIDSource, interval time.Duration, threshold int, readiness *Readiness, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var consecutiveFailures int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := CheckX509SVID(source)
+		if err == nil {
+			if consecutiveFailures >= threshold {
+				logger.Infof("x509 source is recovered")
+			}
+			consecutiveFailures = 0
+			readiness.Set(X509SourceCondition, nil)
+			continue
+		}
+
+		consecutiveFailures++
+		logger.Warnf("x509 source check failed %d time(s): %v", consecutiveFailures, err)
+		metrics.RecordSVIDCheckFailure(ctx)
+		if consecutiveFailures >= threshold {
+			readiness.Set(X509SourceCondition, err)
+			if reconnecting, ok := source.(*ReconnectingX509Source); ok {
+				if reconnectErr := reconnecting.Reconnect(ctx); reconnectErr != nil {
+					return
+				}
+			}
+		}
+	}
+}
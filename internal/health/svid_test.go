@@ -0,0 +1,158 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health_test
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"go.uber.org/zap"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/health"
+)
+
+// fakeX509Source provides a valid SVID unless err is set
+type fakeX509Source struct {
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (s *fakeX509Source) GetX509SVID() (*x509svid.SVID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	now := time.Now()
+	return &x509svid.SVID{
+		Certificates: []*x509.Certificate{{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}},
+	}, nil
+}
+
+func (s *fakeX509Source) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *fakeX509Source) getCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckX509SVID(t *testing.T) {
+	if err := health.CheckX509SVID(&fakeX509Source{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := health.CheckX509SVID(&fakeX509Source{err: errors.New("stream is closed")}); err == nil {
+		t.Fatal("expected an error for the failed source")
+	}
+}
+
+func TestWatchX509Source(t *testing.T) {
+	const threshold = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := &fakeX509Source{}
+	readiness := new(health.Readiness)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		health.WatchX509Source(ctx, source, time.Millisecond, threshold, readiness, zap.NewNop().Sugar())
+	}()
+
+	eventually(t, func() bool { return len(readiness.Status()) == 1 })
+	if err := readiness.Check(); err != nil {
+		t.Fatalf("expected ready x509 source, got %v", err)
+	}
+
+	failedAt := source.getCalls()
+	source.setErr(errors.New("stream is closed"))
+	eventually(t, func() bool { return readiness.Check() != nil })
+	if calls := source.getCalls() - failedAt; calls < threshold {
+		t.Fatalf("expected readiness to fail after %d checks, got %d", threshold, calls)
+	}
+
+	source.setErr(nil)
+	eventually(t, func() bool { return readiness.Check() == nil })
+
+	cancel()
+	<-done
+}
+
+func TestWaitX509SVID(t *testing.T) {
+	source := &fakeX509Source{err: errors.New("no identity issued")}
+	readiness := new(health.Readiness)
+	done := make(chan error, 1)
+	go func() {
+		done <- health.WaitX509SVID(context.Background(), source, readiness)
+	}()
+
+	eventually(t, func() bool { return readiness.Check() != nil })
+	if err := readiness.Check(); err == nil || !strings.Contains(err.Error(), "waiting for the first x509 SVID") {
+		t.Fatalf("expected readiness to fail while waiting, got %v", err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("expected to wait for a valid SVID, got %v", err)
+	default:
+	}
+
+	source.setErr(nil)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := readiness.Check(); err != nil {
+		t.Fatalf("expected ready x509 source, got %v", err)
+	}
+}
+
+func TestWaitX509SVIDTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	readiness := new(health.Readiness)
+	err := health.WaitX509SVID(ctx, &fakeX509Source{err: errors.New("no identity issued")}, readiness)
+	if err == nil || !strings.Contains(err.Error(), "no valid x509 SVID is available") {
+		t.Fatalf("expected the timeout error, got %v", err)
+	}
+	if readiness.Check() == nil {
+		t.Fatal("expected readiness to fail")
+	}
+}
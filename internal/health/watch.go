@@ -0,0 +1,82 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// CertificateCondition is the name of the readiness condition reflecting the validity of the static serving certificate
+	CertificateCondition = "certificate"
+	// WebhookConfigurationCondition is the name of the readiness condition reflecting the state of the registered
+	// MutatingWebhookConfiguration
+	WebhookConfigurationCondition = "webhook configuration"
+	// RequiredKindCondition is the name of the optional readiness condition reflecting the presence of Config.RequireCRD
+	RequiredKindCondition = "required kind"
+)
+
+// Watch sets the named condition of readiness to the result of check immediately and then every interval until ctx is done
+func Watch(ctx context.Context, readiness *Readiness, name string, interval time.Duration, check func(context.Context) error) {
+	watch(ctx, interval, check, func(err error) {
+		readiness.Set(name, err)
+	})
+}
+
+// WatchOptional is Watch for the optional condition, see Readiness.SetOptional
+func WatchOptional(ctx context.Context, readiness *Readiness, name string, interval time.Duration, check func(context.Context) error) {
+	watch(ctx, interval, check, func(err error) {
+		readiness.SetOptional(name, err)
+	})
+}
+
+func watch(ctx context.Context, interval time.Duration, check func(context.Context) error, set func(error)) {
+	set(check(ctx))
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		set(check(ctx))
+	}
+}
+
+// CheckCertificate returns an error if the leaf of cert is not currently valid
+func CheckCertificate(cert *tls.Certificate) error {
+	if len(cert.Certificate) == 0 {
+		return errors.New("certificate is empty")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return errors.Wrap(err, "failed to parse certificate")
+	}
+	if now := time.Now(); now.After(leaf.NotAfter) || now.Before(leaf.NotBefore) {
+		return errors.Errorf("certificate %v is valid only from %v to %v", leaf.Subject, leaf.NotBefore, leaf.NotAfter)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"context"
+	"time"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/config"
+)
+
+// WatchCABundle reloads the CA bundle of c every interval until ctx is done and publishes it to the registered
+// MutatingWebhookConfiguration if it's changed. The CA bundle that can't be read or parsed is not published, the failed publishing is retried.
+// The CA bundle is also reloaded and published on each rotated notification, so the CA of the rotated serving
+// certificate doesn't wait for the next interval. 0 interval disables the periodic reloads.
+func (a *AdmissionWebhookRegisterClient) WatchCABundle(ctx context.Context, c *config.Config, interval time.Duration, rotated <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	// pending is set if the reloaded CA bundle is not published yet
+	var pending bool
+	for {
+		var force bool
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-rotated:
+			force = true
+		}
+		changed, err := c.ReloadCABundle()
+		if err != nil {
+			a.Logger.Errorf("Failed to reload CA bundle: %v", err)
+			continue
+		}
+		if !changed && !pending && !force {
+			continue
+		}
+		err = a.UpdateCABundle(ctx, c, c.GetOrResolveCABundle())
+		pending = err != nil
+		if err != nil && ctx.Err() == nil {
+			a.Logger.Errorf("Failed to publish reloaded CA bundle: %v", err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/config"
+)
+
+// caBundle returns the PEM encoded self signed CA certificate with the common name.
+func caBundle(t *testing.T, commonName string) []byte {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, public, private)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// publishedCommonName returns the common name of the CA bundle published to the webhook configuration.
+func publishedCommonName(t *testing.T, a *AdmissionWebhookRegisterClient, c *config.Config) string {
+	t.Helper()
+	configuration, err := a.client.MutatingWebhookConfigurations().Get(context.Background(), c.WebhookConfigurationName(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get webhook configuration: %v", err)
+	}
+	certs, err := config.ParseCABundle(configuration.Webhooks[0].ClientConfig.CABundle)
+	if err != nil {
+		return ""
+	}
+	return certs[0].Subject.CommonName
+}
+
+func waitPublished(t *testing.T, a *AdmissionWebhookRegisterClient, c *config.Config, commonName string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for publishedCommonName(t, a, c) != commonName {
+		if time.Now().After(deadline) {
+			t.Fatalf("CA bundle %s is not published", commonName)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func writeCABundle(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+}
+
+func TestWatchCABundle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := testConfig()
+	c.RegisterRetryAttempts = 1
+	c.CABundleFilePath = filepath.Join(t.TempDir(), "ca.crt")
+	writeCABundle(t, c.CABundleFilePath, caBundle(t, "first"))
+	a, clientset := newRegisterClient(webhookConfiguration(c.Name, c.WebhookConfigurationLabels()))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.WatchCABundle(ctx, c, time.Millisecond, nil)
+	}()
+	waitPublished(t, a, c, "first")
+
+	writeCABundle(t, c.CABundleFilePath, caBundle(t, "rotated"))
+	waitPublished(t, a, c, "rotated")
+
+	// The invalid CA bundle is not published
+	writeCABundle(t, c.CABundleFilePath, []byte("not a certificate"))
+	time.Sleep(20 * time.Millisecond)
+	if got := publishedCommonName(t, a, c); got != "rotated" {
+		t.Fatalf("expected the previous CA bundle to be kept, got %q", got)
+	}
+
+	// The failed publishing is retried on the next tick even though the CA bundle isn't changed again
+	attempts := failUpdates(clientset, 1, apierrors.NewBadRequest("unavailable"))
+	writeCABundle(t, c.CABundleFilePath, caBundle(t, "retried"))
+	waitPublished(t, a, c, "retried")
+	if *attempts < 2 {
+		t.Fatalf("expected the failed publishing to be retried, got %d attempts", *attempts)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestWatchCABundleOnRotation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := testConfig()
+	c.RegisterRetryAttempts = 1
+	c.CABundleFilePath = filepath.Join(t.TempDir(), "ca.crt")
+	writeCABundle(t, c.CABundleFilePath, caBundle(t, "first"))
+	a, _ := newRegisterClient(webhookConfiguration(c.Name, c.WebhookConfigurationLabels()))
+	rotated := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// There are no periodic reloads, so the CA bundle is published only on rotation
+		a.WatchCABundle(ctx, c, 0, rotated)
+	}()
+
+	rotated <- struct{}{}
+	waitPublished(t, a, c, "first")
+
+	writeCABundle(t, c.CABundleFilePath, caBundle(t, "rotated"))
+	rotated <- struct{}{}
+	waitPublished(t, a, c, "rotated")
+
+	cancel()
+	<-done
+}
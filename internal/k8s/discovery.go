@@ -0,0 +1,67 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// RequiredKind tracks whether the kind is served by the apiserver, e.g. the kind of an optional CRD.
+type RequiredKind struct {
+	client  discovery.DiscoveryInterface
+	gvk     schema.GroupVersionKind
+	present atomic.Bool
+}
+
+// NewRequiredKind creates RequiredKind that looks up gvk by client. The kind is absent until the first Check.
+func NewRequiredKind(client discovery.DiscoveryInterface, gvk schema.GroupVersionKind) *RequiredKind {
+	return &RequiredKind{
+		client: client,
+		gvk:    gvk,
+	}
+}
+
+// Check looks up the kind and returns an error if it's not served. The result of a failed lookup is kept.
+func (r *RequiredKind) Check(_ context.Context) error {
+	resources, err := r.client.ServerResourcesForGroupVersion(r.gvk.GroupVersion().String())
+	if apierrors.IsNotFound(err) {
+		r.present.Store(false)
+		return errors.Errorf("%s is not served by the apiserver", r.gvk.GroupVersion())
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to discover %s", r.gvk.GroupVersion())
+	}
+	for i := range resources.APIResources {
+		if resources.APIResources[i].Kind == r.gvk.Kind {
+			r.present.Store(true)
+			return nil
+		}
+	}
+	r.present.Store(false)
+	return errors.Errorf("%s is not served by the apiserver", r.gvk)
+}
+
+// Present returns true if the kind was served on the last successful Check.
+func (r *RequiredKind) Present() bool {
+	return r.present.Load()
+}
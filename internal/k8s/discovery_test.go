@@ -0,0 +1,90 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRequiredKind(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "networkservicemesh.io", Version: "v1", Kind: "NetworkService"}
+	for _, tc := range []struct {
+		name      string
+		resources []*metav1.APIResourceList
+		want      bool
+	}{
+		{name: "absent group version"},
+		{
+			name: "absent kind",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "networkservicemesh.io/v1",
+				APIResources: []metav1.APIResource{{Name: "networkserviceendpoints", Kind: "NetworkServiceEndpoint"}},
+			}},
+		},
+		{
+			name: "other version",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "networkservicemesh.io/v1beta1",
+				APIResources: []metav1.APIResource{{Name: "networkservices", Kind: "NetworkService"}},
+			}},
+		},
+		{
+			name: "served",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "networkservicemesh.io/v1",
+				APIResources: []metav1.APIResource{{Name: "networkservices", Kind: "NetworkService"}},
+			}},
+			want: true,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+			client.Resources = tc.resources
+			r := NewRequiredKind(client, gvk)
+			if r.Present() {
+				t.Fatal("expected the kind to be absent before the first check")
+			}
+			err := r.Check(context.Background())
+			if got := r.Present(); got != tc.want || (err == nil) != tc.want {
+				t.Fatalf("expected present %v, got %v with error %v", tc.want, got, err)
+			}
+		})
+	}
+}
+
+func TestRequiredKindRemoved(t *testing.T) {
+	client := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	client.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "networkservicemesh.io/v1",
+		APIResources: []metav1.APIResource{{Name: "networkservices", Kind: "NetworkService"}},
+	}}
+	r := NewRequiredKind(client, schema.GroupVersionKind{Group: "networkservicemesh.io", Version: "v1", Kind: "NetworkService"})
+	if err := r.Check(context.Background()); err != nil || !r.Present() {
+		t.Fatalf("expected the served kind, got %v", err)
+	}
+
+	client.Resources = nil
+	if err := r.Check(context.Background()); err == nil || r.Present() {
+		t.Fatal("expected the removed kind to be absent")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// NamespaceCache keeps the fetched namespaces for ttl to avoid a namespace lookup per admission request.
+type NamespaceCache struct {
+	client  typedcorev1.NamespaceInterface
+	ttl     time.Duration
+	mutex   sync.Mutex
+	entries map[string]namespaceCacheEntry
+}
+
+type namespaceCacheEntry struct {
+	namespace *corev1.Namespace
+	expiry    time.Time
+}
+
+// NewNamespaceCache creates NamespaceCache that fetches the missing or expired namespaces by client.
+func NewNamespaceCache(client typedcorev1.NamespaceInterface, ttl time.Duration) *NamespaceCache {
+	return &NamespaceCache{
+		client:  client,
+		ttl:     ttl,
+		entries: make(map[string]namespaceCacheEntry),
+	}
+}
+
+// Get returns the cached namespace or fetches it if it's missing or expired. Failed lookups are not cached.
+func (n *NamespaceCache) Get(ctx context.Context, name string) (*corev1.Namespace, error) {
+	n.mutex.Lock()
+	entry, ok := n.entries[name]
+	n.mutex.Unlock()
+	if ok && time.Now().Before(entry.expiry) {
+		return entry.namespace, nil
+	}
+
+	namespace, err := n.client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for key, e := range n.entries {
+		if time.Now().After(e.expiry) {
+			delete(n.entries, key)
+		}
+	}
+	n.entries[name] = namespaceCacheEntry{namespace: namespace, expiry: time.Now().Add(n.ttl)}
+	return namespace, nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceCache(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		ttl       time.Duration
+		lookup    string
+		wantFound bool
+		wantGets  int
+	}{
+		{name: "cached", ttl: time.Hour, lookup: "app", wantFound: true, wantGets: 1},
+		{name: "expired", ttl: 0, lookup: "app", wantFound: true, wantGets: 3},
+		{name: "not found", ttl: time.Hour, lookup: "missing", wantGets: 3},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: map[string]string{"networkservicemesh.io": "kernel://ns"}},
+			})
+			cache := NewNamespaceCache(clientset.CoreV1().Namespaces(), tc.ttl)
+			for i := 0; i < 3; i++ {
+				namespace, err := cache.Get(context.Background(), tc.lookup)
+				if tc.wantFound && (err != nil || namespace.Annotations["networkservicemesh.io"] != "kernel://ns") {
+					t.Fatalf("expected the annotated namespace, got %v, %v", namespace, err)
+				}
+				if !tc.wantFound && err == nil {
+					t.Fatal("expected an error")
+				}
+			}
+			var gets int
+			for _, action := range clientset.Actions() {
+				if action.GetVerb() == "get" {
+					gets++
+				}
+			}
+			if gets != tc.wantGets {
+				t.Fatalf("expected %d namespace lookups, got %d", tc.wantGets, gets)
+			}
+		})
+	}
+}
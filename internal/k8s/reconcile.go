@@ -0,0 +1,117 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/config"
+)
+
+// Reconcile recreates the registered MutatingWebhookConfiguration if it's deleted and restores it if it drifts from
+// the desired one every interval until ctx is done. The configuration registered by another instance is not changed.
+func (a *AdmissionWebhookRegisterClient) Reconcile(ctx context.Context, c *config.Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := a.reconcile(ctx, c); err != nil && ctx.Err() == nil {
+			a.Logger.Errorf("Failed to reconcile MutatingWebhookConfiguration %s: %v", c.WebhookConfigurationName(), err)
+		}
+	}
+}
+
+func (a *AdmissionWebhookRegisterClient) reconcile(ctx context.Context, c *config.Config) error {
+	a.once.Do(a.initializeClient)
+	return retryPublish(c, func() error {
+		desired, err := c.BuildMutatingWebhookConfiguration(ctx)
+		if err != nil {
+			return err
+		}
+		actual, err := a.client.MutatingWebhookConfigurations().Get(ctx, desired.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			a.Logger.Warnf("MutatingWebhookConfiguration %s is deleted, recreating", desired.Name)
+			_, err = a.client.MutatingWebhookConfigurations().Create(ctx, desired, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return nil
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if owner := actual.Annotations[OwnerInstanceAnnotation]; owner != "" && owner != c.Name {
+			return nil
+		}
+		if !drifted(actual, desired) {
+			return nil
+		}
+		a.Logger.Warnf("MutatingWebhookConfiguration %s drifted from the desired state, updating", desired.Name)
+		desired.ResourceVersion = actual.ResourceVersion
+		_, err = a.client.MutatingWebhookConfigurations().Update(ctx, desired, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// drifted returns true if the fields of actual set by admission webhook differ from desired. Fields defaulted by the
+// apiserver are not compared.
+func drifted(actual, desired *admissionv1.MutatingWebhookConfiguration) bool {
+	if len(actual.Webhooks) != len(desired.Webhooks) {
+		return true
+	}
+	for i := range desired.Webhooks {
+		a, d := &actual.Webhooks[i], &desired.Webhooks[i]
+		if a.Name != d.Name ||
+			!bytes.Equal(a.ClientConfig.CABundle, d.ClientConfig.CABundle) ||
+			!equality.Semantic.DeepEqual(a.ClientConfig.Service, d.ClientConfig.Service) ||
+			!equality.Semantic.DeepEqual(a.ClientConfig.URL, d.ClientConfig.URL) ||
+			!equality.Semantic.DeepEqual(withoutScope(a.Rules), d.Rules) ||
+			!equality.Semantic.DeepEqual(orEmpty(a.NamespaceSelector), orEmpty(d.NamespaceSelector)) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutScope returns rules without the scope defaulted by the apiserver
+func withoutScope(rules []admissionv1.RuleWithOperations) []admissionv1.RuleWithOperations {
+	result := make([]admissionv1.RuleWithOperations, len(rules))
+	for i := range rules {
+		rules[i].DeepCopyInto(&result[i])
+		result[i].Scope = nil
+	}
+	return result
+}
+
+// orEmpty returns the empty selector for nil that is the apiserver default
+func orEmpty(selector *metav1.LabelSelector) *metav1.LabelSelector {
+	if selector == nil {
+		return &metav1.LabelSelector{}
+	}
+	return selector
+}
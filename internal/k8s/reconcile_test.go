@@ -0,0 +1,146 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func registered(t *testing.T, clientset *fake.Clientset, name string) *admissionv1.MutatingWebhookConfiguration {
+	t.Helper()
+	configuration, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get webhook configuration %s: %v", name, err)
+	}
+	return configuration
+}
+
+func TestReconcile(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		change      func(configuration *admissionv1.MutatingWebhookConfiguration)
+		deleted     bool
+		wantUpdate  bool
+		wantCreate  bool
+		wantDrifted bool
+	}{
+		{name: "deleted", deleted: true, wantCreate: true},
+		{
+			name: "drifted caBundle",
+			change: func(configuration *admissionv1.MutatingWebhookConfiguration) {
+				configuration.Webhooks[0].ClientConfig.CABundle = []byte("another CA bundle")
+			},
+			wantUpdate: true,
+		},
+		{
+			name: "drifted rules",
+			change: func(configuration *admissionv1.MutatingWebhookConfiguration) {
+				configuration.Webhooks[0].Rules = configuration.Webhooks[0].Rules[:1]
+			},
+			wantUpdate: true,
+		},
+		{
+			name: "apiserver defaults",
+			change: func(configuration *admissionv1.MutatingWebhookConfiguration) {
+				scope := admissionv1.NamespacedScope
+				configuration.Webhooks[0].Rules[0].Scope = &scope
+				configuration.Webhooks[0].NamespaceSelector = &metav1.LabelSelector{}
+			},
+		},
+		{
+			name: "registered by another instance",
+			change: func(configuration *admissionv1.MutatingWebhookConfiguration) {
+				configuration.Annotations[OwnerInstanceAnnotation] = "admission-webhook-k8s-def"
+				configuration.Webhooks[0].ClientConfig.CABundle = []byte("another CA bundle")
+			},
+			wantDrifted: true,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := testConfig()
+			c.WebhookConfigName = "nsm-admission-webhook"
+			c.ExcludeOwnNamespace = false
+			a, clientset := newRegisterClient()
+			if err := a.Register(context.Background(), c); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			client := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations()
+			if tc.deleted {
+				if err := client.Delete(context.Background(), c.WebhookConfigName, metav1.DeleteOptions{}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else {
+				configuration := registered(t, clientset, c.WebhookConfigName)
+				tc.change(configuration)
+				if _, err := client.Update(context.Background(), configuration, metav1.UpdateOptions{}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			clientset.ClearActions()
+
+			if err := a.reconcile(context.Background(), c); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var created, updated bool
+			for _, action := range clientset.Actions() {
+				created = created || action.GetVerb() == "create"
+				updated = updated || action.GetVerb() == "update"
+			}
+			if created != tc.wantCreate || updated != tc.wantUpdate {
+				t.Fatalf("expected create %v and update %v, got %v", tc.wantCreate, tc.wantUpdate, clientset.Actions())
+			}
+			desired, err := c.BuildMutatingWebhookConfiguration(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := registered(t, clientset, c.WebhookConfigName); drifted(got, desired) != tc.wantDrifted {
+				t.Fatalf("expected drifted %v, got %+v", tc.wantDrifted, got)
+			}
+		})
+	}
+}
+
+func TestReconcileLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := testConfig()
+	a, clientset := newRegisterClient()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Reconcile(ctx, c, time.Millisecond)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(configurationNames(t, clientset.AdmissionregistrationV1())) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("webhook configuration is not recreated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	assertNames(t, configurationNames(t, clientset.AdmissionregistrationV1()), c.Name)
+}
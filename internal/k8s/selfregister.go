@@ -20,21 +20,34 @@
 package k8s
 
 import (
+	"bytes"
 	"context"
-	"fmt"
 	"sync"
 
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	admissionregistrationv1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 
 	"github.com/networkservicemesh/cmd-admission-webhook/internal/config"
 )
 
+const (
+	// OwnerServiceLabel is the label of the registered MutatingWebhookConfiguration that contains Config.ServiceName
+	OwnerServiceLabel = config.OwnerServiceLabel
+	// OwnerNamespaceLabel is the label of the registered MutatingWebhookConfiguration that contains Config.Namespace
+	OwnerNamespaceLabel = config.OwnerNamespaceLabel
+	// OwnerInstanceAnnotation is the annotation of the registered MutatingWebhookConfiguration that contains Config.Name
+	// of the admission webhook instance that has registered it
+	OwnerInstanceAnnotation = config.OwnerInstanceAnnotation
+)
+
 // AdmissionWebhookRegisterClient is a simple client that can register and unregister MutatingWebhookConfiguration based on config.Config
 type AdmissionWebhookRegisterClient struct {
 	Logger *zap.SugaredLogger
@@ -60,73 +73,164 @@ func (a *AdmissionWebhookRegisterClient) Register(ctx context.Context, c *config
 	a.Logger.Infof("Starting to register MutatingWebhookConfiguration based config: %#v", c)
 	defer a.Logger.Infof("Register for config %#v is done", c)
 
+	name := c.WebhookConfigurationName()
 	// When node is restarted, all apps started in pods with old names.
 	// Then we already have configuration with the same name but it can't be reused
-	// because it contains different certs (certs are regenerated on every program restart)
-	_, errExisting := a.client.MutatingWebhookConfigurations().Get(ctx, c.Name, metav1.GetOptions{})
+	// because it contains different certs (certs are regenerated on every program restart).
+	// The configuration with Config.WebhookConfigName is updated instead to keep the name stable across rollouts.
+	existing, errExisting := a.client.MutatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
 	if errExisting == nil {
-		a.Logger.Infof("Found existing MutatingWebhookConfiguration %s, unregistering", c.Name)
-		err := a.Unregister(ctx, c)
-		if err != nil {
-			return err
+		trustCABundles(c, existing)
+		if c.WebhookConfigName == "" {
+			a.Logger.Infof("Found existing MutatingWebhookConfiguration %s, unregistering", name)
+			if err := a.Unregister(ctx, c); err != nil {
+				return err
+			}
 		}
 	} else if !apierrors.IsNotFound(errExisting) {
-		a.Logger.Errorf("Failed to search for existing MutatingWebhookConfiguration %s", c.Name)
+		a.Logger.Errorf("Failed to search for existing MutatingWebhookConfiguration %s", name)
 		return errExisting
 	}
 
-	path := "/mutate"
-	policy := admissionv1.Fail
-	sideEffects := admissionv1.SideEffectClassNone
-	webhookConfig := &admissionv1.MutatingWebhookConfiguration{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: c.Name,
-		},
-		Webhooks: []admissionv1.MutatingWebhook{
-			{
-
-				Name: fmt.Sprintf("%v.%v", c.Name, c.Annotation),
-				Rules: []admissionv1.RuleWithOperations{
-					{
-						Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
-						Rule: admissionv1.Rule{
-							APIGroups:   []string{""},
-							APIVersions: []string{"v1"},
-							Resources:   []string{"pods"},
-						},
-					},
-					{
-						Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
-						Rule: admissionv1.Rule{
-							APIGroups:   []string{"apps"},
-							APIVersions: []string{"v1"},
-							Resources:   []string{"deployments", "statefulsets", "daemonsets", "replicasets"},
-						},
-					},
-				},
-				SideEffects:             &sideEffects,
-				AdmissionReviewVersions: []string{"v1"},
-				FailurePolicy:           &policy,
-				ClientConfig: admissionv1.WebhookClientConfig{
-					Service: &admissionv1.ServiceReference{
-						Namespace: c.Namespace,
-						Name:      c.ServiceName,
-						Path:      &path,
-					},
-					CABundle: c.GetOrResolveCABundle(),
-				},
-			},
-		},
+	// Without Config.WebhookConfigName every replica registers the configuration with its own name, so the other
+	// configurations with the same labels may belong to the live replicas and aren't stale
+	if c.WebhookConfigName != "" {
+		if err := a.deleteStale(ctx, c); err != nil {
+			return err
+		}
 	}
 
-	_, err := a.client.MutatingWebhookConfigurations().Create(ctx, webhookConfig, metav1.CreateOptions{})
+	webhookConfig, err := c.BuildMutatingWebhookConfiguration(ctx)
+	if err != nil {
+		return err
+	}
+	return retryPublish(c, func() error {
+		return a.publish(ctx, webhookConfig)
+	})
+}
+
+// publish creates webhookConfig or updates the existing configuration with the same name
+func (a *AdmissionWebhookRegisterClient) publish(ctx context.Context, webhookConfig *admissionv1.MutatingWebhookConfiguration) error {
+	existing, err := a.client.MutatingWebhookConfigurations().Get(ctx, webhookConfig.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = a.client.MutatingWebhookConfigurations().Create(ctx, webhookConfig, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	a.Logger.Infof("Found existing MutatingWebhookConfiguration %s, updating", webhookConfig.Name)
+	webhookConfig.ResourceVersion = existing.ResourceVersion
+	_, err = a.client.MutatingWebhookConfigurations().Update(ctx, webhookConfig, metav1.UpdateOptions{})
 	return err
 }
 
+// retryPublish retries fn on conflicts and transient apiserver errors at most Config.RegisterRetryAttempts times
+func retryPublish(c *config.Config, fn func() error) error {
+	backoff := retry.DefaultBackoff
+	backoff.Steps = c.RegisterRetryAttempts
+	var attempts int
+	err := retry.OnError(backoff, isRetriable, func() error {
+		attempts++
+		return fn()
+	})
+	if err != nil && isRetriable(err) {
+		return errors.Wrapf(err, "failed to publish MutatingWebhookConfiguration %s after %d attempts", c.WebhookConfigurationName(), attempts)
+	}
+	return err
+}
+
+// isRetriable returns true for update conflicts and transient apiserver errors
+func isRetriable(err error) bool {
+	return apierrors.IsConflict(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err)
+}
+
+// deleteStale deletes MutatingWebhookConfigurations owned by this admission webhook service that have other name than
+// Config.WebhookConfigName, e.g. the ones registered before the name was configured
+func (a *AdmissionWebhookRegisterClient) deleteStale(ctx context.Context, c *config.Config) error {
+	list, err := a.client.MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(c.WebhookConfigurationLabels()).String(),
+	})
+	if err != nil {
+		return err
+	}
+	for i := range list.Items {
+		if list.Items[i].Name == c.WebhookConfigurationName() {
+			continue
+		}
+		a.Logger.Infof("Deleting stale MutatingWebhookConfiguration %s", list.Items[i].Name)
+		trustCABundles(c, &list.Items[i])
+		err = a.client.MutatingWebhookConfigurations().Delete(ctx, list.Items[i].Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// trustCABundles keeps CA bundles of the replaced MutatingWebhookConfiguration during Config.CARotationWindow
+func trustCABundles(c *config.Config, webhookConfig *admissionv1.MutatingWebhookConfiguration) {
+	for i := range webhookConfig.Webhooks {
+		c.TrustPreviousCABundle(webhookConfig.Webhooks[i].ClientConfig.CABundle)
+	}
+}
+
+// UpdateCABundle patches caBundle of the registered MutatingWebhookConfiguration. Update conflicts and transient
+// apiserver errors are retried.
+func (a *AdmissionWebhookRegisterClient) UpdateCABundle(ctx context.Context, c *config.Config, caBundle []byte) error {
+	a.once.Do(a.initializeClient)
+	a.Logger.Infof("Updating caBundle of MutatingWebhookConfiguration %s", c.WebhookConfigurationName())
+	return retryPublish(c, func() error {
+		webhookConfig, err := a.client.MutatingWebhookConfigurations().Get(ctx, c.WebhookConfigurationName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for i := range webhookConfig.Webhooks {
+			webhookConfig.Webhooks[i].ClientConfig.CABundle = caBundle
+		}
+		_, err = a.client.MutatingWebhookConfigurations().Update(ctx, webhookConfig, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// CheckRegistration returns an error if the registered MutatingWebhookConfiguration doesn't exist or its caBundle
+// doesn't match the resolved CA bundle
+func (a *AdmissionWebhookRegisterClient) CheckRegistration(ctx context.Context, c *config.Config) error {
+	a.once.Do(a.initializeClient)
+	webhookConfig, err := a.client.MutatingWebhookConfigurations().Get(ctx, c.WebhookConfigurationName(), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range webhookConfig.Webhooks {
+		if !bytes.Equal(webhookConfig.Webhooks[i].ClientConfig.CABundle, c.GetOrResolveCABundle()) {
+			return errors.Errorf("caBundle of MutatingWebhookConfiguration %s webhook %s doesn't match", webhookConfig.Name, webhookConfig.Webhooks[i].Name)
+		}
+	}
+	return nil
+}
+
 // Unregister unregisters MutatingWebhookConfiguration based on passed config.Config
 func (a *AdmissionWebhookRegisterClient) Unregister(ctx context.Context, c *config.Config) error {
 	a.Logger.Infof("Starting to unregister MutatingWebhookConfiguration based config: %#v", c)
 	defer a.Logger.Infof("Unregister for config %#v is done", c)
 	a.once.Do(a.initializeClient)
-	return a.client.MutatingWebhookConfigurations().Delete(ctx, c.Name, metav1.DeleteOptions{})
+	if c.WebhookConfigName != "" {
+		// The configuration with the stable name may be already updated by the next admission webhook instance
+		webhookConfig, err := a.client.MutatingWebhookConfigurations().Get(ctx, c.WebhookConfigName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for i := range webhookConfig.Webhooks {
+			if !bytes.Equal(webhookConfig.Webhooks[i].ClientConfig.CABundle, c.GetOrResolveCABundle()) {
+				a.Logger.Infof("MutatingWebhookConfiguration %s is owned by another instance, skipping", c.WebhookConfigName)
+				return nil
+			}
+		}
+	}
+	return a.client.MutatingWebhookConfigurations().Delete(ctx, c.WebhookConfigurationName(), metav1.DeleteOptions{})
 }
@@ -0,0 +1,298 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	admissionregistrationv1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/config"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Name:                    "admission-webhook-k8s-abc",
+		ServiceName:             "admission-webhook-svc",
+		Namespace:               "nsm-system",
+		Annotation:              "networkservicemesh.io",
+		EnabledResources:        config.SupportedResources,
+		MatchPolicy:             "Equivalent",
+		AdmissionReviewVersions: []string{"v1"},
+		ServerPort:              443,
+		RegisterRetryAttempts:   5,
+	}
+}
+
+func newRegisterClient(objects ...runtime.Object) (*AdmissionWebhookRegisterClient, *fake.Clientset) {
+	clientset := fake.NewSimpleClientset(objects...)
+	a := &AdmissionWebhookRegisterClient{
+		Logger: zap.NewNop().Sugar(),
+		client: clientset.AdmissionregistrationV1(),
+	}
+	a.once.Do(func() {})
+	return a, clientset
+}
+
+func webhookConfiguration(name string, labels map[string]string) *admissionv1.MutatingWebhookConfiguration {
+	return &admissionv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Webhooks: []admissionv1.MutatingWebhook{
+			{
+				Name:         name + ".networkservicemesh.io",
+				ClientConfig: admissionv1.WebhookClientConfig{CABundle: []byte(name)},
+			},
+		},
+	}
+}
+
+func configurationNames(t *testing.T, client admissionregistrationv1.AdmissionregistrationV1Interface) []string {
+	t.Helper()
+	list, err := client.MutatingWebhookConfigurations().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list webhook configurations: %v", err)
+	}
+	var names []string
+	for i := range list.Items {
+		names = append(names, list.Items[i].Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func assertNames(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("unexpected webhook configurations: %v, expected: %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected webhook configurations: %v, expected: %v", got, want)
+		}
+	}
+}
+
+// failUpdates makes the first n updates of MutatingWebhookConfigurations fail with err and returns the number of the
+// update attempts.
+func failUpdates(clientset *fake.Clientset, n int, err error) *int {
+	var attempts int
+	clientset.PrependReactor("update", "mutatingwebhookconfigurations", func(k8stesting.Action) (bool, runtime.Object, error) {
+		attempts++
+		if attempts <= n {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	return &attempts
+}
+
+// conflict is the update conflict error of MutatingWebhookConfiguration.
+var conflict = apierrors.NewConflict(schema.GroupResource{Group: "admissionregistration.k8s.io", Resource: "mutatingwebhookconfigurations"}, "admission-webhook-k8s-abc", nil)
+
+func TestRegisterDeletesStaleConfigurations(t *testing.T) {
+	c := testConfig()
+	c.WebhookConfigName = "nsm-admission-webhook"
+	a, clientset := newRegisterClient(
+		webhookConfiguration("admission-webhook-k8s-old", c.WebhookConfigurationLabels()),
+		webhookConfiguration("foreign", map[string]string{OwnerServiceLabel: "other", OwnerNamespaceLabel: c.Namespace}),
+	)
+
+	if err := a.Register(context.Background(), c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertNames(t, configurationNames(t, clientset.AdmissionregistrationV1()), "foreign", "nsm-admission-webhook")
+}
+
+func TestRegisterKeepsConfigurationsOfOtherReplicas(t *testing.T) {
+	c := testConfig()
+	a, clientset := newRegisterClient(webhookConfiguration("admission-webhook-k8s-def", c.WebhookConfigurationLabels()))
+
+	if err := a.Register(context.Background(), c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertNames(t, configurationNames(t, clientset.AdmissionregistrationV1()), "admission-webhook-k8s-abc", "admission-webhook-k8s-def")
+}
+
+func TestUpdateCABundle(t *testing.T) {
+	c := testConfig()
+	configuration := webhookConfiguration(c.Name, c.WebhookConfigurationLabels())
+	configuration.Webhooks = append(configuration.Webhooks, admissionv1.MutatingWebhook{Name: "second.networkservicemesh.io"})
+	a, clientset := newRegisterClient(configuration)
+	attempts := failUpdates(clientset, 1, conflict)
+
+	if err := a.UpdateCABundle(context.Background(), c, []byte("new CA bundle")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *attempts != 2 {
+		t.Fatalf("expected the conflict to be retried once, got %d attempts", *attempts)
+	}
+	updated, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), c.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := range updated.Webhooks {
+		if got := string(updated.Webhooks[i].ClientConfig.CABundle); got != "new CA bundle" {
+			t.Fatalf("webhook %s has caBundle %q", updated.Webhooks[i].Name, got)
+		}
+	}
+}
+
+func TestRegisterTrustsPreviousCABundle(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		window   time.Duration
+		wantPrev bool
+	}{
+		{name: "rotation window", window: time.Hour, wantPrev: true},
+		{name: "no rotation window"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := testConfig()
+			c.WebhookMode = config.SelfregisterMode
+			c.WebhookConfigName = "nsm-admission-webhook"
+			c.CARotationWindow = tc.window
+			if err := c.Init(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			current := c.GetOrResolveCABundle()
+			previous := webhookConfiguration(c.WebhookConfigName, c.WebhookConfigurationLabels())
+			a, clientset := newRegisterClient(previous)
+
+			if err := a.Register(context.Background(), c); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			published, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), c.WebhookConfigName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			caBundle := published.Webhooks[0].ClientConfig.CABundle
+			if !bytes.Contains(caBundle, current) {
+				t.Fatalf("expected the current CA bundle to be published, got %q", caBundle)
+			}
+			if got := bytes.Contains(caBundle, []byte(c.WebhookConfigName)); got != tc.wantPrev {
+				t.Fatalf("expected the previous CA bundle to be published %v, got %q", tc.wantPrev, caBundle)
+			}
+		})
+	}
+}
+
+func TestRegisterUpdatesConfigurationWithStableName(t *testing.T) {
+	c := testConfig()
+	c.WebhookConfigName = "nsm-admission-webhook"
+	a, clientset := newRegisterClient(webhookConfiguration(c.WebhookConfigName, c.WebhookConfigurationLabels()))
+
+	if err := a.Register(context.Background(), c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertNames(t, configurationNames(t, clientset.AdmissionregistrationV1()), c.WebhookConfigName)
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "create" || action.GetVerb() == "delete" {
+			t.Fatalf("expected the existing configuration to be updated, got %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+	updated, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), c.WebhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updated.Webhooks) != 1 || updated.Webhooks[0].Name != c.Name+"."+c.Annotation {
+		t.Fatalf("expected the webhook of this instance, got %+v", updated.Webhooks)
+	}
+	if got := updated.Annotations[OwnerInstanceAnnotation]; got != c.Name {
+		t.Fatalf("expected owner instance %s, got %s", c.Name, got)
+	}
+}
+
+func TestCheckRegistration(t *testing.T) {
+	c := testConfig()
+	if err := c.Init(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, clientset := newRegisterClient()
+	if err := a.CheckRegistration(context.Background(), c); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	if err := a.Register(context.Background(), c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := a.CheckRegistration(context.Background(), c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	configuration, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), c.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configuration.Webhooks[0].ClientConfig.CABundle = []byte("another CA bundle")
+	if _, err = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(context.Background(), configuration, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = a.CheckRegistration(context.Background(), c); err == nil {
+		t.Fatal("expected an error for the mismatching caBundle")
+	}
+}
+
+func TestPublishRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		failures     int
+		err          error
+		wantAttempts int
+		wantErr      string
+	}{
+		{name: "transient error", failures: 2, err: apierrors.NewServiceUnavailable("etcd is unavailable"), wantAttempts: 3},
+		{name: "exhausted", failures: 10, err: conflict, wantAttempts: 3, wantErr: "after 3 attempts"},
+		{name: "not retriable", failures: 10, err: apierrors.NewBadRequest("invalid webhook"), wantAttempts: 1, wantErr: "invalid webhook"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := testConfig()
+			c.WebhookConfigName = c.Name
+			c.RegisterRetryAttempts = 3
+			a, clientset := newRegisterClient(webhookConfiguration(c.Name, c.WebhookConfigurationLabels()))
+			attempts := failUpdates(clientset, tc.failures, tc.err)
+
+			err := a.Register(context.Background(), c)
+			if *attempts != tc.wantAttempts {
+				t.Fatalf("expected %d attempts, got %d", tc.wantAttempts, *attempts)
+			}
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics contains OpenTelemetry metrics of cmd-admission-webhook-k8s
+package metrics
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const meterName = "cmd-admission-webhook"
+
+// These are the reasons of skipped mutations.
+const (
+	// SkipReasonNoAnnotation means that neither the resource nor its namespace has Config.Annotation
+	SkipReasonNoAnnotation = "no_annotation"
+	// SkipReasonSelectorMismatch means that the pod doesn't match Config.InjectPodSelector
+	SkipReasonSelectorMismatch = "selector_mismatch"
+	// SkipReasonDisabledResource means that the resource is not listed in Config.EnabledResources
+	SkipReasonDisabledResource = "disabled_resource"
+	// SkipReasonSubresource means that the request targets a subresource, e.g. pods/status
+	SkipReasonSubresource = "subresource"
+	// SkipReasonTerminating means that the object has a deletion timestamp
+	SkipReasonTerminating = "terminating"
+	// SkipReasonAlreadyInjected means that the updated object already has the desired injection
+	SkipReasonAlreadyInjected = "already_injected"
+	// SkipReasonPercentage means that the resource is not selected by Config.InjectionPercentage
+	SkipReasonPercentage = "percentage"
+	// SkipReasonBypass means that the bypass mode is on
+	SkipReasonBypass = "bypass"
+	// SkipReasonLargeObject means that the object exceeds the large object thresholds
+	SkipReasonLargeObject = "large_object"
+	// SkipReasonMissingKind means that Config.RequireCRD is not served by the apiserver
+	SkipReasonMissingKind = "missing_kind"
+)
+
+var (
+	reviews           = mustInt64Counter("webhook_admission_reviews_total", "Number of admission review requests")
+	skipped           = mustInt64Counter("webhook_skipped_total", "Number of admitted resources that were not mutated")
+	svidCheckFailures = mustInt64Counter("spire_svid_check_failures_total", "Number of failed spire x509 source checks")
+	svidReconnects    = mustInt64Counter("spire_x509_source_reconnect_failures_total", "Number of failed attempts to reconnect spire x509 source")
+	percentageChecks  = mustInt64Counter("webhook_percentage_decisions_total", "Number of Config.InjectionPercentage decisions")
+	largeObjects      = mustInt64Counter("webhook_large_objects_total", "Number of admitted resources that exceeded the large object thresholds")
+	patchBytes        = mustInt64Histogram("webhook_patch_bytes", "Size of JSON patches of the mutated resources", "By")
+)
+
+// RecordReview records the admission review request of the resource of kind. Empty namespace is omitted.
+func RecordReview(ctx context.Context, kind, operation, namespace string) {
+	reviews.Add(ctx, 1, metric.WithAttributes(withNamespace(namespace, attribute.String("kind", kind), attribute.String("operation", operation))...))
+}
+
+// RecordSkip records that the resource was not mutated because of reason. Empty namespace is omitted.
+func RecordSkip(ctx context.Context, reason, namespace string) {
+	skipped.Add(ctx, 1, metric.WithAttributes(withNamespace(namespace, attribute.String("reason", reason))...))
+}
+
+// RecordPercentageDecision records whether the resource was selected for injection by Config.InjectionPercentage.
+func RecordPercentageDecision(ctx context.Context, inject bool) {
+	percentageChecks.Add(ctx, 1, metric.WithAttributes(attribute.Bool("inject", inject)))
+}
+
+// RecordPatchSize records the size of the JSON patch of the mutated resource of kind. Empty namespace is omitted.
+func RecordPatchSize(ctx context.Context, kind, namespace string, size int) {
+	patchBytes.Record(ctx, int64(size), metric.WithAttributes(withNamespace(namespace, attribute.String("kind", kind))...))
+}
+
+// RecordLargeObject records that the resource of kind exceeded the large object thresholds.
+func RecordLargeObject(ctx context.Context, kind string) {
+	largeObjects.Add(ctx, 1, metric.WithAttributes(attribute.String("kind", kind)))
+}
+
+// RecordSVIDCheckFailure records that spire x509 source didn't provide a valid SVID.
+func RecordSVIDCheckFailure(ctx context.Context) {
+	svidCheckFailures.Add(ctx, 1)
+}
+
+// RecordSVIDReconnectFailure records that spire x509 source couldn't be reconnected.
+func RecordSVIDReconnectFailure(ctx context.Context) {
+	svidReconnects.Add(ctx, 1)
+}
+
+// withNamespace appends the namespace attribute to attrs unless namespace is empty.
+func withNamespace(namespace string, attrs ...attribute.KeyValue) []attribute.KeyValue {
+	if namespace == "" {
+		return attrs
+	}
+	return append(attrs, attribute.String("namespace", namespace))
+}
+
+func mustInt64Counter(name, description string) metric.Int64Counter {
+	counter, err := otel.Meter(meterName).Int64Counter(name, metric.WithDescription(description))
+	if err != nil {
+		panic(err.Error())
+	}
+	return counter
+}
+
+func mustInt64Histogram(name, description, unit string) metric.Int64Histogram {
+	histogram, err := otel.Meter(meterName).Int64Histogram(name, metric.WithDescription(description), metric.WithUnit(unit))
+	if err != nil {
+		panic(err.Error())
+	}
+	return histogram
+}
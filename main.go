@@ -19,18 +19,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -41,32 +47,103 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
 	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"gomodules.xyz/jsonpatch/v2"
 	admissionv1 "k8s.io/api/admission/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/client-go/kubernetes"
 	psa "k8s.io/pod-security-admission/api"
 
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/cert"
 	"github.com/networkservicemesh/cmd-admission-webhook/internal/config"
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/health"
 	"github.com/networkservicemesh/cmd-admission-webhook/internal/k8s"
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/metrics"
 	kubeutils "github.com/networkservicemesh/sdk-k8s/pkg/tools/k8s"
 	"github.com/networkservicemesh/sdk/pkg/tools/nsurl"
 	"github.com/networkservicemesh/sdk/pkg/tools/opentelemetry"
 	"github.com/networkservicemesh/sdk/pkg/tools/pprofutils"
 )
 
+const (
+	tracerName = "cmd-admission-webhook"
+	// projectedSATokenVolume is the name of the injected volume with the projected service account token
+	projectedSATokenVolume = "nsm-projected-sa-token"
+	// ephemeralContainersSubresource is the subresource of pods used to add ephemeral containers
+	ephemeralContainersSubresource = "ephemeralcontainers"
+	// spireWaitContainerName is the name of the init container waiting for the SPIRE agent socket
+	spireWaitContainerName = "nsm-spire-wait"
+	// spireAgentSocket is the path of the SPIRE agent socket in the injected containers
+	spireAgentSocket = "/run/spire/sockets/agent.sock"
+)
+
 var deserializer = serializer.NewCodecFactory(runtime.NewScheme()).UniversalDeserializer()
 
+// errNetworkServiceRequired means that the resource has Config.Annotation without network services while
+// Config.RequireNetworkService is set
+var errNetworkServiceRequired = errors.New("annotation has no network services")
+
+// errTooManyContainers means that more than Config.MaxInjectedContainers containers would be injected
+var errTooManyContainers = errors.New("too many injected containers")
+
 type admissionWebhookServer struct {
 	config    *config.Config
 	logger    *zap.SugaredLogger
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
+	// initialized is closed when config.Config lazy initialization is done
+	initialized chan struct{}
+	// nativeSidecars is set if the containers from Config.ContainerImages are injected as native sidecars
+	nativeSidecars bool
+	// namespaces caches the namespace lookups if Config.HonorNamespaceAnnotation is set
+	namespaces *k8s.NamespaceCache
+	// bypass is set if all admission requests are passed through without mutation
+	bypass atomic.Bool
+	// requiredKind is Config.RequireCRD. All admission requests are passed through while it's absent.
+	requiredKind *k8s.RequiredKind
+	// mutators are the mutators of the admitted objects by their kinds
+	mutators map[schema.GroupVersionKind]mutator
+}
+
+// mutator builds JSON patch of the admitted object of a registered kind. nil patch admits the object without changes.
+// The object is denied if resp.Allowed is set to false.
+type mutator interface {
+	Mutate(ctx context.Context, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) ([]byte, error)
+}
+
+// mutatorFunc is a function implementing mutator.
+type mutatorFunc func(ctx context.Context, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) ([]byte, error)
+
+// Mutate calls f.
+func (f mutatorFunc) Mutate(ctx context.Context, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) ([]byte, error) {
+	return f(ctx, in, resp)
+}
+
+// mutationTarget is a resource that has been matched for the mutation.
+type mutationTarget struct {
+	kind       string
+	path       string
+	annotation string
+	podMeta    *v1.ObjectMeta
+	spec       *corev1.PodSpec
+	namespace  *corev1.Namespace
+	// tests are the patch test operations of Config.EmitPatchTests. They're created before the spec is changed.
+	tests []jsonpatch.JsonPatchOperation
+	// removedSidecars is set if the update removes injected containers, so Config.RemovedSidecarsAnnotation is patched
+	removedSidecars bool
 }
 
 func (s *admissionWebhookServer) Review(ctx context.Context, in *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
@@ -79,70 +156,807 @@ func (s *admissionWebhookServer) Review(ctx context.Context, in *admissionv1.Adm
 	escapedIn = strings.ReplaceAll(escapedIn, "\r", "")
 	s.logger.Infof("Incoming request: %v", escapedIn)
 	defer logResponse(s.logger, resp)
+	metrics.RecordReview(ctx, in.Kind.Kind, string(in.Operation), s.metricNamespace(in))
 
-	if in.Operation != admissionv1.Create {
+	if s.bypass.Load() {
+		s.logger.Warnf("BYPASS MODE: passing through request %v without mutation", in.UID)
+		s.skip(ctx, in, metrics.SkipReasonBypass)
 		resp.Allowed = true
 		return resp
 	}
-	podMetaPtr, spec := s.unmarshal(in)
+	if s.requiredKind != nil && !s.requiredKind.Present() {
+		s.skip(ctx, in, metrics.SkipReasonMissingKind)
+		resp.Allowed = true
+		return resp
+	}
+
+	if timeout := s.config.EffectiveHandlerTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if in.SubResource != "" {
+		return s.reviewSubresource(ctx, in, resp)
+	}
+	return s.dispatch(ctx, in, resp)
+}
+
+// dispatch admits the object with the patch of the mutator registered for its kind. Objects of the kinds without
+// a registered mutator are admitted without changes.
+func (s *admissionWebhookServer) dispatch(ctx context.Context, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) *admissionv1.AdmissionResponse {
+	resp.Allowed = true
+	m, ok := s.mutators[schema.GroupVersionKind{Group: in.Kind.Group, Version: in.Kind.Version, Kind: in.Kind.Kind}]
+	if !ok {
+		return resp
+	}
+	patch, err := m.Mutate(ctx, in, resp)
+	if err != nil {
+		return s.mutationError(in, resp, err)
+	}
+	if patch != nil {
+		s.observePatch(ctx, in, patch)
+		resp.Patch = patch
+		var t = admissionv1.PatchTypeJSONPatch
+		resp.PatchType = &t
+	}
+	return resp
+}
+
+// mutateWorkload returns the patch that injects NSM into the pod or the pod template of the workload.
+func (s *admissionWebhookServer) mutateWorkload(ctx context.Context, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) ([]byte, error) {
+	if !s.isMutatedOperation(in) {
+		return nil, nil
+	}
+	if !s.config.IsResourceEnabled(in.Resource.Resource) {
+		s.skip(ctx, in, metrics.SkipReasonDisabledResource)
+		return nil, nil
+	}
+	// Mutation of the object that is being deleted is pointless and may cause update conflicts
+	if isTerminating(in.Object.Raw) {
+		s.skip(ctx, in, metrics.SkipReasonTerminating)
+		return nil, nil
+	}
+
+	if !s.waitInitialized(ctx) {
+		s.logger.Warnf("Initialization is not done yet, passing through request %v", in.UID)
+		addWarning(resp, "networkservicemesh admission webhook is starting, the resource is not mutated")
+		return nil, nil
+	}
+
+	matchCtx, matchSpan := s.startSpan(ctx, "match-check")
+	target, err := s.match(matchCtx, in, resp)
+	matchSpan.End()
+	if err != nil || target == nil {
+		return nil, err
+	}
+	if in.Operation == admissionv1.Update {
+		if target = s.prepareUpdate(ctx, in, target); target == nil {
+			return nil, nil
+		}
+	}
+	if s.rejectServiceAccount(resp, target) {
+		return nil, nil
+	}
+	_, patchSpan := s.startSpan(ctx, "patch-build")
+	defer patchSpan.End()
+	return s.createPatch(resp, target)
+}
+
+// mutateNamespace returns the patch that adds Config.Labels to the created namespace that has Config.Annotation if
+// Config.EnableNamespaceMutation is set. The other namespace requests are passed through.
+func (s *admissionWebhookServer) mutateNamespace(ctx context.Context, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) ([]byte, error) {
+	if !s.config.EnableNamespaceMutation || in.Operation != admissionv1.Create || len(s.config.Labels) == 0 {
+		return nil, nil
+	}
+	var namespace corev1.Namespace
+	if err := json.Unmarshal(in.Object.Raw, &namespace); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal Namespace")
+	}
+	if annotation, _ := s.lookupAnnotation(resp, namespace.Annotations); annotation == "" {
+		s.skip(ctx, in, metrics.SkipReasonNoAnnotation)
+		return nil, nil
+	}
+	if namespace.Labels == nil {
+		namespace.Labels = make(map[string]string)
+	}
+	return json.Marshal([]jsonpatch.JsonPatchOperation{s.createLabelPatch("/", namespace.Labels)})
+}
+
+// reviewSubresource passes through the requests of subresources. Only ephemeral containers are injected if
+// Config.EnableEphemeralInjection is set, the resources themselves are mutated otherwise.
+func (s *admissionWebhookServer) reviewSubresource(ctx context.Context, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) *admissionv1.AdmissionResponse {
+	if in.SubResource == ephemeralContainersSubresource && s.config.EnableEphemeralInjection {
+		return s.reviewEphemeralContainers(ctx, in, resp)
+	}
+	resp.Allowed = true
+	if !s.isMutatedOperation(in) {
+		return resp
+	}
+	if !s.config.IsSubresourceIgnored(in.SubResource) {
+		s.logger.Warnf("Unsupported subresource %s/%s, passing through request %v", in.Resource.Resource, in.SubResource, in.UID)
+	}
+	s.skip(ctx, in, metrics.SkipReasonSubresource)
+	return resp
+}
+
+// reviewEphemeralContainers adds the debug container to the ephemeral containers of the annotated pod. The volumes
+// that the pod doesn't have are not mounted, so the container works in the pods created without injection as well.
+func (s *admissionWebhookServer) reviewEphemeralContainers(ctx context.Context, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) *admissionv1.AdmissionResponse {
+	resp.Allowed = true
+	img := s.ephemeralContainerImage()
+	if in.Operation != admissionv1.Update || img == "" {
+		return resp
+	}
+	if !s.waitInitialized(ctx) {
+		s.logger.Warnf("Initialization is not done yet, passing through request %v", in.UID)
+		addWarning(resp, "networkservicemesh admission webhook is starting, the ephemeral container is not injected")
+		return resp
+	}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(in.Object.Raw, &pod); err != nil {
+		return s.mutationError(in, resp, errors.Wrap(err, "failed to unmarshal Pod"))
+	}
+	namespace, err := s.getNamespace(ctx, in.Namespace)
+	if err != nil {
+		return s.mutationError(in, resp, err)
+	}
+	annotation, err := s.resolveAnnotation(resp, "Pod", &pod.ObjectMeta, namespace)
+	if err != nil {
+		return s.mutationError(in, resp, err)
+	}
+	if annotation == "" {
+		s.skip(ctx, in, metrics.SkipReasonNoAnnotation)
+		return resp
+	}
+	for i := range pod.Spec.EphemeralContainers {
+		if pod.Spec.EphemeralContainers[i].Name == nameOf(img) {
+			s.skip(ctx, in, metrics.SkipReasonAlreadyInjected)
+			return resp
+		}
+	}
+
+	c := s.newSidecar(img, &injection{
+		psaLevel: psaLevelByNamespace(namespace),
+		envVars:  s.sidecarEnvs(annotation, &pod.ObjectMeta),
+	})
+	// Ephemeral containers can't have resources
+	c.Resources = corev1.ResourceRequirements{}
+	c.VolumeMounts = withExistingVolumes(c.VolumeMounts, pod.Spec.Volumes)
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon(c),
+	})
+	patch, err := json.Marshal([]jsonpatch.JsonPatchOperation{
+		jsonpatch.NewOperation("add", "/spec/ephemeralContainers", pod.Spec.EphemeralContainers),
+	})
+	if err != nil {
+		return s.mutationError(in, resp, err)
+	}
+	s.observePatch(ctx, in, patch)
+	resp.Patch = patch
+	var t = admissionv1.PatchTypeJSONPatch
+	resp.PatchType = &t
+	return resp
+}
+
+// observePatch records the size of patch and warns if it exceeds Config.PatchSizeWarningBytes.
+func (s *admissionWebhookServer) observePatch(ctx context.Context, in *admissionv1.AdmissionRequest, patch []byte) {
+	metrics.RecordPatchSize(ctx, in.Kind.Kind, s.metricNamespace(in), len(patch))
+	s.logger.Debugw("Mutation patch", "uid", in.UID, "kind", in.Kind.Kind, "namespace", in.Namespace, "name", displayName(in), "patch", string(patch))
+	if s.config.PatchSizeWarningBytes > 0 && len(patch) > s.config.PatchSizeWarningBytes {
+		s.logger.Warnf("Patch of %s %s/%s is %d bytes, which exceeds %d bytes", in.Kind.Kind, in.Namespace, displayName(in), len(patch), s.config.PatchSizeWarningBytes)
+	}
+}
+
+// ephemeralContainerImage returns Config.EphemeralContainerImage or the first of Config.ContainerImages if it's empty.
+func (s *admissionWebhookServer) ephemeralContainerImage() string {
+	if s.config.EphemeralContainerImage != "" {
+		return s.config.EphemeralContainerImage
+	}
+	if images := s.config.GetOrResolveContainerImages(); len(images) > 0 {
+		return images[0]
+	}
+	return ""
+}
+
+// withExistingVolumes returns the mounts of the volumes listed in volumes.
+func withExistingVolumes(mounts []corev1.VolumeMount, volumes []corev1.Volume) []corev1.VolumeMount {
+	names := make(map[string]bool, len(volumes))
+	for i := range volumes {
+		names[volumes[i].Name] = true
+	}
+	var result []corev1.VolumeMount
+	for _, m := range mounts {
+		if names[m.Name] {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// match returns the mutationTarget for the admitted resource or nil if the resource shouldn't be mutated.
+func (s *admissionWebhookServer) match(ctx context.Context, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) (*mutationTarget, error) {
+	podMetaPtr, spec, err := s.unmarshal(in)
+	if err != nil {
+		return nil, err
+	}
 	p := ""
 	if in.Kind.Kind != "Pod" {
 		p = "/spec/template"
 	}
 	p = path.Join("/", p)
 
+	namespace, err := s.getNamespace(ctx, in.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec == nil && podMetaPtr == nil {
+		return nil, nil
+	}
+	if s.skipLargeObject(ctx, in, resp, spec) {
+		return nil, nil
+	}
+	annotation, err := s.resolveAnnotation(resp, in.Kind.Kind, podMetaPtr, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if annotation == "" {
+		s.skip(ctx, in, metrics.SkipReasonNoAnnotation)
+		return nil, nil
+	}
+
+	if !s.config.GetOrResolvePodSelector().Matches(labels.Set(podMetaPtr.Labels)) {
+		s.skip(ctx, in, metrics.SkipReasonSelectorMismatch)
+		return nil, nil
+	}
+	if !s.isSelectedByPercentage(ctx, in) {
+		s.skip(ctx, in, metrics.SkipReasonPercentage)
+		return nil, nil
+	}
+	target := &mutationTarget{
+		kind:       in.Kind.Kind,
+		path:       p,
+		annotation: annotation,
+		podMeta:    podMetaPtr,
+		spec:       spec,
+		namespace:  namespace,
+	}
+	if s.config.EmitPatchTests {
+		target.tests = createPatchTests(p, spec)
+	}
+	return target, nil
+}
+
+// skipLargeObject returns true if the admitted object exceeds Config.LargeObjectBytes or Config.LargeObjectContainers
+// and Config.LargeObjectPolicy is skip.
+func (s *admissionWebhookServer) skipLargeObject(ctx context.Context, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse, spec *corev1.PodSpec) bool {
+	size, containers := len(in.Object.Raw), len(spec.InitContainers)+len(spec.Containers)
+	if (s.config.LargeObjectBytes == 0 || size <= s.config.LargeObjectBytes) &&
+		(s.config.LargeObjectContainers == 0 || containers <= s.config.LargeObjectContainers) {
+		return false
+	}
+	metrics.RecordLargeObject(ctx, in.Kind.Kind)
+	s.logger.Warnf("%s %s/%s is too large: %d bytes, %d containers", in.Kind.Kind, in.Namespace, displayName(in), size, containers)
+	if s.config.LargeObjectPolicy != config.SkipLargeObjectPolicy {
+		return false
+	}
+	addWarning(resp, "networkservicemesh admission webhook doesn't mutate objects larger than %d bytes or with more than %d containers",
+		s.config.LargeObjectBytes, s.config.LargeObjectContainers)
+	s.skip(ctx, in, metrics.SkipReasonLargeObject)
+	return true
+}
+
+// isMutatedOperation returns true for CREATE requests and, if Config.MutateOnUpdate is set, for UPDATE requests of
+// workloads. Containers of existing pods can't be changed, so pod updates are never mutated.
+func (s *admissionWebhookServer) isMutatedOperation(in *admissionv1.AdmissionRequest) bool {
+	return in.Operation == admissionv1.Create ||
+		in.Operation == admissionv1.Update && s.config.MutateOnUpdate && in.Kind.Kind != "Pod"
+}
+
+// prepareUpdate returns nil if the updated target already has the desired injection. Otherwise it removes the
+// previously injected containers and volumes from the target, so they're replaced with the desired ones.
+func (s *admissionWebhookServer) prepareUpdate(ctx context.Context, in *admissionv1.AdmissionRequest, target *mutationTarget) *mutationTarget {
+	if s.config.AllowSidecarRemoval {
+		s.recordRemovedSidecars(in, target)
+	}
+	images := append(append([]string(nil), s.config.GetOrResolveInitContainerImages()...),
+		s.sidecarImages(new(admissionv1.AdmissionResponse), target.podMeta.Annotations)...)
+	containers := append(append([]corev1.Container(nil), target.spec.InitContainers...), target.spec.Containers...)
+	existing := make(map[string]*corev1.Container, len(containers))
+	for i := range containers {
+		existing[containers[i].Name] = &containers[i]
+	}
+
+	injected := true
+	names := make(map[string]bool)
+	for _, img := range images {
+		names[nameOf(img)] = true
+		injected = injected && s.isInjected(existing[nameOf(img)], img, target.annotation)
+	}
+	if injected && !target.removedSidecars {
+		s.skip(ctx, in, metrics.SkipReasonAlreadyInjected)
+		return nil
+	}
+	names[spireWaitContainerName] = true
+
+	target.spec.InitContainers = s.withoutContainers(target.spec.InitContainers, names)
+	target.spec.Containers = s.withoutContainers(target.spec.Containers, names)
+	var volumes []corev1.Volume
+	for _, v := range target.spec.Volumes {
+		if v.Name != "spire-agent-socket" && v.Name != "nsm-socket" && v.Name != projectedSATokenVolume {
+			volumes = append(volumes, v)
+		}
+	}
+	target.spec.Volumes = volumes
+	return target
+}
+
+// isInjected returns true if the existing container c is injected for img with the network services of annotation.
+// NSURL env is compared only if c has it, since the sidecars have no envs with some Config.EnvTarget values.
+func (s *admissionWebhookServer) isInjected(c *corev1.Container, img, annotation string) bool {
+	if c == nil || c.Image != img {
+		return false
+	}
+	if env := envByName(c, s.nsURLEnvName(c.Name)); env != nil {
+		return env.Value == annotation
+	}
+	return true
+}
+
+// recordRemovedSidecars adds the containers from Config.ContainerImages that are present in the old object, but are
+// removed by the update, to Config.RemovedSidecarsAnnotation of the target, so they aren't injected again.
+func (s *admissionWebhookServer) recordRemovedSidecars(in *admissionv1.AdmissionRequest, target *mutationTarget) {
+	old := *in
+	old.Object = in.OldObject
+	_, oldSpec, err := s.unmarshal(&old)
+	if err != nil || oldSpec == nil {
+		return
+	}
+	oldNames, newNames := containerNames(oldSpec), containerNames(target.spec)
+	removed := s.removedSidecars(target.podMeta.Annotations)
+	if removed == nil {
+		removed = make(map[string]bool)
+	}
+	for _, img := range s.sidecarImages(new(admissionv1.AdmissionResponse), target.podMeta.Annotations) {
+		if name := nameOf(img); oldNames[name] && !newNames[name] {
+			removed[name] = true
+			target.removedSidecars = true
+		}
+	}
+	if !target.removedSidecars {
+		return
+	}
+	sorted := make([]string, 0, len(removed))
+	for name := range removed {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	if target.podMeta.Annotations == nil {
+		target.podMeta.Annotations = make(map[string]string)
+	}
+	target.podMeta.Annotations[s.config.RemovedSidecarsAnnotation] = strings.Join(sorted, ",")
+	s.logger.Infof("%s %s/%s: containers %v are removed by the update and aren't injected again", in.Kind.Kind, in.Namespace, displayName(in), sorted)
+}
+
+// containerNames returns the names of the init containers and containers of spec.
+func containerNames(spec *corev1.PodSpec) map[string]bool {
+	result := make(map[string]bool)
+	for _, c := range append(append([]corev1.Container(nil), spec.InitContainers...), spec.Containers...) {
+		result[c.Name] = true
+	}
+	return result
+}
+
+// withoutContainers returns containers without the named ones. Config.ProtectedContainers are kept.
+func (s *admissionWebhookServer) withoutContainers(containers []corev1.Container, names map[string]bool) []corev1.Container {
+	var result []corev1.Container
+	for i := range containers {
+		if !names[containers[i].Name] || s.config.IsContainerProtected(containers[i].Name) {
+			result = append(result, containers[i])
+		}
+	}
+	return result
+}
+
+// serviceAccountName returns the service account of spec. "default" is used by k8s if it's not set.
+func serviceAccountName(spec *corev1.PodSpec) string {
+	if spec.ServiceAccountName != "" {
+		return spec.ServiceAccountName
+	}
+	if spec.DeprecatedServiceAccount != "" {
+		return spec.DeprecatedServiceAccount
+	}
+	return "default"
+}
+
+// rejectServiceAccount fills the response denying the target and returns true if the target's service account differs
+// from Config.RequireServiceAccount and Config.ServiceAccountPolicy is 'reject'.
+func (s *admissionWebhookServer) rejectServiceAccount(resp *admissionv1.AdmissionResponse, target *mutationTarget) bool {
+	if s.config.RequireServiceAccount == "" || s.config.ServiceAccountPolicy != config.RejectServiceAccountPolicy ||
+		serviceAccountName(target.spec) == s.config.RequireServiceAccount {
+		return false
+	}
+	resp.Allowed = false
+	resp.Result = &v1.Status{
+		Status: v1.StatusFailure,
+		Message: fmt.Sprintf("networkservicemesh admission webhook requires service account %s, but %s is used",
+			s.config.RequireServiceAccount, serviceAccountName(target.spec)),
+		Reason: v1.StatusReasonForbidden,
+		Code:   http.StatusForbidden,
+	}
+	return true
+}
+
+// resolveAnnotation returns the value of Config.Annotation of the resource. Pods (or all resources if
+// Config.HonorNamespaceAnnotation is set) without their own annotation use the annotation of the namespace.
+// Config.DefaultNetworkService is used for the present annotation without value.
+func (s *admissionWebhookServer) resolveAnnotation(resp *admissionv1.AdmissionResponse, kind string, podMeta *v1.ObjectMeta, namespace *corev1.Namespace) (string, error) {
+	annotation, found := s.lookupAnnotation(resp, podMeta.Annotations)
+	if annotation == "" && (kind == "Pod" || s.config.HonorNamespaceAnnotation) && namespace != nil {
+		if v, ok := s.lookupAnnotation(resp, namespace.Annotations); ok {
+			annotation, found = v, true
+		}
+	}
+	if !found || strings.TrimSpace(annotation) != "" {
+		return annotation, nil
+	}
+	if s.config.DefaultNetworkService != "" {
+		return s.config.DefaultNetworkService, nil
+	}
+	if s.config.RequireNetworkService {
+		return "", errNetworkServiceRequired
+	}
+	return "", nil
+}
+
+// getNamespace returns the namespace or nil if it can't be found. An error is returned only if ctx is done.
+func (s *admissionWebhookServer) getNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
 	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
-	namespace, err := s.clientset.CoreV1().Namespaces().Get(timeoutCtx, in.Namespace, v1.GetOptions{})
+	var namespace *corev1.Namespace
+	var err error
+	if s.namespaces != nil {
+		namespace, err = s.namespaces.Get(timeoutCtx, name)
+	} else {
+		namespace, err = s.clientset.CoreV1().Namespaces().Get(timeoutCtx, name, v1.GetOptions{})
+	}
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "admission request timed out")
+		}
 		s.logger.Errorf("failed to get namespace by name: %v", err)
+		return nil, nil
 	}
+	return namespace, nil
+}
 
-	if spec == nil && podMetaPtr == nil {
-		resp.Allowed = true
-		return resp
+// isTerminating returns true if the admitted object has a deletion timestamp.
+func isTerminating(raw []byte) bool {
+	var meta v1.PartialObjectMetadata
+	return json.Unmarshal(raw, &meta) == nil && meta.DeletionTimestamp != nil
+}
+
+// isSelectedByPercentage returns true if the admitted object falls into Config.InjectionPercentage of objects. The
+// decision depends only on the object identity from percentageKey, so it's the same for every request.
+func (s *admissionWebhookServer) isSelectedByPercentage(ctx context.Context, in *admissionv1.AdmissionRequest) bool {
+	if s.config.InjectionPercentage >= 100 {
+		return true
 	}
-	annotation := podMetaPtr.Annotations[s.config.Annotation]
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(percentageKey(in)))
+	selected := int(h.Sum32()%100) < s.config.InjectionPercentage
+	metrics.RecordPercentageDecision(ctx, selected)
+	return selected
+}
 
-	if annotation == "" && in.Kind.Kind != "Pod" {
-		resp.Allowed = true
-		return resp
+// percentageKey returns the key of the percentage decision of the admitted object. Objects created with generateName
+// have no name on admission, so generateName is combined with the UID of the controller owner or, if there is none,
+// with the pod-template-hash label. The pods of the same owner are decided together then, and the decision doesn't
+// change when they are recreated.
+func percentageKey(in *admissionv1.AdmissionRequest) string {
+	if in.Name != "" {
+		return in.Namespace + "/" + in.Name
+	}
+	var meta v1.PartialObjectMetadata
+	_ = json.Unmarshal(in.Object.Raw, &meta)
+	if meta.Name != "" {
+		return in.Namespace + "/" + meta.Name
+	}
+	key := in.Namespace + "/" + meta.GenerateName
+	if owner := v1.GetControllerOfNoCopy(&meta); owner != nil {
+		return key + "/" + string(owner.UID)
+	}
+	return key + "/" + meta.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+}
+
+// skip records and logs that the admitted resource is not mutated because of reason.
+func (s *admissionWebhookServer) skip(ctx context.Context, in *admissionv1.AdmissionRequest, reason string) {
+	metrics.RecordSkip(ctx, reason, s.metricNamespace(in))
+	s.logger.Debugf("Skipping %s %s/%s: %s", in.Kind.Kind, in.Namespace, displayName(in), reason)
+}
+
+// metricNamespace returns the namespace label of the metrics of the admitted resource. It's empty unless
+// Config.NamespaceMetricLabel is set, so the metrics cardinality doesn't grow with the number of namespaces.
+func (s *admissionWebhookServer) metricNamespace(in *admissionv1.AdmissionRequest) string {
+	if !s.config.NamespaceMetricLabel {
+		return ""
+	}
+	return in.Namespace
+}
+
+// displayName returns the name of the admitted object for logs. Objects created with generateName have no name on
+// admission, so generateName and the request UID are used for them instead.
+func displayName(in *admissionv1.AdmissionRequest) string {
+	if in.Name != "" {
+		return in.Name
+	}
+	var meta v1.PartialObjectMetadata
+	if json.Unmarshal(in.Object.Raw, &meta) == nil && meta.Name != "" {
+		return meta.Name
+	}
+	return fmt.Sprintf("%s<generated>(uid: %s)", meta.GenerateName, in.UID)
+}
+
+// createPatch returns JSON patch that injects NSM into the target.
+func (s *admissionWebhookServer) createPatch(resp *admissionv1.AdmissionResponse, target *mutationTarget) ([]byte, error) {
+	p, spec, podMetaPtr := target.path, target.spec, target.podMeta
+
+	envVars := s.sidecarEnvs(target.annotation, podMetaPtr)
+
+	if s.config.WarnFloatingImageTags {
+		s.warnFloatingImageTags(resp, spec)
 	}
 
-	// use namespace annotation only if resource doesn't have its own
-	if annotation == "" && in.Kind.Kind == "Pod" && namespace != nil {
-		annotation = namespace.Annotations[s.config.Annotation]
+	adjustForHostNetwork := s.config.AdjustForHostNetwork && spec.HostNetwork
+	if adjustForHostNetwork {
+		envVars = withoutPodIPEnvs(envVars)
 	}
 
-	if annotation != "" {
-		nsmNameEnv := corev1.EnvVar{Name: "NSM_NAME", Value: "$(POD_NAME)"}
-		if podMetaPtr.GenerateName == "" {
-			clientID := uuid.NewString()
-			nsmNameEnv.Value = fmt.Sprintf("$(POD_NAME)-%v", clientID)
+	inj := &injection{
+		psaLevel:       psaLevelByNamespace(target.namespace),
+		envVars:        envVars,
+		resources:      s.sidecarResources(resp, target.kind, podMetaPtr),
+		initImages:     s.config.GetOrResolveInitContainerImages(),
+		images:         s.sidecarImages(resp, podMetaPtr.Annotations),
+		skipContainers: s.skipContainers(podMetaPtr.Annotations),
+	}
+	inj.appVolumeMounts = s.appVolumeMounts(resp, podMetaPtr.Annotations, spec.Volumes)
+	if err := s.limitInjectedContainers(resp, inj); err != nil {
+		return nil, err
+	}
+	sidecars := s.createSidecars(inj)
+	var nativeSidecars []corev1.Container
+	if s.nativeSidecars {
+		nativeSidecars, sidecars = sidecars, nil
+	}
+	patchOps := []jsonpatch.JsonPatchOperation{
+		s.createInitContainerPatch(p, target.annotation, spec.InitContainers, nativeSidecars, inj),
+		s.createContainerPatch(p, spec.Containers, sidecars, inj),
+		s.createVolumesPatch(p, spec.Volumes, inj.psaLevel),
+		s.createLabelPatch(p, podMetaPtr.Labels),
+	}
+	if len(s.config.InjectAnnotations) > 0 {
+		// Template annotations of workloads are copied from the workload, so they're not patched back
+		var ownAnnotations map[string]string
+		if target.kind == "Pod" {
+			ownAnnotations = podMetaPtr.Annotations
 		}
-		envVars := append(s.config.GetOrResolveEnvs(),
-			corev1.EnvVar{Name: s.config.NSURLEnvName, Value: annotation},
-			nsmNameEnv)
+		patchOps = append(patchOps, s.createAnnotationPatch(p, ownAnnotations))
+	}
+	if s.config.EmitAuditAnnotations {
+		// The apiserver prefixes the keys with the webhook name
+		resp.AuditAnnotations = map[string]string{
+			"injected-init-containers": strconv.Itoa(len(inj.initImages)),
+			"injected-containers":      strconv.Itoa(len(inj.images)),
+			"network-services":         target.annotation,
+		}
+	}
+	if target.removedSidecars {
+		// Only workloads are mutated on update, so the annotation is recorded in the metadata of the workload
+		patchOps = append(patchOps, jsonpatch.NewOperation("add", "/metadata/annotations", podMetaPtr.Annotations))
+	}
+	patchOps = append(patchOps, s.createPodSpecPatch(p, spec, adjustForHostNetwork)...)
+	return json.Marshal(append(target.tests, patchOps...))
+}
 
-		psaLevel := psaLevelByNamespace(namespace)
-		bytes, err := json.Marshal([]jsonpatch.JsonPatchOperation{
-			s.createInitContainerPatch(p, annotation, spec.InitContainers, psaLevel, envVars...),
-			s.createContainerPatch(p, spec.Containers, psaLevel, envVars...),
-			s.createVolumesPatch(p, spec.Volumes, psaLevel),
-			s.createLabelPatch(p, podMetaPtr.Labels),
-		})
-		if err != nil {
-			resp.Result = &v1.Status{
-				Status: err.Error(),
+// createPatchTests returns the test operations asserting that the arrays replaced by the patch are the admitted ones,
+// so the patch fails instead of overwriting the changes made since admission.
+func createPatchTests(p string, spec *corev1.PodSpec) []jsonpatch.JsonPatchOperation {
+	spec = spec.DeepCopy()
+	testOps := []jsonpatch.JsonPatchOperation{
+		jsonpatch.NewOperation("test", path.Join(p, "spec", "containers"), spec.Containers),
+	}
+	// Absent paths can't be tested
+	if len(spec.InitContainers) > 0 {
+		testOps = append(testOps, jsonpatch.NewOperation("test", path.Join(p, "spec", "initContainers"), spec.InitContainers))
+	}
+	if len(spec.Volumes) > 0 {
+		testOps = append(testOps, jsonpatch.NewOperation("test", path.Join(p, "spec", "volumes"), spec.Volumes))
+	}
+	return testOps
+}
+
+// sidecarEnvs returns the envs of the injected containers for the network services from annotation. The envs keep
+// the order of Config.Envs, because k8s expands $(VAR) only if VAR is defined before, so the patches are stable.
+func (s *admissionWebhookServer) sidecarEnvs(annotation string, podMeta *v1.ObjectMeta) []corev1.EnvVar {
+	nsmNameEnv := corev1.EnvVar{Name: "NSM_NAME", Value: "$(POD_NAME)"}
+	if podMeta.GenerateName == "" {
+		clientID := uuid.NewString()
+		nsmNameEnv.Value = fmt.Sprintf("$(POD_NAME)-%v", clientID)
+	}
+	envs := s.config.GetOrResolveEnvs()
+	labelEnvs := s.labelEnvs(podMeta.Labels)
+	// The resolved envs are shared by the concurrent requests, so they are copied instead of appending in place
+	result := make([]corev1.EnvVar, 0, len(envs)+len(labelEnvs)+2)
+	result = append(result, envs...)
+	result = append(result, labelEnvs...)
+	return append(result,
+		corev1.EnvVar{Name: s.config.NSURLEnvName, Value: annotation},
+		nsmNameEnv)
+}
+
+// labelEnvs returns the envs of Config.LabelToEnvMapping for podLabels. Labels mapped to the same env are joined
+// as "key:value" pairs sorted by key, e.g. "app:nginx,tier:web". Envs without present labels are omitted.
+func (s *admissionWebhookServer) labelEnvs(podLabels map[string]string) []corev1.EnvVar {
+	pairs := make(map[string][]string)
+	for key, envName := range s.config.LabelToEnvMapping {
+		if value, ok := podLabels[key]; ok {
+			pairs[envName] = append(pairs[envName], key+":"+value)
+		}
+	}
+	result := make([]corev1.EnvVar, 0, len(pairs))
+	for envName, values := range pairs {
+		sort.Strings(values)
+		result = append(result, corev1.EnvVar{Name: envName, Value: strings.Join(values, ",")})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// createPodSpecPatch returns the patch operations of the optional pod spec fields.
+func (s *admissionWebhookServer) createPodSpecPatch(p string, spec *corev1.PodSpec, adjustForHostNetwork bool) []jsonpatch.JsonPatchOperation {
+	var patchOps []jsonpatch.JsonPatchOperation
+	if len(s.config.InjectTopologySpreadConstraints) > 0 {
+		patchOps = append(patchOps, s.createTopologySpreadConstraintsPatch(p, spec.TopologySpreadConstraints))
+	}
+	if sa := s.config.RequireServiceAccount; sa != "" && serviceAccountName(spec) != sa &&
+		s.config.ServiceAccountPolicy == config.PatchServiceAccountPolicy {
+		patchOps = append(patchOps, jsonpatch.NewOperation("add", path.Join(p, "spec", "serviceAccountName"), sa))
+		if spec.DeprecatedServiceAccount != "" {
+			patchOps = append(patchOps, jsonpatch.NewOperation("add", path.Join(p, "spec", "serviceAccount"), sa))
+		}
+	}
+	if minPeriod := s.config.MinTerminationGracePeriodSeconds; minPeriod > 0 &&
+		(spec.TerminationGracePeriodSeconds == nil || *spec.TerminationGracePeriodSeconds < minPeriod) {
+		patchOps = append(patchOps, jsonpatch.NewOperation("add", path.Join(p, "spec", "terminationGracePeriodSeconds"), minPeriod))
+	}
+	if s.shareProcessNamespace(spec) {
+		patchOps = append(patchOps, jsonpatch.NewOperation("add", path.Join(p, "spec", "shareProcessNamespace"), true))
+	}
+	// Injected containers need cluster DNS to reach NSM services
+	if adjustForHostNetwork && (spec.DNSPolicy == "" || spec.DNSPolicy == corev1.DNSClusterFirst) {
+		patchOps = append(patchOps, jsonpatch.NewOperation("add", path.Join(p, "spec", "dnsPolicy"), corev1.DNSClusterFirstWithHostNet))
+	}
+	return patchOps
+}
+
+// shareProcessNamespace returns true if Config.InjectShareProcessNamespace is set and spec doesn't set
+// shareProcessNamespace explicitly. It can't be used together with hostPID.
+func (s *admissionWebhookServer) shareProcessNamespace(spec *corev1.PodSpec) bool {
+	return s.config.InjectShareProcessNamespace && spec.ShareProcessNamespace == nil && !spec.HostPID
+}
+
+// withoutPodIPEnvs returns envVars without the envs referring to the pod IP, since it's the host IP for host network pods.
+func withoutPodIPEnvs(envVars []corev1.EnvVar) []corev1.EnvVar {
+	var result []corev1.EnvVar
+	for _, env := range envVars {
+		if env.ValueFrom != nil && env.ValueFrom.FieldRef != nil &&
+			(env.ValueFrom.FieldRef.FieldPath == "status.podIP" || env.ValueFrom.FieldRef.FieldPath == "status.podIPs") {
+			continue
+		}
+		result = append(result, env)
+	}
+	return result
+}
+
+// waitInitialized returns true if config.Config is initialized. Depending on Config.ColdStartPolicy it waits for the
+// initialization up to Config.ColdStartTimeout or returns immediately.
+func (s *admissionWebhookServer) waitInitialized(ctx context.Context) bool {
+	if s.initialized == nil {
+		return true
+	}
+	select {
+	case <-s.initialized:
+		return true
+	default:
+	}
+	if s.config.ColdStartPolicy == config.PassthroughColdStartPolicy {
+		return false
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, s.config.ColdStartTimeout)
+	defer cancel()
+	select {
+	case <-s.initialized:
+		return true
+	case <-timeoutCtx.Done():
+		return false
+	}
+}
+
+// startSpan starts a tracing span if Config.EnableTracing is set. Otherwise, it returns a non-recording span.
+func (s *admissionWebhookServer) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	if !s.config.EnableTracing {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return otel.Tracer(tracerName).Start(ctx, name)
+}
+
+// lookupAnnotation returns the value of Config.Annotation. If it's absent, the first found Config.DeprecatedAnnotations
+// value is returned and a warning is attached to the response.
+func (s *admissionWebhookServer) lookupAnnotation(resp *admissionv1.AdmissionResponse, annotations map[string]string) (value string, found bool) {
+	v, found := annotations[s.config.Annotation]
+	if v != "" {
+		return v, true
+	}
+	for _, name := range s.config.DeprecatedAnnotations {
+		v, ok := annotations[name]
+		found = found || ok
+		if v == "" {
+			continue
+		}
+		if s.config.WarnDeprecatedAnnotations {
+			addWarning(resp, "annotation %q is deprecated, use %q instead", name, s.config.Annotation)
+		}
+		return v, true
+	}
+	return "", found
+}
+
+func (s *admissionWebhookServer) warnFloatingImageTags(resp *admissionv1.AdmissionResponse, spec *corev1.PodSpec) {
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			if isFloatingImageTag(containers[i].Image) {
+				addWarning(resp, "container %q uses image %q without a fixed tag", containers[i].Name, containers[i].Image)
 			}
-			return resp
 		}
-		resp.Patch = bytes
-		var t = admissionv1.PatchTypeJSONPatch
-		resp.PatchType = &t
 	}
+}
 
-	resp.Allowed = true
+// isFloatingImageTag returns true if img is referenced neither by digest nor by a tag other than 'latest'.
+func isFloatingImageTag(img string) bool {
+	if strings.Contains(img, "@") {
+		return false
+	}
+	name := path.Base(img)
+	i := strings.LastIndex(name, ":")
+	return i < 0 || name[i+1:] == "latest"
+}
+
+// addWarning appends a warning that is shown to the client, e.g. by kubectl.
+func addWarning(resp *admissionv1.AdmissionResponse, format string, args ...interface{}) {
+	resp.Warnings = append(resp.Warnings, fmt.Sprintf(format, args...))
+}
+
+// mutationError fills the response for a failed mutation according to Config.OnMutationError.
+func (s *admissionWebhookServer) mutationError(in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse, err error) *admissionv1.AdmissionResponse {
+	s.logger.Errorf("Mutation of %s %s/%s failed: %v", in.Kind.Kind, in.Namespace, displayName(in), err)
+	resp.Allowed = s.config.OnMutationError == config.AllowErrorPolicy
+	resp.Result = &v1.Status{
+		Status:  v1.StatusFailure,
+		Message: fmt.Sprintf("networkservicemesh admission webhook failed to mutate the object: %v", err),
+		Reason:  v1.StatusReasonBadRequest,
+		Code:    http.StatusBadRequest,
+	}
+	// The object is invalid regardless of Config.OnMutationError
+	if errors.Is(err, errNetworkServiceRequired) || errors.Is(err, errTooManyContainers) {
+		resp.Allowed = false
+		resp.Result.Message = fmt.Sprintf("networkservicemesh admission webhook rejected the object: %v", err)
+	}
 	return resp
 }
 
@@ -157,7 +971,7 @@ func psaLevelByNamespace(namespace *corev1.Namespace) psa.Level {
 	return level
 }
 
-func (s *admissionWebhookServer) unmarshal(in *admissionv1.AdmissionRequest) (podMetaPtr *v1.ObjectMeta, podSpec *corev1.PodSpec) {
+func (s *admissionWebhookServer) unmarshal(in *admissionv1.AdmissionRequest) (podMetaPtr *v1.ObjectMeta, podSpec *corev1.PodSpec, err error) {
 	var target interface{}
 	var metaPtr *v1.ObjectMeta
 	switch in.Kind.Kind {
@@ -191,39 +1005,48 @@ func (s *admissionWebhookServer) unmarshal(in *admissionv1.AdmissionRequest) (po
 		podSpec = &replicaSet.Spec.Template.Spec
 		target = &replicaSet
 	default:
-		return nil, nil
+		return nil, nil, nil
 	}
-	if err := json.Unmarshal(in.Object.Raw, target); err != nil {
-		return nil, nil
+	if err = json.Unmarshal(in.Object.Raw, target); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to unmarshal %v", in.Kind.Kind)
 	}
-	podMetaPtr = s.postProcessPodMeta(podMetaPtr, metaPtr, in.Kind.Kind)
+	podMetaPtr, err = s.postProcessPodMeta(podMetaPtr, metaPtr, in.Kind.Kind)
 	if podMetaPtr == nil {
-		return nil, nil
+		return nil, nil, err
 	}
-	return podMetaPtr, podSpec
+	return podMetaPtr, podSpec, nil
 }
 
-func (s *admissionWebhookServer) postProcessPodMeta(podMetaPtr, metaPtr *v1.ObjectMeta, kind string) *v1.ObjectMeta {
+func (s *admissionWebhookServer) postProcessPodMeta(podMetaPtr, metaPtr *v1.ObjectMeta, kind string) (*v1.ObjectMeta, error) {
 	if podMetaPtr.Labels == nil {
 		podMetaPtr.Labels = make(map[string]string)
 	}
-	// Annotations shouldn't be applied second time.
+	// Annotations shouldn't be applied second time. The template annotations of Config.InjectAnnotations are patched
+	// by the previous mutation, so they're expected on update.
 	if kind != "Pod" {
-		if podMetaPtr.Annotations == nil {
-			podMetaPtr.Annotations = metaPtr.Annotations
-		} else {
-			s.logger.Errorf("Malformed specification. Annotations can't be provided in several places.")
-			return nil
+		if !s.isInjectedAnnotations(podMetaPtr.Annotations) {
+			return nil, errors.New("malformed specification: annotations can't be provided in several places")
 		}
+		podMetaPtr.Annotations = metaPtr.Annotations
 	}
 	if kind == "ReplicaSet" {
 		for _, o := range metaPtr.OwnerReferences {
 			if o.Kind == "Deployment" {
-				return nil
+				return nil, nil
 			}
 		}
 	}
-	return podMetaPtr
+	return podMetaPtr, nil
+}
+
+// isInjectedAnnotations returns true if all annotations are the keys of Config.InjectAnnotations.
+func (s *admissionWebhookServer) isInjectedAnnotations(annotations map[string]string) bool {
+	for key := range annotations {
+		if _, ok := s.config.InjectAnnotations[key]; !ok {
+			return false
+		}
+	}
+	return true
 }
 
 func (s *admissionWebhookServer) createVolumesPatch(p string, volumes []corev1.Volume, psaLevel psa.Level) jsonpatch.JsonPatchOperation {
@@ -272,6 +1095,24 @@ func (s *admissionWebhookServer) createVolumesPatch(p string, volumes []corev1.V
 			},
 		)
 	}
+	if s.config.InjectProjectedSATokenVolume {
+		volumes = append(volumes, corev1.Volume{
+			Name: projectedSATokenVolume,
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{
+						{
+							ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+								Audience:          s.config.ProjectedSATokenAudience,
+								ExpirationSeconds: &s.config.ProjectedSATokenExpirationSeconds,
+								Path:              "token",
+							},
+						},
+					},
+				},
+			},
+		})
+	}
 	return jsonpatch.NewOperation("add", path.Join(p, "spec", "volumes"), volumes)
 }
 
@@ -289,67 +1130,267 @@ func parseResources(v string, logger *zap.SugaredLogger) map[string]int {
 		nsmURLs = append(nsmURLs, (*nsurl.NSURL)(u))
 	}
 
-	for _, nsmURL := range nsmURLs {
-		labels := nsmURL.Labels()
-		if _, ok := labels["sriovToken"]; ok {
-			interfacePools := strings.Split(labels["sriovToken"], ",")
-			poolResources[interfacePools[0]]++
+	for _, nsmURL := range nsmURLs {
+		labels := nsmURL.Labels()
+		if _, ok := labels["sriovToken"]; ok {
+			interfacePools := strings.Split(labels["sriovToken"], ",")
+			poolResources[interfacePools[0]]++
+		}
+	}
+
+	return poolResources
+}
+
+// injection contains per-request parameters of the injected containers.
+type injection struct {
+	psaLevel   psa.Level
+	envVars    []corev1.EnvVar
+	resources  corev1.ResourceRequirements
+	initImages []string
+	images     []string
+	// skipContainers are the names of the existing containers from Config.SkipContainersAnnotation
+	skipContainers map[string]bool
+	// appVolumeMounts are added to the existing containers
+	appVolumeMounts []corev1.VolumeMount
+}
+
+// limitInjectedContainers applies Config.MaxInjectedContainersPolicy if inj exceeds Config.MaxInjectedContainers.
+// The SPIRE wait init container is counted too, and it's never truncated: the other containers depend on it.
+func (s *admissionWebhookServer) limitInjectedContainers(resp *admissionv1.AdmissionResponse, inj *injection) error {
+	var reserved int
+	if s.config.InjectSpireWaitInitContainer {
+		reserved = 1
+	}
+	limit, total := s.config.MaxInjectedContainers, reserved+len(inj.initImages)+len(inj.images)
+	if limit == 0 || total <= limit {
+		return nil
+	}
+	if s.config.MaxInjectedContainersPolicy == config.RejectLimitPolicy {
+		return errors.Wrapf(errTooManyContainers, "%d containers would be injected, maximum is %d", total, limit)
+	}
+	addWarning(resp, "%d containers would be injected, only the first %d are injected", total, limit)
+	available := limit - reserved
+	if len(inj.initImages) > available {
+		inj.initImages = inj.initImages[:available]
+	}
+	inj.images = inj.images[:available-len(inj.initImages)]
+	return nil
+}
+
+// createInitContainerPatch returns the patch of init containers. The existing init containers keep their order and go
+// first, so they still run before the injected ones.
+func (s *admissionWebhookServer) createInitContainerPatch(p, v string, initContainers, nativeSidecars []corev1.Container, inj *injection) jsonpatch.JsonPatchOperation {
+	if s.config.EnvTarget == config.AllEnvTarget || s.config.EnvTarget == config.InitContainersEnvTarget {
+		s.mutateAppContainers(initContainers, inj.skipContainers, func(c *corev1.Container) {
+			addEnvs(c, inj.envVars)
+		})
+	}
+	if s.config.InjectSpireWaitInitContainer {
+		initContainers = append(initContainers, s.createSpireWaitContainer(inj))
+	}
+	poolResources := parseResources(v, s.logger)
+	for _, img := range inj.initImages {
+		c := s.newSidecar(img, inj)
+		if s.config.EnvTarget == config.ContainersEnvTarget {
+			c.Env = nil
+		}
+		s.addResources(&c, poolResources)
+		s.addGOMAXPROCS(&c)
+		initContainers = append(initContainers, c)
+	}
+	// Native sidecars start after the injected init containers are completed
+	initContainers = append(initContainers, nativeSidecars...)
+	return jsonpatch.NewOperation("add", path.Join(p, "spec", "initContainers"), initContainers)
+}
+
+// createContainerPatch returns the patch of containers. The existing containers are never reordered: sidecars are
+// inserted as a block before or after them according to Config.ContainerInjectionPosition.
+func (s *admissionWebhookServer) createContainerPatch(p string, containers, sidecars []corev1.Container, inj *injection) jsonpatch.JsonPatchOperation {
+	if s.config.EnvTarget == config.AllEnvTarget || s.config.EnvTarget == config.ContainersEnvTarget {
+		s.mutateAppContainers(containers, inj.skipContainers, func(c *corev1.Container) {
+			addEnvs(c, inj.envVars)
+		})
+	}
+	if len(inj.appVolumeMounts) > 0 {
+		s.mutateAppContainers(containers, inj.skipContainers, func(c *corev1.Container) {
+			addVolumeMounts(c, inj.appVolumeMounts)
+		})
+	}
+	// A new slice is used, so the admitted containers are not overwritten through the shared backing array
+	result := make([]corev1.Container, 0, len(containers)+len(sidecars))
+	if s.config.ContainerInjectionPosition == config.PrependContainerPosition {
+		result = append(append(result, sidecars...), containers...)
+	} else {
+		result = append(append(result, containers...), sidecars...)
+	}
+	return jsonpatch.NewOperation("add", path.Join(p, "spec", "containers"), result)
+}
+
+// createSpireWaitContainer returns the init container that waits for the SPIRE agent socket for
+// Config.SpireWaitTimeout, so the injected containers don't crash until the SPIRE agent is ready.
+func (s *admissionWebhookServer) createSpireWaitContainer(inj *injection) corev1.Container {
+	script := fmt.Sprintf("i=0; until [ -S %[1]s ]; do i=$((i+1)); if [ $i -gt %[2]d ]; then echo %[1]s is not found; exit 1; fi; sleep 1; done",
+		spireAgentSocket, int(s.config.SpireWaitTimeout.Seconds()))
+	c := corev1.Container{
+		Name:            spireWaitContainerName,
+		Image:           s.config.SpireWaitImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"sh", "-c", script},
+		Resources:       *inj.resources.DeepCopy(),
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      "spire-agent-socket",
+			MountPath: path.Dir(spireAgentSocket),
+			ReadOnly:  true,
+		}},
+	}
+	c.SecurityContext = securityContextFor(inj.psaLevel)
+	return c
+}
+
+// createSidecars returns the containers for Config.ContainerImages.
+func (s *admissionWebhookServer) createSidecars(inj *injection) []corev1.Container {
+	var result []corev1.Container
+	for _, img := range inj.images {
+		c := s.newSidecar(img, inj)
+		if s.config.EnvTarget == config.InitContainersEnvTarget {
+			c.Env = nil
+		}
+		s.addGOMAXPROCS(&c)
+		// Regular init containers can't have probes, but native sidecars and containers can
+		c.ReadinessProbe = s.config.SidecarReadinessProbe.Get()
+		c.LivenessProbe = s.config.SidecarLivenessProbe.Get()
+		c.StartupProbe = s.config.SidecarStartupProbe.Get()
+		if preStop := s.config.SidecarPreStop.Get(); preStop != nil {
+			c.Lifecycle = &corev1.Lifecycle{PreStop: preStop}
+		}
+		if s.nativeSidecars {
+			restartPolicy := corev1.ContainerRestartPolicyAlways
+			c.RestartPolicy = &restartPolicy
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// mutateAppContainers applies mutate to the existing containers except Config.ProtectedContainers and skipped.
+func (s *admissionWebhookServer) mutateAppContainers(containers []corev1.Container, skipped map[string]bool, mutate func(c *corev1.Container)) {
+	for i := range containers {
+		if !s.config.IsContainerProtected(containers[i].Name) && !skipped[containers[i].Name] {
+			mutate(&containers[i])
+		}
+	}
+}
+
+// appVolumeMounts returns Config.AppContainerVolumeMounts or the mounts from Config.AppVolumeMountsAnnotation of the
+// resource. Mounts of the volumes that are neither in volumes nor injected are dropped.
+func (s *admissionWebhookServer) appVolumeMounts(resp *admissionv1.AdmissionResponse, annotations map[string]string, volumes []corev1.Volume) []corev1.VolumeMount {
+	// Config.AppContainerVolumeMounts is validated on start
+	mounts, _ := config.ParseVolumeMounts(s.config.AppContainerVolumeMounts)
+	if v, ok := annotations[s.config.AppVolumeMountsAnnotation]; ok {
+		overridden, err := config.ParseVolumeMounts(strings.Split(v, ","))
+		if err != nil {
+			addWarning(resp, "%s annotation: %v, default app volume mounts are used", s.config.AppVolumeMountsAnnotation, err)
+		} else {
+			mounts = overridden
+		}
+	}
+	injected := []corev1.Volume{{Name: "spire-agent-socket"}, {Name: "nsm-socket"}}
+	return withExistingVolumes(mounts, append(injected, volumes...))
+}
+
+// addVolumeMounts appends mounts to the volume mounts of the existing container. Mounts over the existing mount
+// paths and mounts of the already mounted volumes are skipped.
+func addVolumeMounts(c *corev1.Container, mounts []corev1.VolumeMount) {
+	paths := make(map[string]bool, len(c.VolumeMounts))
+	volumes := make(map[string]bool, len(c.VolumeMounts))
+	for _, m := range c.VolumeMounts {
+		paths[path.Clean(m.MountPath)] = true
+		volumes[m.Name] = true
+	}
+	for _, m := range mounts {
+		if !paths[m.MountPath] && !volumes[m.Name] {
+			paths[m.MountPath], volumes[m.Name] = true, true
+			c.VolumeMounts = append(c.VolumeMounts, m)
+		}
+	}
+}
+
+// addEnvs appends envVars to the envs of the existing container. Envs that the container already has are not changed.
+func addEnvs(c *corev1.Container, envVars []corev1.EnvVar) {
+	existing := make(map[string]bool, len(c.Env))
+	for _, env := range c.Env {
+		existing[env.Name] = true
+	}
+	for _, env := range envVars {
+		if !existing[env.Name] {
+			c.Env = append(c.Env, env)
+		}
+	}
+}
+
+// newSidecar returns the container that is injected for img.
+func (s *admissionWebhookServer) newSidecar(img string, inj *injection) corev1.Container {
+	c := corev1.Container{
+		Name:            nameOf(img),
+		Env:             s.envsFor(nameOf(img), inj.envVars),
+		EnvFrom:         s.config.GetOrResolveEnvFrom(),
+		Image:           img,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Resources:       *inj.resources.DeepCopy(),
+	}
+	s.addVolumeMounts(&c)
+
+	c.SecurityContext = securityContextFor(inj.psaLevel)
+	return c
+}
+
+// securityContextFor returns the security context of the injected containers required by the k8s restricted policy
+// or nil for the other policy levels.
+func securityContextFor(level psa.Level) *corev1.SecurityContext {
+	if level != psa.LevelRestricted {
+		return nil
+	}
+	allowPrivilegeEscalation := false
+	return &corev1.SecurityContext{
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+	}
+}
+
+// envsFor returns envVars where NSURL env is renamed according to Config.NSURLEnvNames for the named container.
+func (s *admissionWebhookServer) envsFor(name string, envVars []corev1.EnvVar) []corev1.EnvVar {
+	envName := s.nsURLEnvName(name)
+	if envName == s.config.NSURLEnvName {
+		return envVars
+	}
+	result := make([]corev1.EnvVar, len(envVars))
+	copy(result, envVars)
+	for i := range result {
+		if result[i].Name == s.config.NSURLEnvName {
+			result[i].Name = envName
 		}
 	}
-
-	return poolResources
+	return result
 }
 
-func (s *admissionWebhookServer) createInitContainerPatch(p, v string, initContainers []corev1.Container, psaLevel psa.Level, envVars ...corev1.EnvVar) jsonpatch.JsonPatchOperation {
-	poolResources := parseResources(v, s.logger)
-	for _, img := range s.config.InitContainerImages {
-		initContainers = append(initContainers, corev1.Container{
-			Name:            nameOf(img),
-			Env:             envVars,
-			Image:           img,
-			ImagePullPolicy: corev1.PullIfNotPresent,
-		})
-		s.addVolumeMounts(&initContainers[len(initContainers)-1])
-		s.addResources(&initContainers[len(initContainers)-1], poolResources)
-		s.addResourcesLimits(&initContainers[len(initContainers)-1])
-
-		// SecurityContext is required by the k8s restricted policy
-		if psaLevel == psa.LevelRestricted {
-			allowPrivilegeEscalation := false
-			initContainers[len(initContainers)-1].SecurityContext = &corev1.SecurityContext{
-				Capabilities: &corev1.Capabilities{
-					Drop: []corev1.Capability{"ALL"},
-				},
-				AllowPrivilegeEscalation: &allowPrivilegeEscalation,
-			}
+// envByName returns the named env of the container or nil.
+func envByName(c *corev1.Container, name string) *corev1.EnvVar {
+	for i := range c.Env {
+		if c.Env[i].Name == name {
+			return &c.Env[i]
 		}
 	}
-	return jsonpatch.NewOperation("add", path.Join(p, "spec", "initContainers"), initContainers)
+	return nil
 }
 
-func (s *admissionWebhookServer) createContainerPatch(p string, containers []corev1.Container, psaLevel psa.Level, envVars ...corev1.EnvVar) jsonpatch.JsonPatchOperation {
-	for _, img := range s.config.ContainerImages {
-		containers = append(containers, corev1.Container{
-			Name:            nameOf(img),
-			Env:             envVars,
-			Image:           img,
-			ImagePullPolicy: corev1.PullIfNotPresent,
-		})
-		s.addVolumeMounts(&containers[len(containers)-1])
-		s.addResourcesLimits(&containers[len(containers)-1])
-
-		// SecurityContext is required by the k8s restricted policy
-		if psaLevel == psa.LevelRestricted {
-			allowPrivilegeEscalation := false
-			containers[len(containers)-1].SecurityContext = &corev1.SecurityContext{
-				Capabilities: &corev1.Capabilities{
-					Drop: []corev1.Capability{"ALL"},
-				},
-				AllowPrivilegeEscalation: &allowPrivilegeEscalation,
-			}
-		}
+// nsURLEnvName returns the name of NSURL env of the named container.
+func (s *admissionWebhookServer) nsURLEnvName(name string) string {
+	if envName, ok := s.config.NSURLEnvNames[name]; ok {
+		return envName
 	}
-	return jsonpatch.NewOperation("add", path.Join(p, "spec", "containers"), containers)
+	return s.config.NSURLEnvName
 }
 
 func nameOf(img string) string {
@@ -365,17 +1406,163 @@ func (s *admissionWebhookServer) addResources(c *corev1.Container, r map[string]
 	}
 }
 
-func (s *admissionWebhookServer) addResourcesLimits(c *corev1.Container) {
-	c.Resources = corev1.ResourceRequirements{
-		Limits: corev1.ResourceList{
-			"cpu":    resource.MustParse(s.config.SidecarLimitsCPU),
-			"memory": resource.MustParse(s.config.SidecarLimitsMemory),
-		},
-		Requests: corev1.ResourceList{
-			"cpu":    resource.MustParse(s.config.SidecarRequestsCPU),
-			"memory": resource.MustParse(s.config.SidecarRequestsMemory),
-		},
+// addGOMAXPROCS adds GOMAXPROCS env equal to the CPU limit of c rounded up if Config.InjectGOMAXPROCS is set.
+// The env is not added if c has no CPU limit or already has GOMAXPROCS env.
+func (s *admissionWebhookServer) addGOMAXPROCS(c *corev1.Container) {
+	if !s.config.InjectGOMAXPROCS {
+		return
+	}
+	limit, ok := c.Resources.Limits[corev1.ResourceCPU]
+	if !ok || limit.IsZero() {
+		return
+	}
+	for i := range c.Env {
+		if c.Env[i].Name == "GOMAXPROCS" {
+			return
+		}
+	}
+	// Env may be shared with the other containers
+	c.Env = append(append([]corev1.EnvVar(nil), c.Env...), corev1.EnvVar{
+		Name:  "GOMAXPROCS",
+		Value: strconv.FormatInt(gomaxprocs(&limit), 10),
+	})
+}
+
+// gomaxprocs returns the CPU limit rounded up to the whole number of CPUs, at least 1.
+func gomaxprocs(limit *resource.Quantity) int64 {
+	result := (limit.MilliValue() + 999) / 1000
+	if result < 1 {
+		return 1
+	}
+	return result
+}
+
+// sidecarResources returns the resources of the injected containers. Config.SidecarResourcesAnnotation of the resource
+// overrides the configured values, e.g. "cpu=500m,memory=128Mi" or "requests.cpu=100m,limits.cpu=500m".
+// Config.OmitSidecarResources and "none" value of the annotation omit the resources.
+func (s *admissionWebhookServer) sidecarResources(resp *admissionv1.AdmissionResponse, kind string, podMeta *v1.ObjectMeta) corev1.ResourceRequirements {
+	resources := s.defaultSidecarResources(kind, podMeta)
+	v, ok := podMeta.Annotations[s.config.SidecarResourcesAnnotation]
+	if !ok {
+		return resources
+	}
+	overridden, err := config.OverrideResources(resources, v)
+	if err != nil {
+		addWarning(resp, "%s annotation: %v, default sidecar resources are used", s.config.SidecarResourcesAnnotation, err)
+		return resources
+	}
+	return overridden
+}
+
+// defaultSidecarResources returns the configured resources of the injected containers. DaemonSets and their pods
+// get Config.DaemonSetSidecarResources over them.
+func (s *admissionWebhookServer) defaultSidecarResources(kind string, podMeta *v1.ObjectMeta) corev1.ResourceRequirements {
+	var resources corev1.ResourceRequirements
+	if !s.config.OmitSidecarResources {
+		resources = corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				"cpu":    resource.MustParse(s.config.SidecarLimitsCPU),
+				"memory": resource.MustParse(s.config.SidecarLimitsMemory),
+			},
+			Requests: corev1.ResourceList{
+				"cpu":    resource.MustParse(s.config.SidecarRequestsCPU),
+				"memory": resource.MustParse(s.config.SidecarRequestsMemory),
+			},
+		}
+	}
+	if s.config.DaemonSetSidecarResources == "" || !isDaemonSet(kind, podMeta) {
+		return resources
+	}
+	// Config.DaemonSetSidecarResources is validated on start
+	overridden, _ := config.OverrideResources(resources, s.config.DaemonSetSidecarResources)
+	return overridden
+}
+
+// isDaemonSet returns true if the admitted object is a DaemonSet or a pod controlled by a DaemonSet.
+func isDaemonSet(kind string, podMeta *v1.ObjectMeta) bool {
+	if kind == "DaemonSet" {
+		return true
+	}
+	if kind != "Pod" {
+		return false
+	}
+	owner := v1.GetControllerOf(podMeta)
+	return owner != nil && owner.Kind == "DaemonSet"
+}
+
+// skipContainers returns the names of the existing containers listed in Config.SkipContainersAnnotation of the
+// resource, e.g. "sidecar-a,sidecar-b". They aren't mutated in addition to Config.ProtectedContainers.
+func (s *admissionWebhookServer) skipContainers(annotations map[string]string) map[string]bool {
+	v, ok := annotations[s.config.SkipContainersAnnotation]
+	if !ok {
+		return nil
+	}
+	return parseNames(v)
+}
+
+// removedSidecars returns the names of the containers listed in Config.RemovedSidecarsAnnotation of the resource if
+// Config.AllowSidecarRemoval is set.
+func (s *admissionWebhookServer) removedSidecars(annotations map[string]string) map[string]bool {
+	v, ok := annotations[s.config.RemovedSidecarsAnnotation]
+	if !ok || !s.config.AllowSidecarRemoval {
+		return nil
+	}
+	return parseNames(v)
+}
+
+// parseNames returns the names from the comma separated list v.
+func parseNames(v string) map[string]bool {
+	result := make(map[string]bool)
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			result[name] = true
+		}
+	}
+	return result
+}
+
+// sidecarImages returns the images of the injected containers without the ones from Config.RemovedSidecarsAnnotation.
+func (s *admissionWebhookServer) sidecarImages(resp *admissionv1.AdmissionResponse, annotations map[string]string) []string {
+	removed := s.removedSidecars(annotations)
+	if len(removed) == 0 {
+		return s.configuredSidecarImages(resp, annotations)
+	}
+	var result []string
+	for _, img := range s.configuredSidecarImages(resp, annotations) {
+		if !removed[nameOf(img)] {
+			result = append(result, img)
+		}
+	}
+	return result
+}
+
+// configuredSidecarImages returns Config.ContainerImages. Config.SidecarImageAnnotation of the resource overrides the
+// configured images with the same name, e.g. "registry/cmd-nsc:v1.2.3".
+func (s *admissionWebhookServer) configuredSidecarImages(resp *admissionv1.AdmissionResponse, annotations map[string]string) []string {
+	images := s.config.GetOrResolveContainerImages()
+	v, ok := annotations[s.config.SidecarImageAnnotation]
+	if !ok {
+		return images
+	}
+	overridden := append([]string(nil), images...)
+	for _, img := range strings.Split(v, ",") {
+		img = strings.TrimSpace(img)
+		if _, _, _, err := config.ParseImageReference(img); err != nil {
+			addWarning(resp, "malformed %s annotation: %v, default sidecar images are used", s.config.SidecarImageAnnotation, err)
+			return images
+		}
+		var found bool
+		for i := range overridden {
+			if nameOf(overridden[i]) == nameOf(img) {
+				overridden[i], found = img, true
+			}
+		}
+		if !found {
+			addWarning(resp, "image %s from %s annotation doesn't match any sidecar image, default sidecar images are used", img, s.config.SidecarImageAnnotation)
+			return images
+		}
 	}
+	return overridden
 }
 
 func (s *admissionWebhookServer) addVolumeMounts(c *corev1.Container) {
@@ -388,8 +1575,18 @@ func (s *admissionWebhookServer) addVolumeMounts(c *corev1.Container) {
 		MountPath: "/var/lib/networkservicemesh",
 		ReadOnly:  true,
 	})
+
+	if s.config.InjectProjectedSATokenVolume {
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+			Name:      projectedSATokenVolume,
+			MountPath: s.config.ProjectedSATokenMountPath,
+			ReadOnly:  true,
+		})
+	}
 }
 
+// createLabelPatch adds Config.Labels to v. The labels are marshaled as a JSON object with sorted keys, so the patch
+// doesn't depend on the map order.
 func (s *admissionWebhookServer) createLabelPatch(p string, v map[string]string) jsonpatch.JsonPatchOperation {
 	for key, value := range s.config.Labels {
 		v[key] = value
@@ -397,6 +1594,132 @@ func (s *admissionWebhookServer) createLabelPatch(p string, v map[string]string)
 	return jsonpatch.NewOperation("add", path.Join(p, "metadata", "labels"), v)
 }
 
+func (s *admissionWebhookServer) createAnnotationPatch(p string, v map[string]string) jsonpatch.JsonPatchOperation {
+	annotations := make(map[string]string, len(v)+len(s.config.InjectAnnotations))
+	for key, value := range v {
+		annotations[key] = value
+	}
+	for key, value := range s.config.InjectAnnotations {
+		if _, ok := annotations[key]; ok && !s.config.OverwriteAnnotations {
+			continue
+		}
+		annotations[key] = value
+	}
+	return jsonpatch.NewOperation("add", path.Join(p, "metadata", "annotations"), annotations)
+}
+
+// createTopologySpreadConstraintsPatch appends Config.InjectTopologySpreadConstraints that have no existing constraint
+// with the same topologyKey and labelSelector.
+func (s *admissionWebhookServer) createTopologySpreadConstraintsPatch(p string, constraints []corev1.TopologySpreadConstraint) jsonpatch.JsonPatchOperation {
+	key := func(c *corev1.TopologySpreadConstraint) string {
+		return c.TopologyKey + "/" + v1.FormatLabelSelector(c.LabelSelector)
+	}
+	existing := make(map[string]bool, len(constraints))
+	for i := range constraints {
+		existing[key(&constraints[i])] = true
+	}
+	for i := range s.config.InjectTopologySpreadConstraints {
+		constraint := &s.config.InjectTopologySpreadConstraints[i]
+		if !existing[key(constraint)] {
+			existing[key(constraint)] = true
+			constraints = append(constraints, *constraint.DeepCopy())
+		}
+	}
+	return jsonpatch.NewOperation("add", path.Join(p, "spec", "topologySpreadConstraints"), constraints)
+}
+
+// setBypass turns the bypass mode on or off.
+func (s *admissionWebhookServer) setBypass(bypass bool) {
+	s.bypass.Store(bypass)
+	if bypass {
+		s.logger.Warn("BYPASS MODE IS ON: all admission requests are passed through without mutation")
+	} else {
+		s.logger.Info("Bypass mode is off")
+	}
+}
+
+// toggleBypassOnSignal toggles the bypass mode on each SIGUSR1 until ctx is done.
+func (s *admissionWebhookServer) toggleBypassOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			s.setBypass(!s.bypass.Load())
+		}
+	}
+}
+
+// registerMutators registers the mutators of pods, workloads with a pod template and namespaces.
+func (s *admissionWebhookServer) registerMutators() {
+	s.mutators = make(map[schema.GroupVersionKind]mutator)
+	workload := mutatorFunc(s.mutateWorkload)
+	s.mutators[corev1.SchemeGroupVersion.WithKind("Pod")] = workload
+	for _, kind := range []string{"Deployment", "StatefulSet", "DaemonSet", "ReplicaSet"} {
+		s.mutators[appsv1.SchemeGroupVersion.WithKind(kind)] = workload
+	}
+	s.mutators[corev1.SchemeGroupVersion.WithKind("Namespace")] = mutatorFunc(s.mutateNamespace)
+}
+
+// watchRequiredKind checks Config.RequireCRD and then keeps checking it every Config.HealthCheckInterval in background.
+// The first check is done before serving, so the requests are not passed through on start if the kind is present.
+func (s *admissionWebhookServer) watchRequiredKind(ctx context.Context, readiness *health.Readiness) {
+	if s.requiredKind == nil {
+		return
+	}
+	readiness.SetOptional(health.RequiredKindCondition, s.requiredKind.Check(ctx))
+	go health.WatchOptional(ctx, readiness, health.RequiredKindCondition, s.config.HealthCheckInterval, s.requiredKind.Check)
+}
+
+// newLogger returns the production logger with the level. prod is used to report the failure.
+func newLogger(prod *zap.Logger, level string) *zap.Logger {
+	var logLevel zapcore.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		prod.Fatal(err.Error())
+	}
+	loggerConfig := zap.NewProductionConfig()
+	loggerConfig.Level = zap.NewAtomicLevelAt(logLevel)
+	logger, err := loggerConfig.Build()
+	if err != nil {
+		prod.Fatal(err.Error())
+	}
+	return logger
+}
+
+// newAdmissionWebhookServer creates admissionWebhookServer with the namespace cache and native sidecars support resolved.
+func newAdmissionWebhookServer(conf *config.Config, logger *zap.SugaredLogger, clientset kubernetes.Interface) *admissionWebhookServer {
+	var handler = &admissionWebhookServer{
+		config:      conf,
+		logger:      logger.Named("admissionWebhookServer"),
+		clientset:   clientset,
+		initialized: make(chan struct{}),
+	}
+	handler.setBypass(conf.Bypass)
+	handler.registerMutators()
+	if conf.RequireCRD != "" {
+		// Config.RequireCRD is validated on start
+		gvk, _ := config.ParseGroupVersionKind(conf.RequireCRD)
+		handler.requiredKind = k8s.NewRequiredKind(clientset.Discovery(), gvk)
+	}
+	if conf.HonorNamespaceAnnotation {
+		handler.namespaces = k8s.NewNamespaceCache(clientset.CoreV1().Namespaces(), conf.NamespaceCacheTTL)
+	}
+	if conf.UseNativeSidecars || conf.ContainerInjectionPosition == config.NativeContainerPosition {
+		var err error
+		handler.nativeSidecars, err = supportsNativeSidecars(clientset)
+		if err != nil {
+			logger.Warnf("Failed to check native sidecars support: %v", err)
+		}
+		if !handler.nativeSidecars {
+			logger.Warn("Native sidecars are not supported by the cluster, containers are injected as regular containers")
+		}
+	}
+	return handler
+}
+
 func main() {
 	prod, err := zap.NewProduction()
 
@@ -414,7 +1737,18 @@ func main() {
 		prod.Fatal(err.Error())
 	}
 
-	var logger = prod.Sugar()
+	if err = conf.Validate(); err != nil {
+		prod.Fatal(err.Error())
+	}
+
+	for _, warning := range conf.ConsistencyWarnings() {
+		if conf.StrictConfigValidation {
+			prod.Fatal(warning)
+		}
+		prod.Warn(warning)
+	}
+
+	var logger = newLogger(prod, conf.LogLevel).Sugar()
 
 	logger.Infof("config.Config: %#v", conf)
 
@@ -444,17 +1778,7 @@ func main() {
 		go pprofutils.ListenAndServe(ctx, conf.PprofListenOn)
 	}
 
-	if conf.WebhookMode == config.SelfregisterMode {
-		unregister := registerSelf(ctx, conf, logger)
-		defer func() {
-			_ = unregister(context.Background(), conf)
-		}()
-	}
-
-	tlsConfig, err := prepareTLSConfig(ctx, conf, logger)
-	if err != nil {
-		logger.Fatal(err.Error())
-	}
+	var readiness = new(health.Readiness)
 
 	s := echo.New()
 	s.Use(middleware.Logger())
@@ -470,69 +1794,259 @@ func main() {
 	if err != nil {
 		logger.Fatal(err.Error())
 	}
-	var handler = &admissionWebhookServer{
-		config:    conf,
-		logger:    logger.Named("admissionWebhookServer"),
-		clientset: clientset,
+	var handler = newAdmissionWebhookServer(conf, logger, clientset)
+	go handler.toggleBypassOnSignal(ctx)
+	handler.watchRequiredKind(ctx, readiness)
+
+	var mutateMiddlewares []echo.MiddlewareFunc
+	if conf.MaxConcurrentAdmissions > 0 {
+		mutateMiddlewares = append(mutateMiddlewares, concurrencyLimiter(conf))
+	}
+
+	s.POST("/mutate", handler.mutate(ctx), mutateMiddlewares...)
+	s.GET("/ready", readinessHandler(conf, readiness))
+
+	var startServerErr = make(chan error)
+	var serverTLSConfig = newLazyTLSConfig()
+
+	// The server starts before the initialization, so the requests received until it's done are handled according to
+	// Config.ColdStartPolicy. TLS handshakes wait for the certificate.
+	go func() {
+		// #nosec
+		var server = &http.Server{
+			Addr: conf.ServerAddress(),
+			TLSConfig: &tls.Config{
+				MinVersion:         tls.VersionTLS12,
+				GetConfigForClient: serverTLSConfig.getConfigForClient,
+			},
+		}
+		startServerErr <- s.StartServer(server)
+	}()
+
+	// Notifies the CA bundle publishing about the rotated serving certificate
+	var certRotated = make(chan struct{}, 1)
+	tlsConfig, err := prepareTLSConfig(ctx, conf, logger, readiness, certRotated)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	serverTLSConfig.set(tlsConfig)
+
+	if err = conf.Init(); err != nil {
+		logger.Fatal(err.Error())
+	}
+	if conf.WebhookMode == config.SelfregisterMode {
+		unregister := registerSelf(ctx, conf, logger, readiness, certRotated)
+		defer func() {
+			_ = unregister(context.Background(), conf)
+		}()
+	}
+	logFingerprints(logger, conf, tlsConfig)
+	close(handler.initialized)
+
+	select {
+	case err := <-startServerErr:
+		if ctx.Err() != nil {
+			logger.Fatal(err.Error())
+		}
+	case <-ctx.Done():
+		return
 	}
+}
+
+// mutate returns the handler of AdmissionReview requests.
+func (s *admissionWebhookServer) mutate(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		reqCtx := otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(c.Request().Header))
+		reqCtx, span := s.startSpan(reqCtx, "admission-review")
+		defer span.End()
 
-	s.POST("/mutate", func(c echo.Context) error {
 		msg, err := io.ReadAll(c.Request().Body)
 		if err != nil {
 			return err
 		}
 		var review = new(admissionv1.AdmissionReview)
 
+		_, decodeSpan := s.startSpan(reqCtx, "decode")
 		_, _, err = deserializer.Decode(msg, nil, review)
+		decodeSpan.End()
 		if err != nil {
-			return err
+			return s.decodeError(c, msg, err)
 		}
+		// The apiserver rejects responses whose UID doesn't match the request UID
+		if review.Request == nil || review.Request.UID == "" {
+			return s.decodeError(c, msg, errors.New("admission request UID is empty"))
+		}
+
+		span.SetAttributes(attribute.String("uid", string(review.Request.UID)))
 
-		review.Response = handler.Review(ctx, review.Request)
+		review.Response = s.Review(reqCtx, review.Request)
+		_, encodeSpan := s.startSpan(reqCtx, "encode")
 		response, err := json.Marshal(review)
+		encodeSpan.End()
 		if err != nil {
 			return err
 		}
-		_, err = c.Response().Write(response)
-		return err
-	})
-	s.GET("/ready", func(c echo.Context) error {
-		return c.NoContent(http.StatusOK)
-	})
+		// Admission responses are specific to the request and must not be cached
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, response)
+	}
+}
 
-	var startServerErr = make(chan error)
+// decodeError responds to the admission review that failed to be decoded. The resource is admitted or rejected according
+// to Config.OnDecodeError. The UID is recovered from msg if possible, otherwise the apiserver can't match the response,
+// so it's sent with 400 status.
+func (s *admissionWebhookServer) decodeError(c echo.Context, msg []byte, err error) error {
+	s.logger.Errorf("Failed to decode admission review: %v", err)
+	var partial struct {
+		Request struct {
+			UID types.UID `json:"uid"`
+		} `json:"request"`
+	}
+	_ = json.Unmarshal(msg, &partial)
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: v1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+		Response: &admissionv1.AdmissionResponse{
+			UID:     partial.Request.UID,
+			Allowed: s.config.OnDecodeError == config.AllowErrorPolicy,
+			Result: &v1.Status{
+				Status:  v1.StatusFailure,
+				Message: fmt.Sprintf("networkservicemesh admission webhook failed to decode the admission review: %v", err),
+				Reason:  v1.StatusReasonBadRequest,
+				Code:    http.StatusBadRequest,
+			},
+		},
+	}
+	code := http.StatusOK
+	if review.Response.UID == "" {
+		code = http.StatusBadRequest
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(code, review)
+}
 
-	go func() {
-		// #nosec
-		var server = &http.Server{
-			Addr:      ":443",
-			TLSConfig: tlsConfig,
+// supportsNativeSidecars returns true if the apiserver version is at least 1.28, where native sidecars are introduced.
+func supportsNativeSidecars(clientset kubernetes.Interface) (bool, error) {
+	info, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get apiserver version")
+	}
+	v, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to parse apiserver version %s", info.GitVersion)
+	}
+	return v.AtLeast(version.MajorMinor(1, 28)), nil
+}
+
+// readinessHandler responds with the mode-specific readiness conditions. Status is 503 if any of them is not ready.
+func readinessHandler(conf *config.Config, readiness *health.Readiness) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		code := http.StatusOK
+		if readiness.Check() != nil {
+			code = http.StatusServiceUnavailable
 		}
-		startServerErr <- s.StartServer(server)
-	}()
+		return c.JSON(code, map[string]interface{}{
+			"mode":       conf.WebhookMode.String(),
+			"conditions": readiness.Status(),
+		})
+	}
+}
 
-	select {
-	case err := <-startServerErr:
-		if ctx.Err() != nil {
-			logger.Fatal(err.Error())
+// concurrencyLimiter limits the number of concurrently processed requests by Config.MaxConcurrentAdmissions.
+// Excess requests are queued or rejected depending on Config.AdmissionOverloadPolicy.
+func concurrencyLimiter(conf *config.Config) echo.MiddlewareFunc {
+	var semaphore = make(chan struct{}, conf.MaxConcurrentAdmissions)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if conf.AdmissionOverloadPolicy == config.RejectOverloadPolicy {
+				select {
+				case semaphore <- struct{}{}:
+				default:
+					c.Response().Header().Set("Retry-After", "1")
+					return c.NoContent(http.StatusServiceUnavailable)
+				}
+			} else {
+				select {
+				case semaphore <- struct{}{}:
+				case <-c.Request().Context().Done():
+					return c.Request().Context().Err()
+				}
+			}
+			defer func() { <-semaphore }()
+			return next(c)
 		}
-	case <-ctx.Done():
-		return
+	}
+}
+
+// lazyTLSConfig lets the server accept connections before the TLS configuration is resolved. Handshakes wait for it.
+type lazyTLSConfig struct {
+	ready  chan struct{}
+	config *tls.Config
+}
+
+func newLazyTLSConfig() *lazyTLSConfig {
+	return &lazyTLSConfig{ready: make(chan struct{})}
+}
+
+// set publishes the resolved configuration. It must be called once.
+func (l *lazyTLSConfig) set(tlsConfig *tls.Config) {
+	l.config = tlsConfig
+	close(l.ready)
+}
+
+// getConfigForClient is tls.Config.GetConfigForClient that returns the resolved configuration once it's set.
+func (l *lazyTLSConfig) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	select {
+	case <-l.ready:
+		return l.config, nil
+	case <-hello.Context().Done():
+		return nil, hello.Context().Err()
 	}
 }
 
 // prepareTLSConfig returns a configuration that includes certificates for proper working of http.Server, depending on the selected webhook mode.
-func prepareTLSConfig(ctx context.Context, c *config.Config, logger *zap.SugaredLogger) (*tls.Config, error) {
+func prepareTLSConfig(ctx context.Context, c *config.Config, logger *zap.SugaredLogger, readiness *health.Readiness, certRotated chan<- struct{}) (*tls.Config, error) {
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
+		// Renegotiation is not supported by TLS 1.3 and is a source of attacks in TLS 1.2
+		Renegotiation:          tls.RenegotiateNever,
+		SessionTicketsDisabled: c.DisableSessionTickets,
+	}
+
+	spireSVID := c.WebhookMode == config.SpireMode && !c.IsExistingCertificatesUsed()
+	// SVIDs don't depend on the initialization, so only the certificates of the other modes wait for it
+	if !spireSVID {
+		if err := c.Init(); err != nil {
+			return nil, err
+		}
 	}
 
-	if c.WebhookMode == config.SpireMode && !c.IsExistingCertificatesUsed() {
-		source, err := workloadapi.NewX509Source(ctx)
+	if spireSVID {
+		factory := func(ctx context.Context) (health.X509Source, error) {
+			return workloadapi.NewX509Source(ctx)
+		}
+		backoff := health.ReconnectBackoff(c.SpireReconnectBackoff, c.SpireReconnectMaxInterval)
+		// The server doesn't accept connections until the first SVID is available, so early handshakes don't fail
+		waitCtx, cancel := ctx, context.CancelFunc(func() {})
+		if c.SpireSVIDWaitTimeout > 0 {
+			waitCtx, cancel = context.WithTimeout(ctx, c.SpireSVIDWaitTimeout)
+		}
+		defer cancel()
+		readiness.Set(health.X509SourceCondition, errors.New("waiting for x509 source"))
+		source, err := health.NewReconnectingX509Source(waitCtx, factory, backoff, c.SpireLivenessFailureThreshold, readiness, logger.Named("x509SourceReconnect"))
 		if err != nil {
 			return nil, errors.Errorf("error getting x509 source: %v", err.Error())
 		}
+		if err = health.WaitX509SVID(waitCtx, source, readiness); err != nil {
+			_ = source.Close()
+			return nil, errors.Wrap(err, "error waiting for x509 SVID")
+		}
 		tlsConfig.GetCertificate = tlsconfig.GetCertificate(source)
+		if svid, svidErr := source.GetX509SVID(); svidErr == nil && len(svid.Certificates) > 0 {
+			logger.Infof("Serving SVID %v SHA-256 fingerprint: %v", svid.ID, fingerprint(svid.Certificates[0].Raw))
+		}
+		if c.SpireLivenessCheckInterval > 0 {
+			go health.WatchX509Source(ctx, source, c.SpireLivenessCheckInterval, c.SpireLivenessFailureThreshold, readiness, logger.Named("x509SourceLiveness"))
+		}
 
 		select {
 		case <-ctx.Done():
@@ -540,14 +2054,97 @@ func prepareTLSConfig(ctx context.Context, c *config.Config, logger *zap.Sugared
 			logger.Errorf("unable to close x509 source: %v", err.Error())
 		default:
 		}
+	} else if c.IsExistingCertificatesUsed() {
+		initial := c.GetOrResolveCertificate()
+		// The rotated pair passes the same checks as the initial one
+		manager := cert.NewManager(&initial, cert.FileSource(c.CertFilePath, c.KeyFilePath), c.CertificateOptions()...)
+		tlsConfig.GetCertificate = manager.GetCertificate
+		if len(initial.Certificate) > 0 {
+			logger.Infof("Serving certificate SHA-256 fingerprint: %v", fingerprint(initial.Certificate[0]))
+		}
+		if c.CertRotationInterval > 0 {
+			go watchCertificate(ctx, manager, c.CertRotationInterval, certRotated, logger.Named("certificateRotation"))
+		}
+		go health.Watch(ctx, readiness, health.CertificateCondition, c.HealthCheckInterval, func(context.Context) error {
+			return health.CheckCertificate(manager.Certificate())
+		})
 	} else {
-		tlsConfig.Certificates = []tls.Certificate{c.GetOrResolveCertificate()}
+		certificate := c.GetOrResolveCertificate()
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+		go health.Watch(ctx, readiness, health.CertificateCondition, c.HealthCheckInterval, func(context.Context) error {
+			return health.CheckCertificate(&certificate)
+		})
 	}
 
 	return tlsConfig, nil
 }
 
-func registerSelf(ctx context.Context, conf *config.Config, logger *zap.SugaredLogger) func(ctx context.Context, c *config.Config) error {
+// watchCertificate rotates the certificate of manager every interval until ctx is done. The served certificate is kept
+// if the rotation fails. rotated is notified when the served certificate is replaced with a different one.
+func watchCertificate(ctx context.Context, manager *cert.Manager, interval time.Duration, rotated chan<- struct{}, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		previous := manager.Certificate()
+		if err := manager.Rotate(ctx); err != nil {
+			logger.Errorf("Failed to rotate serving certificate: %v", err)
+			continue
+		}
+		if served := manager.Certificate(); !sameLeaf(served, previous) {
+			logger.Infof("Serving certificate is rotated, SHA-256 fingerprint: %v", fingerprint(served.Certificate[0]))
+			// The pending notification isn't consumed yet, so the next one is redundant
+			select {
+			case rotated <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// sameLeaf returns true if a and b have the same leaf certificate.
+func sameLeaf(a, b *tls.Certificate) bool {
+	if len(a.Certificate) == 0 || len(b.Certificate) == 0 {
+		return len(a.Certificate) == len(b.Certificate)
+	}
+	return bytes.Equal(a.Certificate[0], b.Certificate[0])
+}
+
+// logFingerprints logs SHA-256 fingerprints of the static serving certificate and of each certificate in the resolved CA bundle.
+func logFingerprints(logger *zap.SugaredLogger, conf *config.Config, tlsConfig *tls.Config) {
+	for _, certificate := range tlsConfig.Certificates {
+		if len(certificate.Certificate) > 0 {
+			logger.Infof("Serving certificate SHA-256 fingerprint: %v", fingerprint(certificate.Certificate[0]))
+		}
+	}
+	rest := conf.GetOrResolveCABundle()
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return
+		}
+		if block.Type == "CERTIFICATE" {
+			logger.Infof("CA bundle certificate SHA-256 fingerprint: %v", fingerprint(block.Bytes))
+		}
+	}
+}
+
+// fingerprint returns SHA-256 fingerprint of the DER encoded certificate in the colon separated hex form.
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	octets := make([]string, 0, len(sum))
+	for _, b := range sum {
+		octets = append(octets, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(octets, ":")
+}
+
+func registerSelf(ctx context.Context, conf *config.Config, logger *zap.SugaredLogger, readiness *health.Readiness, certRotated <-chan struct{}) func(ctx context.Context, c *config.Config) error {
 	var registerClient = k8s.AdmissionWebhookRegisterClient{
 		Logger: logger.Named("admissionWebhookRegisterClient"),
 	}
@@ -556,6 +2153,30 @@ func registerSelf(ctx context.Context, conf *config.Config, logger *zap.SugaredL
 	if err != nil {
 		logger.Fatal(err.Error())
 	}
+	go health.Watch(ctx, readiness, health.WebhookConfigurationCondition, conf.HealthCheckInterval, func(ctx context.Context) error {
+		return registerClient.CheckRegistration(ctx, conf)
+	})
+	if conf.WebhookReconcileInterval > 0 {
+		go registerClient.Reconcile(ctx, conf, conf.WebhookReconcileInterval)
+	}
+	watchCABundle := conf.CABundleWatchInterval > 0 || conf.CertRotationInterval > 0
+	if watchCABundle && conf.IsExistingCertificatesUsed() && (conf.CABundleFilePath != "" || conf.CABundleDir != "") {
+		go registerClient.WatchCABundle(ctx, conf, conf.CABundleWatchInterval, certRotated)
+	}
+
+	if conf.CARotationWindow > 0 {
+		// Removes the previous CA bundles from the registered configuration when the rotation window is over
+		go func() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(conf.CARotationWindow):
+			}
+			if err := registerClient.UpdateCABundle(ctx, conf, conf.GetOrResolveCABundle()); err != nil {
+				logger.Errorf("Failed to remove previous CA bundles: %v", err)
+			}
+		}()
+	}
 
 	return registerClient.Unregister
 }
@@ -0,0 +1,2826 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sversion "k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/config"
+	"github.com/networkservicemesh/cmd-admission-webhook/internal/health"
+	"github.com/networkservicemesh/cmd-admission-webhook/pkg/webhook"
+)
+
+const (
+	// testEnvPrefix is the prefix of the config envs of the tests, so they don't depend on the environment
+	testEnvPrefix = "nsm_main_test"
+	testNSURL     = "kernel://icmp-responder/nsm-1"
+	testImage     = "ghcr.io/networkservicemesh/cmd-nsc:v1.14.0"
+	testInitImage = "ghcr.io/networkservicemesh/cmd-nsc-init:v1.14.0"
+)
+
+// newTestServer returns admissionWebhookServer with testConfig and with the fake clientset containing objects.
+func newTestServer(t *testing.T, env map[string]string, objects ...runtime.Object) *admissionWebhookServer {
+	t.Helper()
+	s := newAdmissionWebhookServer(testConfig(t, env), zap.NewNop().Sugar(), fake.NewSimpleClientset(objects...))
+	close(s.initialized)
+	return s
+}
+
+// newVersionedTestServer returns newTestServer for the apiserver of gitVersion, e.g. v1.28.0.
+func newVersionedTestServer(t *testing.T, gitVersion string, env map[string]string) *admissionWebhookServer {
+	t.Helper()
+	clientset := fake.NewSimpleClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &k8sversion.Info{GitVersion: gitVersion}
+	s := newAdmissionWebhookServer(testConfig(t, env), zap.NewNop().Sugar(), clientset)
+	close(s.initialized)
+	return s
+}
+
+// testConfig returns the initialized default config changed by env. The env keys have no prefix, e.g. CONTAINER_IMAGES.
+func testConfig(t *testing.T, env map[string]string) *config.Config {
+	t.Helper()
+	defaults := map[string]string{
+		"CONTAINER_IMAGES":      testImage,
+		"INIT_CONTAINER_IMAGES": testInitImage,
+	}
+	for key, value := range defaults {
+		if _, ok := env[key]; !ok {
+			t.Setenv(strings.ToUpper(testEnvPrefix+"_"+key), value)
+		}
+	}
+	for key, value := range env {
+		t.Setenv(strings.ToUpper(testEnvPrefix+"_"+key), value)
+	}
+	conf := new(config.Config)
+	if err := envconfig.Process(testEnvPrefix, conf); err != nil {
+		t.Fatalf("failed to process config: %v", err)
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("config is not valid: %v", err)
+	}
+	if err := conf.Init(); err != nil {
+		t.Fatalf("failed to initialize config: %v", err)
+	}
+	return conf
+}
+
+// testPod returns the pod with a single app container and the annotations.
+func testPod(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta: v1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: v1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "app:v1"}},
+		},
+	}
+}
+
+// testDeployment returns the deployment with the annotations and the pod template of testPod. The annotations of
+// workloads are set on the workload itself, not on the template.
+func testDeployment(annotations map[string]string) *appsv1.Deployment {
+	pod := testPod(nil)
+	return &appsv1.Deployment{
+		TypeMeta:   v1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: v1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace, Annotations: annotations},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{Spec: pod.Spec},
+		},
+	}
+}
+
+// testRequest returns the admission request of obj. Its kind and resource are derived from the Go type.
+func testRequest(t *testing.T, operation admissionv1.Operation, obj runtime.Object) *admissionv1.AdmissionRequest {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatalf("failed to marshal %T: %v", obj, err)
+	}
+	kind := reflect.TypeOf(obj).Elem().Name()
+	group, version := "", "v1"
+	if kind != "Pod" && kind != "Namespace" {
+		group = "apps"
+	}
+	meta := obj.(v1.Object)
+	return &admissionv1.AdmissionRequest{
+		UID:       "test-uid",
+		Kind:      v1.GroupVersionKind{Group: group, Version: version, Kind: kind},
+		Resource:  v1.GroupVersionResource{Group: group, Version: version, Resource: strings.ToLower(kind) + "s"},
+		Name:      meta.GetName(),
+		Namespace: meta.GetNamespace(),
+		Operation: operation,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+// applyPatch applies the patch of the response to the admitted object and unmarshals the result into obj.
+func applyPatch(t *testing.T, in *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse, obj interface{}) {
+	t.Helper()
+	if !resp.Allowed {
+		t.Fatalf("request is denied: %v", resp.Result)
+	}
+	if resp.Patch == nil {
+		t.Fatal("response has no patch")
+	}
+	raw, _, err := webhook.ApplyPatch(in.Object.Raw, resp.Patch)
+	if err != nil {
+		t.Fatalf("failed to apply patch: %v", err)
+	}
+	if err = json.Unmarshal(raw, obj); err != nil {
+		t.Fatalf("failed to unmarshal patched object: %v", err)
+	}
+}
+
+// postReview serves the admission review body by the mutate handler and returns the response code and review.
+func postReview(t *testing.T, s *admissionWebhookServer, body string) (*httptest.ResponseRecorder, *admissionv1.AdmissionReview) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader(body))
+	if err := s.mutate(context.Background())(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	review := new(admissionv1.AdmissionReview)
+	if err := json.Unmarshal(rec.Body.Bytes(), review); err != nil {
+		t.Fatalf("failed to unmarshal admission review: %v", err)
+	}
+	if review.Response == nil {
+		t.Fatalf("admission review has no response: %s", rec.Body.String())
+	}
+	return rec, review
+}
+
+// reviewBody returns JSON encoded admission review of the request.
+func reviewBody(t *testing.T, in *admissionv1.AdmissionRequest) string {
+	t.Helper()
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: v1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+		Request:  in,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal admission review: %v", err)
+	}
+	return string(body)
+}
+
+// warnings returns the number of the warnings of the response containing substr.
+func warnings(resp *admissionv1.AdmissionResponse, substr string) int {
+	var n int
+	for _, w := range resp.Warnings {
+		if strings.Contains(w, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+// containerNamesOf returns the names of the containers.
+func containerNamesOf(containers []corev1.Container) []string {
+	var result []string
+	for i := range containers {
+		result = append(result, containers[i].Name)
+	}
+	return result
+}
+
+func TestPercentageKey(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		req  string
+		raw  string
+		want string
+	}{
+		{
+			name: "name of the request",
+			req:  "nsc",
+			raw:  `{}`,
+			want: "ns/nsc",
+		},
+		{
+			name: "name of the object",
+			raw:  `{"metadata": {"name": "nsc"}}`,
+			want: "ns/nsc",
+		},
+		{
+			name: "generateName with controller owner",
+			raw: `{"metadata": {"generateName": "nsc-7d4b9c-", "labels": {"pod-template-hash": "7d4b9c"}, "ownerReferences": [
+				{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "nsc-7d4b9c", "uid": "rs-uid", "controller": true}]}}`,
+			want: "ns/nsc-7d4b9c-/rs-uid",
+		},
+		{
+			name: "generateName with pod-template-hash",
+			raw:  `{"metadata": {"generateName": "nsc-7d4b9c-", "labels": {"pod-template-hash": "7d4b9c"}}}`,
+			want: "ns/nsc-7d4b9c-/7d4b9c",
+		},
+		{
+			name: "generateName only",
+			raw:  `{"metadata": {"generateName": "nsc-"}}`,
+			want: "ns/nsc-/",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			in := &admissionv1.AdmissionRequest{
+				UID:       "request-uid",
+				Name:      tc.req,
+				Namespace: "ns",
+				Object:    runtime.RawExtension{Raw: []byte(tc.raw)},
+			}
+			if got := percentageKey(in); got != tc.want {
+				t.Fatalf("expected key %q, got %q", tc.want, got)
+			}
+			in.UID = "another-request-uid"
+			if got := percentageKey(in); got != tc.want {
+				t.Fatalf("key depends on the request UID: %q", got)
+			}
+		})
+	}
+}
+
+func TestLimitInjectedContainers(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		limit      int
+		policy     config.LimitPolicy
+		spireWait  bool
+		wantErr    bool
+		wantInit   int
+		wantImages int
+	}{
+		{name: "no limit", spireWait: true, wantInit: 2, wantImages: 3},
+		{name: "within limit", limit: 5, wantInit: 2, wantImages: 3},
+		{name: "spire wait exceeds limit", limit: 5, spireWait: true, wantErr: true},
+		{name: "truncate", limit: 3, policy: config.TruncateLimitPolicy, wantInit: 2, wantImages: 1},
+		{name: "truncate with spire wait", limit: 3, policy: config.TruncateLimitPolicy, spireWait: true, wantInit: 2},
+		{name: "truncate init containers", limit: 2, policy: config.TruncateLimitPolicy, spireWait: true, wantInit: 1},
+		{name: "only spire wait", limit: 1, policy: config.TruncateLimitPolicy, spireWait: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := &admissionWebhookServer{config: &config.Config{
+				MaxInjectedContainers:        tc.limit,
+				MaxInjectedContainersPolicy:  tc.policy,
+				InjectSpireWaitInitContainer: tc.spireWait,
+			}}
+			inj := &injection{
+				initImages: []string{"init-1", "init-2"},
+				images:     []string{"sidecar-1", "sidecar-2", "sidecar-3"},
+			}
+			resp := new(admissionv1.AdmissionResponse)
+			err := s.limitInjectedContainers(resp, inj)
+			if tc.wantErr {
+				if !errors.Is(err, errTooManyContainers) {
+					t.Fatalf("expected errTooManyContainers, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(inj.initImages) != tc.wantInit || len(inj.images) != tc.wantImages {
+				t.Fatalf("expected %d init containers and %d containers, got %v and %v", tc.wantInit, tc.wantImages, inj.initImages, inj.images)
+			}
+			if truncated := tc.wantInit+tc.wantImages < 5; truncated != (len(resp.Warnings) == 1) {
+				t.Fatalf("unexpected warnings: %v", resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestSkipLargeObject(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		policy     config.LargeObjectPolicy
+		containers int
+		want       bool
+	}{
+		{name: "small object", policy: config.SkipLargeObjectPolicy, containers: 2},
+		{name: "process", policy: config.ProcessLargeObjectPolicy, containers: 3},
+		{name: "skip", policy: config.SkipLargeObjectPolicy, containers: 3, want: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := &admissionWebhookServer{
+				config: &config.Config{
+					LargeObjectContainers: 2,
+					LargeObjectPolicy:     tc.policy,
+				},
+				logger: zap.NewNop().Sugar(),
+			}
+			in := &admissionv1.AdmissionRequest{Name: "nsc", Namespace: "ns", Object: runtime.RawExtension{Raw: []byte(`{}`)}}
+			spec := &corev1.PodSpec{Containers: make([]corev1.Container, tc.containers)}
+			resp := new(admissionv1.AdmissionResponse)
+			if got := s.skipLargeObject(context.Background(), in, resp, spec); got != tc.want {
+				t.Fatalf("expected skip %v, got %v", tc.want, got)
+			}
+			if tc.want != (len(resp.Warnings) == 1) {
+				t.Fatalf("unexpected warnings: %v", resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestDeprecatedAnnotationWarning(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		warn string
+		want int
+	}{
+		{name: "warn", warn: "true", want: 1},
+		{name: "no warning", warn: "false"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{
+				"DEPRECATED_ANNOTATIONS":      "ns.networkservicemesh.io",
+				"WARN_DEPRECATED_ANNOTATIONS": tc.warn,
+			})
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"ns.networkservicemesh.io": testNSURL}))
+			resp := s.Review(context.Background(), in)
+
+			var pod corev1.Pod
+			applyPatch(t, in, resp, &pod)
+			if len(pod.Spec.Containers) != 2 {
+				t.Fatalf("expected the deprecated annotation to be handled, got containers %v", containerNamesOf(pod.Spec.Containers))
+			}
+			if got := warnings(resp, "is deprecated"); got != tc.want {
+				t.Fatalf("expected %d deprecation warnings, got %v", tc.want, resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestFloatingImageTagWarning(t *testing.T) {
+	pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "untagged", Image: "registry:5000/app"},
+		{Name: "latest", Image: "app:latest"},
+		{Name: "tagged", Image: "app:v1"},
+		{Name: "digest", Image: "app@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+	}
+	for _, tc := range []struct {
+		name string
+		warn string
+		want int
+	}{
+		{name: "warn", warn: "true", want: 2},
+		{name: "no warning", warn: "false"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"WARN_FLOATING_IMAGE_TAGS": tc.warn})
+			resp := s.Review(context.Background(), testRequest(t, admissionv1.Create, pod))
+			if got := warnings(resp, "without a fixed tag"); got != tc.want {
+				t.Fatalf("expected %d floating tag warnings, got %v", tc.want, resp.Warnings)
+			}
+			if tc.want > 0 && (warnings(resp, `"untagged"`) != 1 || warnings(resp, `"latest"`) != 1) {
+				t.Fatalf("unexpected warnings: %v", resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   config.OverloadPolicy
+		wantCode int
+	}{
+		{name: "reject", policy: config.RejectOverloadPolicy, wantCode: http.StatusServiceUnavailable},
+		{name: "queue", policy: config.QueueOverloadPolicy},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			started, release := make(chan struct{}, 1), make(chan struct{})
+			conf := &config.Config{MaxConcurrentAdmissions: 1, AdmissionOverloadPolicy: tc.policy}
+			handler := concurrencyLimiter(conf)(func(c echo.Context) error {
+				started <- struct{}{}
+				<-release
+				return c.NoContent(http.StatusOK)
+			})
+			e := echo.New()
+			serve := func(ctx context.Context) (*httptest.ResponseRecorder, error) {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPost, "/mutate", http.NoBody).WithContext(ctx)
+				return rec, handler(e.NewContext(req, rec))
+			}
+
+			done := make(chan *httptest.ResponseRecorder)
+			go func() {
+				rec, _ := serve(context.Background())
+				done <- rec
+			}()
+			<-started
+
+			// The second request exceeds the limit: it's rejected or waits until its context is done
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			rec, err := serve(ctx)
+			if tc.wantCode != 0 {
+				if rec.Code != tc.wantCode || rec.Header().Get("Retry-After") == "" {
+					t.Fatalf("expected %d with Retry-After, got %d %v", tc.wantCode, rec.Code, rec.Header())
+				}
+			} else if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected the queued request to wait until its context is done, got %v", err)
+			}
+
+			close(release)
+			if rec := <-done; rec.Code != http.StatusOK {
+				t.Fatalf("expected the first request to succeed, got %d", rec.Code)
+			}
+			if rec, err = serve(context.Background()); err != nil || rec.Code != http.StatusOK {
+				t.Fatalf("expected the request within the limit to succeed, got %d %v", rec.Code, err)
+			}
+		})
+	}
+}
+
+func TestLogFingerprints(t *testing.T) {
+	conf := testConfig(t, map[string]string{"WEBHOOK_MODE": "selfregister"})
+	certificate := conf.GetOrResolveCertificate()
+	core, logs := observer.New(zap.InfoLevel)
+
+	logFingerprints(zap.New(core).Sugar(), conf, &tls.Config{Certificates: []tls.Certificate{certificate}})
+
+	// The self signed certificate is the CA bundle as well
+	want := fingerprint(certificate.Certificate[0])
+	if len(want) != sha256.Size*3-1 || strings.ToUpper(want) != want {
+		t.Fatalf("unexpected fingerprint format: %s", want)
+	}
+	for _, msg := range []string{"Serving certificate SHA-256 fingerprint: ", "CA bundle certificate SHA-256 fingerprint: "} {
+		if logs.FilterMessage(msg+want).Len() != 1 {
+			t.Fatalf("expected %q to be logged, got %v", msg+want, logs.All())
+		}
+	}
+}
+
+func TestReviewUID(t *testing.T) {
+	const configMap = `"kind": {"group": "", "version": "v1", "kind": "ConfigMap"}, "operation": "CREATE", "object": {}`
+	for _, tc := range []struct {
+		name        string
+		body        string
+		wantCode    int
+		wantUID     string
+		wantAllowed bool
+	}{
+		{
+			name:        "uid is echoed",
+			body:        `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": {"uid": "abc", ` + configMap + `}}`,
+			wantCode:    http.StatusOK,
+			wantUID:     "abc",
+			wantAllowed: true,
+		},
+		{
+			name:     "empty uid",
+			body:     `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": {"uid": "", ` + configMap + `}}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "missing uid",
+			body:     `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": {` + configMap + `}}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "missing request",
+			body:     `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview"}`,
+			wantCode: http.StatusBadRequest,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rec, review := postReview(t, newTestServer(t, nil), tc.body)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("expected status %d, got %d", tc.wantCode, rec.Code)
+			}
+			if string(review.Response.UID) != tc.wantUID || review.Response.Allowed != tc.wantAllowed {
+				t.Fatalf("expected uid %q and allowed %v, got %+v", tc.wantUID, tc.wantAllowed, review.Response)
+			}
+		})
+	}
+}
+
+func TestMutationErrorPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		policy      string
+		wantAllowed bool
+	}{
+		{name: "allow", policy: "allow", wantAllowed: true},
+		{name: "deny", policy: "deny"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"ON_MUTATION_ERROR": tc.policy})
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+			in.Object.Raw = []byte(`{"metadata": {"name": "app"}, "spec": {"containers": "app"}}`)
+
+			resp := s.Review(context.Background(), in)
+			if resp.Allowed != tc.wantAllowed || resp.Patch != nil {
+				t.Fatalf("expected allowed %v without patch, got %+v", tc.wantAllowed, resp)
+			}
+			if resp.Result == nil || resp.Result.Code != http.StatusBadRequest || !strings.Contains(resp.Result.Message, "failed to mutate") {
+				t.Fatalf("unexpected result: %+v", resp.Result)
+			}
+		})
+	}
+}
+
+func TestMutationErrorPolicyInvalidObject(t *testing.T) {
+	// The object that is invalid regardless of the mutation is denied with any policy
+	s := newTestServer(t, map[string]string{"ON_MUTATION_ERROR": "allow", "REQUIRE_NETWORK_SERVICE": "true"})
+	resp := s.Review(context.Background(), testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": ""})))
+	if resp.Allowed || resp.Result == nil || !strings.Contains(resp.Result.Message, "rejected") {
+		t.Fatalf("expected the object to be rejected, got %+v", resp)
+	}
+}
+
+func TestEnabledResources(t *testing.T) {
+	annotations := map[string]string{"networkservicemesh.io": testNSURL}
+	s := newTestServer(t, map[string]string{"ENABLED_RESOURCES": "pods"})
+
+	in := testRequest(t, admissionv1.Create, testDeployment(annotations))
+	if resp := s.Review(context.Background(), in); !resp.Allowed || resp.Patch != nil {
+		t.Fatalf("expected the deployment to be admitted without changes, got %+v", resp)
+	}
+
+	in = testRequest(t, admissionv1.Create, testPod(annotations))
+	var pod corev1.Pod
+	applyPatch(t, in, s.Review(context.Background(), in), &pod)
+	if len(pod.Spec.Containers) != 2 {
+		t.Fatalf("expected the pod to be mutated, got containers %v", containerNamesOf(pod.Spec.Containers))
+	}
+}
+
+func TestInjectAnnotations(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		overwrite string
+		want      string
+	}{
+		{name: "keep existing", overwrite: "false", want: "existing"},
+		{name: "overwrite", overwrite: "true", want: "injected"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{
+				"INJECT_ANNOTATIONS":    "example.com/a:injected,example.com/b:injected",
+				"OVERWRITE_ANNOTATIONS": tc.overwrite,
+			})
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{
+				"networkservicemesh.io": testNSURL,
+				"example.com/b":         "existing",
+			}))
+			var pod corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &pod)
+
+			want := map[string]string{"networkservicemesh.io": testNSURL, "example.com/a": "injected", "example.com/b": tc.want}
+			if !reflect.DeepEqual(pod.Annotations, want) {
+				t.Fatalf("expected annotations %v, got %v", want, pod.Annotations)
+			}
+		})
+	}
+}
+
+func TestInjectAnnotationsIntoTemplate(t *testing.T) {
+	s := newTestServer(t, map[string]string{"INJECT_ANNOTATIONS": "example.com/a:injected"})
+	in := testRequest(t, admissionv1.Create, testDeployment(map[string]string{"networkservicemesh.io": testNSURL}))
+	var deployment appsv1.Deployment
+	applyPatch(t, in, s.Review(context.Background(), in), &deployment)
+
+	if want := map[string]string{"example.com/a": "injected"}; !reflect.DeepEqual(deployment.Spec.Template.Annotations, want) {
+		t.Fatalf("expected template annotations %v, got %v", want, deployment.Spec.Template.Annotations)
+	}
+	if want := map[string]string{"networkservicemesh.io": testNSURL}; !reflect.DeepEqual(deployment.Annotations, want) {
+		t.Fatalf("expected deployment annotations %v, got %v", want, deployment.Annotations)
+	}
+}
+
+func TestInjectAnnotationsOnUpdate(t *testing.T) {
+	s := newTestServer(t, map[string]string{"INJECT_ANNOTATIONS": "x:1", "MUTATE_ON_UPDATE": "true"})
+	in := testRequest(t, admissionv1.Create, testDeployment(map[string]string{"networkservicemesh.io": testNSURL}))
+	var deployment appsv1.Deployment
+	applyPatch(t, in, s.Review(context.Background(), in), &deployment)
+
+	const nsURL = "kernel://icmp-responder/nsm-2"
+	deployment.Annotations["networkservicemesh.io"] = nsURL
+	in = testRequest(t, admissionv1.Update, &deployment)
+	var updated appsv1.Deployment
+	applyPatch(t, in, s.Review(context.Background(), in), &updated)
+
+	if want := map[string]string{"x": "1"}; !reflect.DeepEqual(updated.Spec.Template.Annotations, want) {
+		t.Fatalf("expected template annotations %v, got %v", want, updated.Spec.Template.Annotations)
+	}
+	if env := envByName(containerByName(t, updated.Spec.Template.Spec.Containers, "cmd-nsc"), "NSM_NETWORK_SERVICES"); env == nil || env.Value != nsURL {
+		t.Fatalf("expected NSM_NETWORK_SERVICES=%s, got %+v", nsURL, env)
+	}
+
+	// Template annotations that aren't injected are still ambiguous
+	deployment.Spec.Template.Annotations["y"] = "2"
+	in = testRequest(t, admissionv1.Update, &deployment)
+	if resp := s.Review(context.Background(), in); resp.Allowed && resp.Patch != nil {
+		t.Fatal("expected the deployment with template annotations not to be mutated")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		enabled string
+		want    []string
+	}{
+		{name: "enabled", enabled: "true", want: []string{"decode", "match-check", "patch-build", "encode", "admission-review"}},
+		{name: "disabled", enabled: "false"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			previous := otel.GetTracerProvider()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+			t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+			s := newTestServer(t, map[string]string{"ENABLE_TRACING": tc.enabled})
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+			if _, review := postReview(t, s, reviewBody(t, in)); review.Response.Patch == nil {
+				t.Fatal("expected the pod to be mutated")
+			}
+
+			spans := recorder.Ended()
+			var names []string
+			for _, span := range spans {
+				names = append(names, span.Name())
+			}
+			if !reflect.DeepEqual(names, tc.want) {
+				t.Fatalf("expected spans %v, got %v", tc.want, names)
+			}
+			if len(spans) == 0 {
+				return
+			}
+			root := spans[len(spans)-1]
+			for _, span := range spans[:len(spans)-1] {
+				if span.Parent().SpanID() != root.SpanContext().SpanID() {
+					t.Fatalf("span %s is not a child of %s", span.Name(), root.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestInjectPodSelector(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		want   bool
+	}{
+		{name: "matching", labels: map[string]string{"app": "nsc", "tier": "web"}, want: true},
+		{name: "not matching", labels: map[string]string{"app": "web"}},
+		{name: "no labels"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"INJECT_POD_SELECTOR": "app in (nsc,nse)"})
+			pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+			pod.Labels = tc.labels
+			resp := s.Review(context.Background(), testRequest(t, admissionv1.Create, pod))
+			if !resp.Allowed || (resp.Patch != nil) != tc.want {
+				t.Fatalf("expected mutation %v, got %+v", tc.want, resp)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	s := newTestServer(t, nil)
+	// NSM_NAME of the pod with a fixed name contains a random suffix, so the patches are compared for a generated name
+	pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+	pod.Name, pod.GenerateName = "", "app-"
+	resp := s.Review(context.Background(), testRequest(t, admissionv1.Create, pod))
+
+	dryRun := true
+	in := testRequest(t, admissionv1.Create, pod)
+	in.DryRun = &dryRun
+	dryRunResp := s.Review(context.Background(), in)
+	if !dryRunResp.Allowed || string(dryRunResp.Patch) != string(resp.Patch) {
+		t.Fatalf("expected the dry run to get the same patch, got %s", dryRunResp.Patch)
+	}
+	for _, action := range s.clientset.(*fake.Clientset).Actions() {
+		if action.GetVerb() != "get" {
+			t.Fatalf("unexpected %s of %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+// containerByName returns the named container or fails the test.
+func containerByName(t *testing.T, containers []corev1.Container, name string) *corev1.Container {
+	t.Helper()
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	t.Fatalf("container %s is not found in %v", name, containerNamesOf(containers))
+	return nil
+}
+
+func TestEnvFrom(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"ENV_FROM_CONFIG_MAPS": "nsm-config,nsm-config",
+		"ENV_FROM_SECRETS":     "nsm-secret",
+	})
+	in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+	var pod corev1.Pod
+	applyPatch(t, in, s.Review(context.Background(), in), &pod)
+
+	want := []corev1.EnvFromSource{
+		{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "nsm-config"}}},
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "nsm-secret"}}},
+	}
+	for _, c := range []*corev1.Container{
+		containerByName(t, pod.Spec.InitContainers, "cmd-nsc-init"),
+		containerByName(t, pod.Spec.Containers, "cmd-nsc"),
+	} {
+		if !reflect.DeepEqual(c.EnvFrom, want) {
+			t.Fatalf("expected envFrom %v of %s, got %v", want, c.Name, c.EnvFrom)
+		}
+	}
+	if app := containerByName(t, pod.Spec.Containers, "app"); app.EnvFrom != nil {
+		t.Fatalf("expected no envFrom of the app container, got %v", app.EnvFrom)
+	}
+}
+
+func TestNSURLEnvNames(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"CONTAINER_IMAGES": testImage + ",ghcr.io/networkservicemesh/cmd-nsc-vpp:v1.14.0",
+		"NSURL_ENV_NAMES":  "cmd-nsc-vpp:NSM_NETWORK_SERVICE",
+	})
+	in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+	var pod corev1.Pod
+	applyPatch(t, in, s.Review(context.Background(), in), &pod)
+
+	for _, tc := range []struct {
+		container string
+		env       string
+		other     string
+	}{
+		{container: "cmd-nsc", env: "NSM_NETWORK_SERVICES", other: "NSM_NETWORK_SERVICE"},
+		{container: "cmd-nsc-vpp", env: "NSM_NETWORK_SERVICE", other: "NSM_NETWORK_SERVICES"},
+	} {
+		c := containerByName(t, pod.Spec.Containers, tc.container)
+		if env := envByName(c, tc.env); env == nil || env.Value != testNSURL {
+			t.Fatalf("expected %s=%s of %s, got %v", tc.env, testNSURL, tc.container, c.Env)
+		}
+		if envByName(c, tc.other) != nil {
+			t.Fatalf("unexpected %s of %s: %v", tc.other, tc.container, c.Env)
+		}
+	}
+}
+
+func TestAdjustForHostNetwork(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		adjust      string
+		hostNetwork bool
+		dnsPolicy   corev1.DNSPolicy
+		wantPodIP   bool
+		wantDNS     corev1.DNSPolicy
+	}{
+		{name: "host network", adjust: "true", hostNetwork: true, wantDNS: corev1.DNSClusterFirstWithHostNet},
+		{name: "explicit dns policy", adjust: "true", hostNetwork: true, dnsPolicy: corev1.DNSDefault, wantDNS: corev1.DNSDefault},
+		{name: "pod network", adjust: "true", wantPodIP: true},
+		{name: "disabled", adjust: "false", hostNetwork: true, wantPodIP: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{
+				"ADJUST_FOR_HOST_NETWORK": tc.adjust,
+				"ENVS":                    "NSM_LISTEN_ON=tcp://$(POD_IP):5001",
+			})
+			pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+			pod.Spec.HostNetwork = tc.hostNetwork
+			pod.Spec.DNSPolicy = tc.dnsPolicy
+			in := testRequest(t, admissionv1.Create, pod)
+			var patched corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &patched)
+
+			for _, c := range []*corev1.Container{
+				containerByName(t, patched.Spec.InitContainers, "cmd-nsc-init"),
+				containerByName(t, patched.Spec.Containers, "cmd-nsc"),
+			} {
+				if got := envByName(c, "POD_IP") != nil; got != tc.wantPodIP {
+					t.Fatalf("expected POD_IP env of %s %v, got %v", c.Name, tc.wantPodIP, c.Env)
+				}
+			}
+			if patched.Spec.DNSPolicy != tc.wantDNS {
+				t.Fatalf("expected dnsPolicy %q, got %q", tc.wantDNS, patched.Spec.DNSPolicy)
+			}
+		})
+	}
+}
+
+func TestColdStartPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		policy      string
+		timeout     string
+		wantMutated bool
+	}{
+		{name: "wait", policy: "wait", timeout: "5s", wantMutated: true},
+		{name: "wait timeout", policy: "wait", timeout: "1ms"},
+		{name: "passthrough", policy: "passthrough", timeout: "5s"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			conf := testConfig(t, map[string]string{"COLD_START_POLICY": tc.policy, "COLD_START_TIMEOUT": tc.timeout})
+			s := newAdmissionWebhookServer(conf, zap.NewNop().Sugar(), fake.NewSimpleClientset())
+			// The request is received before the initialization is done
+			timer := time.AfterFunc(100*time.Millisecond, func() { close(s.initialized) })
+			defer timer.Stop()
+
+			resp := s.Review(context.Background(), testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL})))
+			if !resp.Allowed || (resp.Patch != nil) != tc.wantMutated {
+				t.Fatalf("expected mutation %v, got %+v", tc.wantMutated, resp)
+			}
+			if passedThrough := warnings(resp, "is starting") == 1; passedThrough == tc.wantMutated {
+				t.Fatalf("unexpected warnings: %v", resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestLazyTLSConfig(t *testing.T) {
+	var serverTLSConfig = newLazyTLSConfig()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.TLS = &tls.Config{GetConfigForClient: serverTLSConfig.getConfigForClient}
+	server.StartTLS()
+	defer server.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := server.Client().Get(server.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected the handshake to wait for the TLS configuration, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The started server has the generated certificate that the client trusts
+	serverTLSConfig.set(server.TLS)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected the request to succeed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handshake doesn't finish after the TLS configuration is set")
+	}
+}
+
+func TestSidecarResourcesAnnotation(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		value       string
+		wantLimits  string
+		wantRequest string
+		wantWarning int
+	}{
+		{name: "cpu and memory", value: "cpu=500m,memory=128Mi", wantLimits: "500m/128Mi", wantRequest: "500m/128Mi"},
+		{name: "requests only", value: "requests.cpu=50m", wantLimits: "200m/80Mi", wantRequest: "50m/40Mi"},
+		{name: "malformed quantity", value: "cpu=lots", wantLimits: "200m/80Mi", wantRequest: "100m/40Mi", wantWarning: 1},
+		{name: "unknown resource", value: "gpu=1", wantLimits: "200m/80Mi", wantRequest: "100m/40Mi", wantWarning: 1},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{
+				"networkservicemesh.io":                   testNSURL,
+				"networkservicemesh.io/sidecar-resources": tc.value,
+			}))
+			resp := s.Review(context.Background(), in)
+			var pod corev1.Pod
+			applyPatch(t, in, resp, &pod)
+
+			resources := containerByName(t, pod.Spec.Containers, "cmd-nsc").Resources
+			if got := resources.Limits.Cpu().String() + "/" + resources.Limits.Memory().String(); got != tc.wantLimits {
+				t.Fatalf("expected limits %s, got %s", tc.wantLimits, got)
+			}
+			if got := resources.Requests.Cpu().String() + "/" + resources.Requests.Memory().String(); got != tc.wantRequest {
+				t.Fatalf("expected requests %s, got %s", tc.wantRequest, got)
+			}
+			if got := warnings(resp, "default sidecar resources are used"); got != tc.wantWarning {
+				t.Fatalf("expected %d warnings, got %v", tc.wantWarning, resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestSkipReasons(t *testing.T) {
+	annotations := map[string]string{"networkservicemesh.io": testNSURL}
+	deletionTimestamp := v1.Now()
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		request func(t *testing.T) *admissionv1.AdmissionRequest
+		want    string
+	}{
+		{
+			name: "no annotation",
+			request: func(t *testing.T) *admissionv1.AdmissionRequest {
+				return testRequest(t, admissionv1.Create, testPod(nil))
+			},
+			want: "Skipping Pod default/app: no_annotation",
+		},
+		{
+			name: "selector mismatch",
+			env:  map[string]string{"INJECT_POD_SELECTOR": "app=nsc"},
+			request: func(t *testing.T) *admissionv1.AdmissionRequest {
+				return testRequest(t, admissionv1.Create, testPod(annotations))
+			},
+			want: "Skipping Pod default/app: selector_mismatch",
+		},
+		{
+			name: "disabled resource",
+			env:  map[string]string{"ENABLED_RESOURCES": "pods"},
+			request: func(t *testing.T) *admissionv1.AdmissionRequest {
+				return testRequest(t, admissionv1.Create, testDeployment(annotations))
+			},
+			want: "Skipping Deployment default/app: disabled_resource",
+		},
+		{
+			name: "subresource",
+			request: func(t *testing.T) *admissionv1.AdmissionRequest {
+				in := testRequest(t, admissionv1.Create, testPod(annotations))
+				in.SubResource = "binding"
+				return in
+			},
+			want: "Skipping Pod default/app: subresource",
+		},
+		{
+			name: "terminating",
+			request: func(t *testing.T) *admissionv1.AdmissionRequest {
+				pod := testPod(annotations)
+				pod.DeletionTimestamp = &deletionTimestamp
+				return testRequest(t, admissionv1.Create, pod)
+			},
+			want: "Skipping Pod default/app: terminating",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, tc.env)
+			core, logs := observer.New(zap.DebugLevel)
+			s.logger = zap.New(core).Sugar()
+
+			if resp := s.Review(context.Background(), tc.request(t)); !resp.Allowed || resp.Patch != nil {
+				t.Fatalf("expected the resource to be admitted without changes, got %+v", resp)
+			}
+			if skipped := logs.FilterMessageSnippet("Skipping "); skipped.Len() != 1 || skipped.All()[0].Message != tc.want {
+				t.Fatalf("expected %q to be logged once, got %v", tc.want, skipped.All())
+			}
+		})
+	}
+}
+
+func TestEnvTarget(t *testing.T) {
+	for _, tc := range []struct {
+		target string
+		want   map[string]bool
+	}{
+		{target: "all", want: map[string]bool{"setup": true, "cmd-nsc-init": true, "app": true, "cmd-nsc": true}},
+		{target: "initContainers", want: map[string]bool{"setup": true, "cmd-nsc-init": true}},
+		{target: "containers", want: map[string]bool{"app": true, "cmd-nsc": true}},
+		{target: "injected-only", want: map[string]bool{"cmd-nsc-init": true, "cmd-nsc": true}},
+	} {
+		tc := tc
+		t.Run(tc.target, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"ENV_TARGET": tc.target})
+			pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+			pod.Spec.InitContainers = []corev1.Container{{Name: "setup", Image: "setup:v1"}}
+			in := testRequest(t, admissionv1.Create, pod)
+			var patched corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &patched)
+
+			for _, c := range append(patched.Spec.InitContainers, patched.Spec.Containers...) {
+				c := c
+				if got := envByName(&c, "NSM_NETWORK_SERVICES") != nil; got != tc.want[c.Name] {
+					t.Fatalf("expected env of %s %v, got %v", c.Name, tc.want[c.Name], c.Env)
+				}
+			}
+		})
+	}
+}
+
+func TestInjectTopologySpreadConstraints(t *testing.T) {
+	s := newTestServer(t, map[string]string{"INJECT_TOPOLOGY_SPREAD_CONSTRAINTS": `[
+		{"maxSkew": 2, "topologyKey": "topology.kubernetes.io/zone", "whenUnsatisfiable": "ScheduleAnyway", "labelSelector": {"matchLabels": {"app": "nsc"}}},
+		{"maxSkew": 1, "topologyKey": "kubernetes.io/hostname", "whenUnsatisfiable": "DoNotSchedule", "labelSelector": {"matchLabels": {"app": "nsc"}}}
+	]`})
+	existing := corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       "topology.kubernetes.io/zone",
+		WhenUnsatisfiable: corev1.DoNotSchedule,
+		LabelSelector:     &v1.LabelSelector{MatchLabels: map[string]string{"app": "nsc"}},
+	}
+	pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+	pod.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{existing}
+	in := testRequest(t, admissionv1.Create, pod)
+	var patched corev1.Pod
+	applyPatch(t, in, s.Review(context.Background(), in), &patched)
+
+	want := []corev1.TopologySpreadConstraint{existing, {
+		MaxSkew:           1,
+		TopologyKey:       "kubernetes.io/hostname",
+		WhenUnsatisfiable: corev1.DoNotSchedule,
+		LabelSelector:     &v1.LabelSelector{MatchLabels: map[string]string{"app": "nsc"}},
+	}}
+	if !reflect.DeepEqual(patched.Spec.TopologySpreadConstraints, want) {
+		t.Fatalf("expected constraints %+v, got %+v", want, patched.Spec.TopologySpreadConstraints)
+	}
+}
+
+func TestSidecarProbes(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"SIDECAR_READINESS_PROBE": `{"tcpSocket": {"port": 5001}, "periodSeconds": 5}`,
+		"SIDECAR_LIVENESS_PROBE":  `{"httpGet": {"path": "/healthz", "port": 8080}}`,
+	})
+	in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+	var pod corev1.Pod
+	applyPatch(t, in, s.Review(context.Background(), in), &pod)
+
+	sidecar := containerByName(t, pod.Spec.Containers, "cmd-nsc")
+	if probe := sidecar.ReadinessProbe; probe == nil || probe.TCPSocket == nil || probe.TCPSocket.Port.IntValue() != 5001 || probe.PeriodSeconds != 5 {
+		t.Fatalf("unexpected readiness probe: %+v", probe)
+	}
+	if probe := sidecar.LivenessProbe; probe == nil || probe.HTTPGet == nil || probe.HTTPGet.Path != "/healthz" {
+		t.Fatalf("unexpected liveness probe: %+v", probe)
+	}
+	if sidecar.StartupProbe != nil {
+		t.Fatalf("unexpected startup probe: %+v", sidecar.StartupProbe)
+	}
+	// Regular init containers can't have probes
+	if init := containerByName(t, pod.Spec.InitContainers, "cmd-nsc-init"); init.ReadinessProbe != nil || init.LivenessProbe != nil {
+		t.Fatalf("unexpected probes of the init container: %+v", init)
+	}
+	if app := containerByName(t, pod.Spec.Containers, "app"); app.ReadinessProbe != nil || app.LivenessProbe != nil {
+		t.Fatalf("unexpected probes of the app container: %+v", app)
+	}
+}
+
+func TestContainerInjectionPosition(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		position       string
+		gitVersion     string
+		wantInit       []string
+		wantContainers []string
+	}{
+		{name: "append", position: "append", gitVersion: "v1.28.0", wantInit: []string{"setup", "cmd-nsc-init"}, wantContainers: []string{"app", "cmd-nsc"}},
+		{name: "prepend", position: "prepend", gitVersion: "v1.28.0", wantInit: []string{"setup", "cmd-nsc-init"}, wantContainers: []string{"cmd-nsc", "app"}},
+		{name: "native", position: "native", gitVersion: "v1.28.0", wantInit: []string{"setup", "cmd-nsc-init", "cmd-nsc"}, wantContainers: []string{"app"}},
+		{name: "native fallback", position: "native", gitVersion: "v1.27.4", wantInit: []string{"setup", "cmd-nsc-init"}, wantContainers: []string{"app", "cmd-nsc"}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newVersionedTestServer(t, tc.gitVersion, map[string]string{"CONTAINER_INJECTION_POSITION": tc.position})
+			pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+			pod.Spec.InitContainers = []corev1.Container{{Name: "setup", Image: "setup:v1"}}
+			in := testRequest(t, admissionv1.Create, pod)
+			var patched corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &patched)
+
+			if got := containerNamesOf(patched.Spec.InitContainers); !reflect.DeepEqual(got, tc.wantInit) {
+				t.Fatalf("expected init containers %v, got %v", tc.wantInit, got)
+			}
+			if got := containerNamesOf(patched.Spec.Containers); !reflect.DeepEqual(got, tc.wantContainers) {
+				t.Fatalf("expected containers %v, got %v", tc.wantContainers, got)
+			}
+		})
+	}
+}
+
+func TestUseNativeSidecars(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		gitVersion string
+		want       bool
+	}{
+		{name: "native", gitVersion: "v1.28.0", want: true},
+		{name: "native on newer cluster", gitVersion: "v1.30.2-eks-1552ad0", want: true},
+		{name: "fallback", gitVersion: "v1.27.4"},
+		{name: "unknown version", gitVersion: "unknown"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newVersionedTestServer(t, tc.gitVersion, map[string]string{"USE_NATIVE_SIDECARS": "true"})
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+			var pod corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &pod)
+
+			containers := pod.Spec.Containers
+			if tc.want {
+				containers = pod.Spec.InitContainers
+			}
+			sidecar := containerByName(t, containers, "cmd-nsc")
+			if native := sidecar.RestartPolicy != nil && *sidecar.RestartPolicy == corev1.ContainerRestartPolicyAlways; native != tc.want {
+				t.Fatalf("expected native sidecar %v, got restartPolicy %v", tc.want, sidecar.RestartPolicy)
+			}
+			if init := containerByName(t, pod.Spec.InitContainers, "cmd-nsc-init"); init.RestartPolicy != nil {
+				t.Fatalf("expected regular init container, got restartPolicy %v", *init.RestartPolicy)
+			}
+		})
+	}
+}
+
+func TestSidecarImageAnnotation(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		value       string
+		want        string
+		wantWarning string
+	}{
+		{name: "override", value: "registry:5000/canary/cmd-nsc:v1.15.0-rc.1", want: "registry:5000/canary/cmd-nsc:v1.15.0-rc.1"},
+		{name: "malformed reference", value: "Registry/CMD-NSC:", want: testImage, wantWarning: "malformed"},
+		{name: "unknown sidecar", value: "ghcr.io/networkservicemesh/cmd-nse:v1.15.0", want: testImage, wantWarning: "doesn't match any sidecar image"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{
+				"networkservicemesh.io":               testNSURL,
+				"networkservicemesh.io/sidecar-image": tc.value,
+			}))
+			resp := s.Review(context.Background(), in)
+			var pod corev1.Pod
+			applyPatch(t, in, resp, &pod)
+
+			if got := containerByName(t, pod.Spec.Containers, "cmd-nsc").Image; got != tc.want {
+				t.Fatalf("expected image %s, got %s", tc.want, got)
+			}
+			if got := containerByName(t, pod.Spec.InitContainers, "cmd-nsc-init").Image; got != testInitImage {
+				t.Fatalf("expected init container image %s, got %s", testInitImage, got)
+			}
+			if tc.wantWarning != "" && warnings(resp, tc.wantWarning) != 1 {
+				t.Fatalf("expected %q warning, got %v", tc.wantWarning, resp.Warnings)
+			}
+			if tc.wantWarning == "" && len(resp.Warnings) != 0 {
+				t.Fatalf("unexpected warnings: %v", resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestPrepareTLSConfig(t *testing.T) {
+	for _, disable := range []bool{true, false} {
+		disable := disable
+		t.Run(fmt.Sprintf("disable session tickets %v", disable), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			conf := testConfig(t, map[string]string{
+				"WEBHOOK_MODE":            "selfregister",
+				"DISABLE_SESSION_TICKETS": strconv.FormatBool(disable),
+			})
+
+			tlsConfig, err := prepareTLSConfig(ctx, conf, zap.NewNop().Sugar(), new(health.Readiness), nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tlsConfig.SessionTicketsDisabled != disable {
+				t.Fatalf("expected SessionTicketsDisabled %v, got %v", disable, tlsConfig.SessionTicketsDisabled)
+			}
+			if tlsConfig.Renegotiation != tls.RenegotiateNever || tlsConfig.MinVersion != tls.VersionTLS12 {
+				t.Fatalf("unexpected renegotiation %v or min version %x", tlsConfig.Renegotiation, tlsConfig.MinVersion)
+			}
+			if len(tlsConfig.Certificates) != 1 {
+				t.Fatalf("expected the self signed certificate, got %d certificates", len(tlsConfig.Certificates))
+			}
+		})
+	}
+}
+
+func TestSubresources(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		operation   admissionv1.Operation
+		subresource string
+		wantWarning bool
+	}{
+		{name: "status update", operation: admissionv1.Update, subresource: "status"},
+		{name: "status create", operation: admissionv1.Create, subresource: "status"},
+		{name: "ignored case insensitive", operation: admissionv1.Create, subresource: "Exec"},
+		{name: "not ignored", operation: admissionv1.Create, subresource: "binding", wantWarning: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			core, logs := observer.New(zap.WarnLevel)
+			s.logger = zap.New(core).Sugar()
+
+			in := testRequest(t, tc.operation, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+			in.SubResource = tc.subresource
+			if resp := s.Review(context.Background(), in); !resp.Allowed || resp.Patch != nil {
+				t.Fatalf("expected the request to be admitted without changes, got %+v", resp)
+			}
+			if got := logs.FilterMessageSnippet("Unsupported subresource").Len() == 1; got != tc.wantWarning {
+				t.Fatalf("expected warning %v, got %v", tc.wantWarning, logs.All())
+			}
+		})
+	}
+}
+
+func TestProjectedSATokenVolume(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"INJECT_PROJECTED_SA_TOKEN_VOLUME":      "true",
+		"PROJECTED_SA_TOKEN_AUDIENCE":           "spire-server",
+		"PROJECTED_SA_TOKEN_EXPIRATION_SECONDS": "7200",
+	})
+	in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+	var pod corev1.Pod
+	applyPatch(t, in, s.Review(context.Background(), in), &pod)
+
+	var volume *corev1.Volume
+	for i := range pod.Spec.Volumes {
+		if pod.Spec.Volumes[i].Name == projectedSATokenVolume {
+			volume = &pod.Spec.Volumes[i]
+		}
+	}
+	if volume == nil || volume.Projected == nil || len(volume.Projected.Sources) != 1 {
+		t.Fatalf("expected the projected volume, got %+v", pod.Spec.Volumes)
+	}
+	token := volume.Projected.Sources[0].ServiceAccountToken
+	if token == nil || token.Audience != "spire-server" || token.ExpirationSeconds == nil || *token.ExpirationSeconds != 7200 {
+		t.Fatalf("unexpected service account token projection: %+v", token)
+	}
+
+	want := corev1.VolumeMount{Name: projectedSATokenVolume, MountPath: "/var/run/secrets/tokens", ReadOnly: true}
+	for _, c := range []*corev1.Container{
+		containerByName(t, pod.Spec.InitContainers, "cmd-nsc-init"),
+		containerByName(t, pod.Spec.Containers, "cmd-nsc"),
+	} {
+		var found bool
+		for _, m := range c.VolumeMounts {
+			found = found || m == want
+		}
+		if !found {
+			t.Fatalf("expected mount %+v of %s, got %+v", want, c.Name, c.VolumeMounts)
+		}
+	}
+	for _, m := range containerByName(t, pod.Spec.Containers, "app").VolumeMounts {
+		if m.Name == projectedSATokenVolume {
+			t.Fatal("unexpected projected token mount of the app container")
+		}
+	}
+}
+
+func TestTerminating(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		terminating bool
+	}{
+		{name: "terminating", terminating: true},
+		{name: "not terminating"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"MUTATE_ON_UPDATE": "true"})
+			deployment := testDeployment(map[string]string{"networkservicemesh.io": testNSURL})
+			if tc.terminating {
+				deletionTimestamp := v1.Now()
+				deployment.DeletionTimestamp = &deletionTimestamp
+			}
+			resp := s.Review(context.Background(), testRequest(t, admissionv1.Update, deployment))
+			if !resp.Allowed || (resp.Patch == nil) != tc.terminating {
+				t.Fatalf("expected mutation %v, got %+v", !tc.terminating, resp)
+			}
+		})
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		policy      string
+		wantAllowed bool
+	}{
+		{name: "allow", policy: "allow", wantAllowed: true},
+		{name: "deny", policy: "deny"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"HANDLER_TIMEOUT": "50ms", "ON_MUTATION_ERROR": tc.policy})
+			// The namespace lookup is slower than the handler deadline
+			s.clientset.(*fake.Clientset).PrependReactor("get", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+				time.Sleep(200 * time.Millisecond)
+				return false, nil, nil
+			})
+
+			resp := s.Review(context.Background(), testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL})))
+			if resp.Allowed != tc.wantAllowed || resp.Patch != nil {
+				t.Fatalf("expected allowed %v without patch, got %+v", tc.wantAllowed, resp)
+			}
+			if resp.Result == nil || !strings.Contains(resp.Result.Message, "admission request timed out") {
+				t.Fatalf("unexpected result: %+v", resp.Result)
+			}
+		})
+	}
+}
+
+func TestGenerateName(t *testing.T) {
+	s := newTestServer(t, nil)
+	core, logs := observer.New(zap.DebugLevel)
+	s.logger = zap.New(core).Sugar()
+
+	pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+	pod.Name, pod.GenerateName = "", "app-"
+	in := testRequest(t, admissionv1.Create, pod)
+	var patched corev1.Pod
+	applyPatch(t, in, s.Review(context.Background(), in), &patched)
+
+	sidecar := containerByName(t, patched.Spec.Containers, "cmd-nsc")
+	if env := envByName(sidecar, "NSM_NAME"); env == nil || env.Value != "$(POD_NAME)" {
+		t.Fatalf("expected NSM_NAME to be the generated pod name, got %+v", env)
+	}
+
+	pod.Annotations = nil
+	s.Review(context.Background(), testRequest(t, admissionv1.Create, pod))
+	if want := "Skipping Pod default/app-<generated>(uid: test-uid): no_annotation"; logs.FilterMessage(want).Len() != 1 {
+		t.Fatalf("expected %q to be logged, got %v", want, logs.FilterMessageSnippet("Skipping").All())
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	readiness := new(health.Readiness)
+	handler := readinessHandler(&config.Config{WebhookMode: config.SelfregisterMode}, readiness)
+	serve := func() (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		if err := handler(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/ready", http.NoBody), rec)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("failed to unmarshal readiness: %v", err)
+		}
+		return rec.Code, body
+	}
+
+	readiness.Set(health.CertificateCondition, nil)
+	readiness.Set(health.WebhookConfigurationCondition, errors.New("caBundle doesn't match"))
+	code, body := serve()
+	if code != http.StatusServiceUnavailable || body["mode"] != "selfregister" {
+		t.Fatalf("expected not ready selfregister mode, got %d %v", code, body)
+	}
+	if conditions, ok := body["conditions"].([]interface{}); !ok || len(conditions) != 2 {
+		t.Fatalf("expected two conditions, got %v", body["conditions"])
+	}
+
+	readiness.Set(health.WebhookConfigurationCondition, nil)
+	if code, body = serve(); code != http.StatusOK {
+		t.Fatalf("expected ready, got %d %v", code, body)
+	}
+}
+
+func TestSidecarPreStopAndGracePeriod(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		period int64
+		want   int64
+	}{
+		{name: "default period", want: 60},
+		{name: "lower period", period: 10, want: 60},
+		{name: "higher period", period: 120, want: 120},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{
+				"SIDECAR_PRE_STOP":                     `{"exec": {"command": ["sleep", "5"]}}`,
+				"MIN_TERMINATION_GRACE_PERIOD_SECONDS": "60",
+			})
+			pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+			if tc.period > 0 {
+				pod.Spec.TerminationGracePeriodSeconds = &tc.period
+			}
+			in := testRequest(t, admissionv1.Create, pod)
+			var patched corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &patched)
+
+			if got := patched.Spec.TerminationGracePeriodSeconds; got == nil || *got != tc.want {
+				t.Fatalf("expected terminationGracePeriodSeconds %d, got %v", tc.want, got)
+			}
+			sidecar := containerByName(t, patched.Spec.Containers, "cmd-nsc")
+			if sidecar.Lifecycle == nil || sidecar.Lifecycle.PreStop == nil || sidecar.Lifecycle.PreStop.Exec == nil ||
+				!reflect.DeepEqual(sidecar.Lifecycle.PreStop.Exec.Command, []string{"sleep", "5"}) {
+				t.Fatalf("unexpected lifecycle of the sidecar: %+v", sidecar.Lifecycle)
+			}
+			if app := containerByName(t, patched.Spec.Containers, "app"); app.Lifecycle != nil {
+				t.Fatalf("unexpected lifecycle of the app container: %+v", app.Lifecycle)
+			}
+		})
+	}
+}
+
+func TestAuditAnnotations(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		emit string
+		want map[string]string
+	}{
+		{
+			name: "emit",
+			emit: "true",
+			want: map[string]string{"injected-init-containers": "1", "injected-containers": "1", "network-services": testNSURL},
+		},
+		{name: "disabled", emit: "false"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"EMIT_AUDIT_ANNOTATIONS": tc.emit})
+			resp := s.Review(context.Background(), testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL})))
+			if resp.Patch == nil {
+				t.Fatal("expected the pod to be mutated")
+			}
+			if !reflect.DeepEqual(resp.AuditAnnotations, tc.want) {
+				t.Fatalf("expected audit annotations %v, got %v", tc.want, resp.AuditAnnotations)
+			}
+		})
+	}
+}
+
+func TestAuditAnnotationsOfSkippedResource(t *testing.T) {
+	s := newTestServer(t, map[string]string{"EMIT_AUDIT_ANNOTATIONS": "true"})
+	if resp := s.Review(context.Background(), testRequest(t, admissionv1.Create, testPod(nil))); resp.AuditAnnotations != nil {
+		t.Fatalf("unexpected audit annotations of the resource that is not mutated: %v", resp.AuditAnnotations)
+	}
+}
+
+func TestRequireServiceAccount(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		policy         string
+		serviceAccount string
+		deprecated     string
+		wantAllowed    bool
+		wantPatched    string
+	}{
+		{name: "reject", policy: "reject", serviceAccount: "app"},
+		{name: "reject default", policy: "reject"},
+		{name: "reject matching", policy: "reject", serviceAccount: "nsm-client", wantAllowed: true, wantPatched: "nsm-client"},
+		{name: "patch", policy: "patch", serviceAccount: "app", wantAllowed: true, wantPatched: "nsm-client"},
+		{name: "patch deprecated", policy: "patch", deprecated: "app", wantAllowed: true, wantPatched: "nsm-client"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"REQUIRE_SERVICE_ACCOUNT": "nsm-client", "SERVICE_ACCOUNT_POLICY": tc.policy})
+			pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+			pod.Spec.ServiceAccountName = tc.serviceAccount
+			pod.Spec.DeprecatedServiceAccount = tc.deprecated
+			in := testRequest(t, admissionv1.Create, pod)
+			resp := s.Review(context.Background(), in)
+			if !tc.wantAllowed {
+				if resp.Allowed || resp.Patch != nil || resp.Result == nil || resp.Result.Code != http.StatusForbidden {
+					t.Fatalf("expected the pod to be forbidden, got %+v", resp)
+				}
+				return
+			}
+
+			var patched corev1.Pod
+			applyPatch(t, in, resp, &patched)
+			if patched.Spec.ServiceAccountName != tc.wantPatched {
+				t.Fatalf("expected service account %s, got %s", tc.wantPatched, patched.Spec.ServiceAccountName)
+			}
+			if tc.deprecated != "" && patched.Spec.DeprecatedServiceAccount != tc.wantPatched {
+				t.Fatalf("expected deprecated service account %s, got %s", tc.wantPatched, patched.Spec.DeprecatedServiceAccount)
+			}
+		})
+	}
+}
+
+func TestMutateOnUpdate(t *testing.T) {
+	annotations := map[string]string{"networkservicemesh.io": testNSURL}
+	injected := testDeployment(annotations)
+	injected.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "cmd-nsc-init", Image: testInitImage}}
+	injected.Spec.Template.Spec.Containers = append(injected.Spec.Template.Spec.Containers, corev1.Container{
+		Name:  "cmd-nsc",
+		Image: testImage,
+		Env:   []corev1.EnvVar{{Name: "NSM_NETWORK_SERVICES", Value: testNSURL}},
+	})
+	outdated := injected.DeepCopy()
+	outdated.Spec.Template.Spec.Containers[1].Image = "ghcr.io/networkservicemesh/cmd-nsc:v1.13.0"
+	// The sidecars are injected for the previous network services
+	changedNS := injected.DeepCopy()
+	changedNS.Spec.Template.Spec.Containers[1].Env[0].Value = "kernel://icmp-responder/nsm-2"
+
+	for _, tc := range []struct {
+		name           string
+		mutateOnUpdate string
+		obj            runtime.Object
+		wantMutated    bool
+	}{
+		{name: "disabled", mutateOnUpdate: "false", obj: testDeployment(annotations)},
+		{name: "not injected", mutateOnUpdate: "true", obj: testDeployment(annotations), wantMutated: true},
+		{name: "already injected", mutateOnUpdate: "true", obj: injected},
+		{name: "outdated injection", mutateOnUpdate: "true", obj: outdated, wantMutated: true},
+		{name: "changed network services", mutateOnUpdate: "true", obj: changedNS, wantMutated: true},
+		{name: "pod", mutateOnUpdate: "true", obj: testPod(annotations)},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"MUTATE_ON_UPDATE": tc.mutateOnUpdate})
+			in := testRequest(t, admissionv1.Update, tc.obj)
+			resp := s.Review(context.Background(), in)
+			if !tc.wantMutated {
+				if !resp.Allowed || resp.Patch != nil {
+					t.Fatalf("expected the request to be admitted without changes, got %+v", resp)
+				}
+				return
+			}
+
+			var patched appsv1.Deployment
+			applyPatch(t, in, resp, &patched)
+			spec := patched.Spec.Template.Spec
+			if got := containerNamesOf(spec.Containers); !reflect.DeepEqual(got, []string{"app", "cmd-nsc"}) {
+				t.Fatalf("expected containers [app cmd-nsc], got %v", got)
+			}
+			sidecar := containerByName(t, spec.Containers, "cmd-nsc")
+			if sidecar.Image != testImage {
+				t.Fatalf("expected image %s, got %s", testImage, sidecar.Image)
+			}
+			if env := envByName(sidecar, "NSM_NETWORK_SERVICES"); env == nil || env.Value != testNSURL {
+				t.Fatalf("expected NSM_NETWORK_SERVICES=%s, got %+v", testNSURL, env)
+			}
+			if got := containerNamesOf(spec.InitContainers); !reflect.DeepEqual(got, []string{"cmd-nsc-init"}) {
+				t.Fatalf("expected init containers [cmd-nsc-init], got %v", got)
+			}
+		})
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+	}{
+		{name: "admission review", body: reviewBody(t, testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL})))},
+		{name: "decode error", body: `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": {"uid": "abc", "operation": 1}}`},
+		{name: "decode error without uid", body: `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview"}`},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rec, _ := postReview(t, newTestServer(t, nil), tc.body)
+			if got := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(got, echo.MIMEApplicationJSON) {
+				t.Fatalf("expected content type %s, got %s", echo.MIMEApplicationJSON, got)
+			}
+			if got := rec.Header().Get(echo.HeaderCacheControl); got != "no-store" {
+				t.Fatalf("expected cache control no-store, got %s", got)
+			}
+		})
+	}
+}
+
+func TestProtectedContainers(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"PROTECTED_CONTAINERS":        "cni-init,security-agent",
+		"ENV_TARGET":                  "all",
+		"APP_CONTAINER_VOLUME_MOUNTS": "nsm-socket:/var/lib/networkservicemesh",
+	})
+	pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+	pod.Spec.InitContainers = []corev1.Container{{Name: "cni-init", Image: "cni:v1"}}
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:  "security-agent",
+		Image: "agent:v1",
+		Env:   []corev1.EnvVar{{Name: "AGENT_MODE", Value: "enforce"}},
+	})
+	in := testRequest(t, admissionv1.Create, pod)
+
+	var patched corev1.Pod
+	applyPatch(t, in, s.Review(context.Background(), in), &patched)
+	protected := []corev1.Container{pod.Spec.InitContainers[0], pod.Spec.Containers[1]}
+	containers := append(append([]corev1.Container(nil), patched.Spec.InitContainers...), patched.Spec.Containers...)
+	for i := range protected {
+		if got := containerByName(t, containers, protected[i].Name); !reflect.DeepEqual(*got, protected[i]) {
+			t.Fatalf("expected protected container %+v, got %+v", protected[i], *got)
+		}
+	}
+	app := containerByName(t, patched.Spec.Containers, "app")
+	if envByName(app, "NSM_NETWORK_SERVICES") == nil || len(app.VolumeMounts) != 1 {
+		t.Fatalf("expected the app container to get the envs and the volume mount, got %+v", app)
+	}
+}
+
+func TestInjectionPercentage(t *testing.T) {
+	const pods = 2000
+	for _, percentage := range []int{0, 30, 100} {
+		percentage := percentage
+		t.Run(strconv.Itoa(percentage), func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"INJECTION_PERCENTAGE": strconv.Itoa(percentage)})
+			var selected int
+			for i := 0; i < pods; i++ {
+				in := &admissionv1.AdmissionRequest{UID: "request-uid", Name: fmt.Sprintf("nsc-%d", i), Namespace: "ns"}
+				decision := s.isSelectedByPercentage(context.Background(), in)
+				in.UID = "another-request-uid"
+				if s.isSelectedByPercentage(context.Background(), in) != decision {
+					t.Fatalf("decision for %s is not stable", in.Name)
+				}
+				if decision {
+					selected++
+				}
+			}
+			// The distribution of the hashes is deterministic, so the tolerance only accounts for its unevenness
+			if got, want := selected*100/pods, percentage; got < want-5 || got > want+5 {
+				t.Fatalf("expected about %d%% of pods to be selected, got %d%%", want, got)
+			}
+		})
+	}
+}
+
+func TestDefaultNetworkService(t *testing.T) {
+	const defaultNSURL = "kernel://default-service/nsm-1"
+	for _, tc := range []struct {
+		name        string
+		env         map[string]string
+		annotation  string
+		wantAllowed bool
+		wantNSURL   string
+	}{
+		{name: "fallback", env: map[string]string{"DEFAULT_NETWORK_SERVICE": defaultNSURL}, wantAllowed: true, wantNSURL: defaultNSURL},
+		{name: "fallback for blank annotation", env: map[string]string{"DEFAULT_NETWORK_SERVICE": defaultNSURL}, annotation: " ", wantAllowed: true, wantNSURL: defaultNSURL},
+		{name: "own network service", env: map[string]string{"DEFAULT_NETWORK_SERVICE": defaultNSURL}, annotation: testNSURL, wantAllowed: true, wantNSURL: testNSURL},
+		{name: "fallback instead of reject", env: map[string]string{"DEFAULT_NETWORK_SERVICE": defaultNSURL, "REQUIRE_NETWORK_SERVICE": "true"}, wantAllowed: true, wantNSURL: defaultNSURL},
+		{name: "reject", env: map[string]string{"REQUIRE_NETWORK_SERVICE": "true"}},
+		{name: "not mutated", wantAllowed: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, tc.env)
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": tc.annotation}))
+			resp := s.Review(context.Background(), in)
+			if resp.Allowed != tc.wantAllowed {
+				t.Fatalf("expected allowed %v, got %+v", tc.wantAllowed, resp)
+			}
+			if tc.wantNSURL == "" {
+				if resp.Patch != nil {
+					t.Fatalf("expected no patch, got %s", resp.Patch)
+				}
+				return
+			}
+
+			var patched corev1.Pod
+			applyPatch(t, in, resp, &patched)
+			env := envByName(containerByName(t, patched.Spec.Containers, "cmd-nsc"), "NSM_NETWORK_SERVICES")
+			if env == nil || env.Value != tc.wantNSURL {
+				t.Fatalf("expected network services %s, got %+v", tc.wantNSURL, env)
+			}
+		})
+	}
+}
+
+func TestHonorNamespaceAnnotation(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		honor       string
+		annotations map[string]string
+		wantMutated bool
+	}{
+		{name: "annotated namespace", honor: "true", annotations: map[string]string{"networkservicemesh.io": testNSURL}, wantMutated: true},
+		{name: "not annotated namespace", honor: "true"},
+		{name: "disabled", honor: "false", annotations: map[string]string{"networkservicemesh.io": testNSURL}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			namespace := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "default", Annotations: tc.annotations}}
+			s := newTestServer(t, map[string]string{"HONOR_NAMESPACE_ANNOTATION": tc.honor}, namespace)
+			in := testRequest(t, admissionv1.Create, testDeployment(nil))
+			resp := s.Review(context.Background(), in)
+			if !tc.wantMutated {
+				if !resp.Allowed || resp.Patch != nil {
+					t.Fatalf("expected the deployment to be admitted without changes, got %+v", resp)
+				}
+				return
+			}
+
+			var patched appsv1.Deployment
+			applyPatch(t, in, resp, &patched)
+			env := envByName(containerByName(t, patched.Spec.Template.Spec.Containers, "cmd-nsc"), "NSM_NETWORK_SERVICES")
+			if env == nil || env.Value != testNSURL {
+				t.Fatalf("expected network services of the namespace %s, got %+v", testNSURL, env)
+			}
+		})
+	}
+}
+
+func TestMaxInjectedContainers(t *testing.T) {
+	const secondImage = "ghcr.io/networkservicemesh/cmd-nsc-vpp:v1.14.0"
+	for _, tc := range []struct {
+		name   string
+		policy string
+	}{
+		{name: "reject", policy: "reject"},
+		{name: "truncate", policy: "truncate"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{
+				"CONTAINER_IMAGES":               testImage + "," + secondImage,
+				"MAX_INJECTED_CONTAINERS":        "2",
+				"MAX_INJECTED_CONTAINERS_POLICY": tc.policy,
+			})
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+			resp := s.Review(context.Background(), in)
+			if tc.policy == "reject" {
+				// The object is rejected regardless of Config.OnMutationError
+				if resp.Allowed || resp.Patch != nil || resp.Result == nil || !strings.Contains(resp.Result.Message, "too many injected containers") {
+					t.Fatalf("expected the pod to be rejected, got %+v", resp)
+				}
+				return
+			}
+
+			var patched corev1.Pod
+			applyPatch(t, in, resp, &patched)
+			if got := containerNamesOf(patched.Spec.Containers); !reflect.DeepEqual(got, []string{"app", "cmd-nsc"}) {
+				t.Fatalf("expected containers [app cmd-nsc], got %v", got)
+			}
+			if got := containerNamesOf(patched.Spec.InitContainers); !reflect.DeepEqual(got, []string{"cmd-nsc-init"}) {
+				t.Fatalf("expected init containers [cmd-nsc-init], got %v", got)
+			}
+			if warnings(resp, "only the first 2 are injected") != 1 {
+				t.Fatalf("expected a truncation warning, got %v", resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestEphemeralContainers(t *testing.T) {
+	annotated := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+	annotated.Spec.Volumes = []corev1.Volume{{Name: "nsm-socket"}}
+	debugged := annotated.DeepCopy()
+	debugged.Spec.EphemeralContainers = []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "cmd-nsc", Image: testImage}}}
+
+	for _, tc := range []struct {
+		name      string
+		env       map[string]string
+		operation admissionv1.Operation
+		pod       *corev1.Pod
+		wantImage string
+	}{
+		{name: "disabled", operation: admissionv1.Update, pod: annotated},
+		{name: "enabled", env: map[string]string{"ENABLE_EPHEMERAL_INJECTION": "true"}, operation: admissionv1.Update, pod: annotated, wantImage: testImage},
+		{
+			name:      "configured image",
+			env:       map[string]string{"ENABLE_EPHEMERAL_INJECTION": "true", "EPHEMERAL_CONTAINER_IMAGE": "ghcr.io/networkservicemesh/nsm-debug:v1.14.0"},
+			operation: admissionv1.Update,
+			pod:       annotated,
+			wantImage: "ghcr.io/networkservicemesh/nsm-debug:v1.14.0",
+		},
+		{name: "not annotated", env: map[string]string{"ENABLE_EPHEMERAL_INJECTION": "true"}, operation: admissionv1.Update, pod: testPod(nil)},
+		{name: "already injected", env: map[string]string{"ENABLE_EPHEMERAL_INJECTION": "true"}, operation: admissionv1.Update, pod: debugged},
+		{name: "create", env: map[string]string{"ENABLE_EPHEMERAL_INJECTION": "true"}, operation: admissionv1.Create, pod: annotated},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, tc.env)
+			in := testRequest(t, tc.operation, tc.pod)
+			in.SubResource = ephemeralContainersSubresource
+			resp := s.Review(context.Background(), in)
+			if tc.wantImage == "" {
+				if !resp.Allowed || resp.Patch != nil {
+					t.Fatalf("expected the request to be admitted without changes, got %+v", resp)
+				}
+				return
+			}
+
+			var patched corev1.Pod
+			applyPatch(t, in, resp, &patched)
+			if len(patched.Spec.EphemeralContainers) != 1 {
+				t.Fatalf("expected a single ephemeral container, got %+v", patched.Spec.EphemeralContainers)
+			}
+			c := corev1.Container(patched.Spec.EphemeralContainers[0].EphemeralContainerCommon)
+			if c.Name != nameOf(tc.wantImage) || c.Image != tc.wantImage {
+				t.Fatalf("expected ephemeral container of %s, got %s %s", tc.wantImage, c.Name, c.Image)
+			}
+			if env := envByName(&c, "NSM_NETWORK_SERVICES"); env == nil || env.Value != testNSURL {
+				t.Fatalf("expected network services %s, got %+v", testNSURL, env)
+			}
+			if len(c.Resources.Limits) != 0 || len(c.Resources.Requests) != 0 {
+				t.Fatalf("expected no resources, got %+v", c.Resources)
+			}
+			if len(c.VolumeMounts) != 1 || c.VolumeMounts[0].Name != "nsm-socket" {
+				t.Fatalf("expected only the mount of the existing volume, got %+v", c.VolumeMounts)
+			}
+		})
+	}
+}
+
+var (
+	metricReaderOnce sync.Once
+	metricReader     *sdkmetric.ManualReader
+)
+
+// collectMetrics returns the metrics recorded by the tests. The global meter provider delegates to the first provider
+// only, so the reader is shared by the tests and the values are cumulative.
+func collectMetrics(t *testing.T) *metricdata.ResourceMetrics {
+	t.Helper()
+	metricReaderOnce.Do(func() {
+		metricReader = sdkmetric.NewManualReader()
+		otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(metricReader)))
+	})
+	rm := new(metricdata.ResourceMetrics)
+	if err := metricReader.Collect(context.Background(), rm); err != nil {
+		t.Fatalf("failed to collect metrics: %v", err)
+	}
+	return rm
+}
+
+// histogramCount returns the number of the observations of the histogram with the attributes matching match.
+func histogramCount(t *testing.T, name string, match func(attrs attribute.Set) bool) uint64 {
+	t.Helper()
+	var count uint64
+	for _, sm := range collectMetrics(t).ScopeMetrics {
+		for _, m := range sm.Metrics {
+			histogram, ok := m.Data.(metricdata.Histogram[int64])
+			if m.Name != name || !ok {
+				continue
+			}
+			for i := range histogram.DataPoints {
+				if match(histogram.DataPoints[i].Attributes) {
+					count += histogram.DataPoints[i].Count
+				}
+			}
+		}
+	}
+	return count
+}
+
+// counterValue returns the sum of the counter with the attributes matching match.
+func counterValue(t *testing.T, name string, match func(attrs attribute.Set) bool) int64 {
+	t.Helper()
+	var value int64
+	for _, sm := range collectMetrics(t).ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if m.Name != name || !ok {
+				continue
+			}
+			for i := range sum.DataPoints {
+				if match(sum.DataPoints[i].Attributes) {
+					value += sum.DataPoints[i].Value
+				}
+			}
+		}
+	}
+	return value
+}
+
+func TestPatchSize(t *testing.T) {
+	isPod := func(attrs attribute.Set) bool {
+		kind, _ := attrs.Value("kind")
+		return kind.AsString() == "Pod"
+	}
+	for _, tc := range []struct {
+		name        string
+		threshold   string
+		wantWarning bool
+	}{
+		{name: "exceeds threshold", threshold: "100", wantWarning: true},
+		{name: "within threshold", threshold: "1048576"},
+		{name: "disabled", threshold: "0"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			before := histogramCount(t, "webhook_patch_bytes", isPod)
+			s := newTestServer(t, map[string]string{"PATCH_SIZE_WARNING_BYTES": tc.threshold})
+			core, logs := observer.New(zap.WarnLevel)
+			s.logger = zap.New(core).Sugar()
+
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+			if resp := s.Review(context.Background(), in); resp.Patch == nil {
+				t.Fatal("expected the pod to be mutated")
+			}
+			if got := histogramCount(t, "webhook_patch_bytes", isPod) - before; got != 1 {
+				t.Fatalf("expected a single patch size observation, got %d", got)
+			}
+			if got := logs.FilterMessageSnippet("which exceeds "+tc.threshold+" bytes").Len() == 1; got != tc.wantWarning {
+				t.Fatalf("expected warning %v, got %v", tc.wantWarning, logs.All())
+			}
+		})
+	}
+}
+
+func TestInjectGOMAXPROCS(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{name: "fraction of CPU", env: map[string]string{"INJECT_GOMAXPROCS": "true", "SIDECAR_LIMITS_CPU": "200m"}, want: "1"},
+		{name: "single CPU", env: map[string]string{"INJECT_GOMAXPROCS": "true", "SIDECAR_LIMITS_CPU": "1"}, want: "1"},
+		{name: "rounded up", env: map[string]string{"INJECT_GOMAXPROCS": "true", "SIDECAR_LIMITS_CPU": "1500m"}, want: "2"},
+		{name: "several CPUs", env: map[string]string{"INJECT_GOMAXPROCS": "true", "SIDECAR_LIMITS_CPU": "4"}, want: "4"},
+		{name: "configured env", env: map[string]string{"INJECT_GOMAXPROCS": "true", "SIDECAR_LIMITS_CPU": "4", "ENVS": "GOMAXPROCS=8"}, want: "8"},
+		{name: "disabled", env: map[string]string{"SIDECAR_LIMITS_CPU": "4"}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, tc.env)
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+			var patched corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &patched)
+			for _, c := range []*corev1.Container{
+				containerByName(t, patched.Spec.InitContainers, "cmd-nsc-init"),
+				containerByName(t, patched.Spec.Containers, "cmd-nsc"),
+			} {
+				var got string
+				for i := range c.Env {
+					if c.Env[i].Name == "GOMAXPROCS" {
+						if got != "" {
+							t.Fatalf("container %s has duplicated GOMAXPROCS env", c.Name)
+						}
+						got = c.Env[i].Value
+					}
+				}
+				if got != tc.want {
+					t.Fatalf("expected GOMAXPROCS %q of container %s, got %q", tc.want, c.Name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestBypass(t *testing.T) {
+	s := newTestServer(t, map[string]string{"BYPASS": "true"})
+	core, logs := observer.New(zap.InfoLevel)
+	s.logger = zap.New(core).Sugar()
+	in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+
+	if resp := s.Review(context.Background(), in); !resp.Allowed || resp.Patch != nil {
+		t.Fatalf("expected the request to be passed through, got %+v", resp)
+	}
+	if logs.FilterMessageSnippet("BYPASS MODE: passing through request").Len() != 1 {
+		t.Fatalf("expected a bypass warning, got %v", logs.All())
+	}
+
+	s.setBypass(false)
+	if resp := s.Review(context.Background(), in); resp.Patch == nil {
+		t.Fatalf("expected the pod to be mutated, got %+v", resp)
+	}
+
+	s.setBypass(true)
+	if resp := s.Review(context.Background(), in); !resp.Allowed || resp.Patch != nil {
+		t.Fatalf("expected the request to be passed through, got %+v", resp)
+	}
+	if logs.FilterMessageSnippet("BYPASS MODE IS ON").Len() != 1 {
+		t.Fatalf("expected a bypass mode warning, got %v", logs.All())
+	}
+}
+
+func TestLargeObjectPolicy(t *testing.T) {
+	isPod := func(attrs attribute.Set) bool {
+		kind, _ := attrs.Value("kind")
+		return kind.AsString() == "Pod"
+	}
+	for _, tc := range []struct {
+		name        string
+		policy      string
+		wantMutated bool
+	}{
+		{name: "skip", policy: "skip"},
+		{name: "process", policy: "process", wantMutated: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			before := counterValue(t, "webhook_large_objects_total", isPod)
+			s := newTestServer(t, map[string]string{"LARGE_OBJECT_BYTES": "1024", "LARGE_OBJECT_POLICY": tc.policy})
+			pod := testPod(map[string]string{"networkservicemesh.io": testNSURL, "description": strings.Repeat("x", 1024)})
+			resp := s.Review(context.Background(), testRequest(t, admissionv1.Create, pod))
+			if !resp.Allowed || (resp.Patch != nil) != tc.wantMutated {
+				t.Fatalf("expected mutated %v, got %+v", tc.wantMutated, resp)
+			}
+			if got := warnings(resp, "doesn't mutate objects larger than 1024 bytes") == 1; got == tc.wantMutated {
+				t.Fatalf("unexpected warnings: %v", resp.Warnings)
+			}
+			if got := counterValue(t, "webhook_large_objects_total", isPod) - before; got != 1 {
+				t.Fatalf("expected the large object to be recorded once, got %d", got)
+			}
+		})
+	}
+}
+
+func TestSpireWaitInitContainer(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		env      map[string]string
+		wantInit []string
+	}{
+		{
+			name:     "enabled",
+			env:      map[string]string{"INJECT_SPIRE_WAIT_INIT_CONTAINER": "true", "SPIRE_WAIT_IMAGE": "busybox:1.37", "SPIRE_WAIT_TIMEOUT": "30s"},
+			wantInit: []string{"setup", spireWaitContainerName, "cmd-nsc-init"},
+		},
+		{name: "disabled", wantInit: []string{"setup", "cmd-nsc-init"}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, tc.env)
+			pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+			pod.Spec.InitContainers = []corev1.Container{{Name: "setup", Image: "setup:v1"}}
+			in := testRequest(t, admissionv1.Create, pod)
+			var patched corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &patched)
+			if got := containerNamesOf(patched.Spec.InitContainers); !reflect.DeepEqual(got, tc.wantInit) {
+				t.Fatalf("expected init containers %v, got %v", tc.wantInit, got)
+			}
+			if len(tc.env) == 0 {
+				return
+			}
+
+			c := containerByName(t, patched.Spec.InitContainers, spireWaitContainerName)
+			if c.Image != "busybox:1.37" || len(c.Command) != 3 || !strings.Contains(c.Command[2], "[ -S "+spireAgentSocket+" ]") ||
+				!strings.Contains(c.Command[2], "-gt 30") {
+				t.Fatalf("unexpected spire wait container: %+v", c)
+			}
+			if len(c.VolumeMounts) != 1 || c.VolumeMounts[0].Name != "spire-agent-socket" || c.VolumeMounts[0].MountPath != "/run/spire/sockets" {
+				t.Fatalf("expected the spire agent socket mount, got %+v", c.VolumeMounts)
+			}
+			var found bool
+			for i := range patched.Spec.Volumes {
+				found = found || patched.Spec.Volumes[i].Name == "spire-agent-socket"
+			}
+			if !found {
+				t.Fatalf("expected the spire agent socket volume, got %+v", patched.Spec.Volumes)
+			}
+		})
+	}
+}
+
+func TestSkipContainersAnnotation(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		protected   string
+		skipped     string
+		wantMutated []string
+	}{
+		{name: "no annotation", wantMutated: []string{"app", "sidecar-a", "sidecar-b", "agent"}},
+		{name: "annotation", skipped: "sidecar-a, sidecar-b", wantMutated: []string{"app", "agent"}},
+		{name: "annotation with protected containers", protected: "agent", skipped: "sidecar-a", wantMutated: []string{"app", "sidecar-b"}},
+		{name: "protected containers only", protected: "agent", wantMutated: []string{"app", "sidecar-a", "sidecar-b"}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			env := map[string]string{"ENV_TARGET": "all", "APP_CONTAINER_VOLUME_MOUNTS": "nsm-socket:/var/lib/networkservicemesh"}
+			if tc.protected != "" {
+				env["PROTECTED_CONTAINERS"] = tc.protected
+			}
+			s := newTestServer(t, env)
+			annotations := map[string]string{"networkservicemesh.io": testNSURL}
+			if tc.skipped != "" {
+				annotations["networkservicemesh.io/skip-containers"] = tc.skipped
+			}
+			pod := testPod(annotations)
+			for _, name := range []string{"sidecar-a", "sidecar-b", "agent"} {
+				pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: name, Image: name + ":v1"})
+			}
+			in := testRequest(t, admissionv1.Create, pod)
+			var patched corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &patched)
+
+			var mutated []string
+			for i := range pod.Spec.Containers {
+				c := containerByName(t, patched.Spec.Containers, pod.Spec.Containers[i].Name)
+				hasEnv, hasMount := envByName(c, "NSM_NETWORK_SERVICES") != nil, len(c.VolumeMounts) > 0
+				if hasEnv != hasMount {
+					t.Fatalf("container %s is partially mutated: %+v", c.Name, c)
+				}
+				if hasEnv {
+					mutated = append(mutated, c.Name)
+				}
+			}
+			if !reflect.DeepEqual(mutated, tc.wantMutated) {
+				t.Fatalf("expected mutated containers %v, got %v", tc.wantMutated, mutated)
+			}
+		})
+	}
+}
+
+func TestPatchDebugLog(t *testing.T) {
+	for _, tc := range []struct {
+		level   zapcore.Level
+		wantLog bool
+	}{
+		{level: zapcore.DebugLevel, wantLog: true},
+		{level: zapcore.InfoLevel},
+	} {
+		tc := tc
+		t.Run(tc.level.String(), func(t *testing.T) {
+			s := newTestServer(t, nil)
+			core, logs := observer.New(tc.level)
+			s.logger = zap.New(core).Sugar()
+
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+			resp := s.Review(context.Background(), in)
+			if resp.Patch == nil {
+				t.Fatal("expected the pod to be mutated")
+			}
+			entries := logs.FilterMessage("Mutation patch").All()
+			if !tc.wantLog {
+				if len(entries) != 0 {
+					t.Fatalf("expected no patch log, got %v", entries)
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("expected a single patch log, got %v", logs.All())
+			}
+			fields := entries[0].ContextMap()
+			if fields["patch"] != string(resp.Patch) || fields["uid"] != in.UID || fields["kind"] != "Pod" ||
+				fields["namespace"] != "default" || fields["name"] != "app" {
+				t.Fatalf("unexpected patch log fields: %v", fields)
+			}
+		})
+	}
+}
+
+func TestNewLoggerLevel(t *testing.T) {
+	if !newLogger(zap.NewNop(), "debug").Core().Enabled(zapcore.DebugLevel) {
+		t.Fatal("expected debug level to be enabled")
+	}
+	if newLogger(zap.NewNop(), "info").Core().Enabled(zapcore.DebugLevel) {
+		t.Fatal("expected debug level to be disabled")
+	}
+}
+
+func TestNamespaceMutation(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		enabled     string
+		operation   admissionv1.Operation
+		annotations map[string]string
+		wantLabels  map[string]string
+	}{
+		{
+			name:        "annotated namespace",
+			enabled:     "true",
+			operation:   admissionv1.Create,
+			annotations: map[string]string{"networkservicemesh.io": testNSURL},
+			wantLabels:  map[string]string{"team": "nsm", "nsm-injected": "true"},
+		},
+		{name: "not annotated namespace", enabled: "true", operation: admissionv1.Create},
+		{name: "update", enabled: "true", operation: admissionv1.Update, annotations: map[string]string{"networkservicemesh.io": testNSURL}},
+		{name: "disabled", enabled: "false", operation: admissionv1.Create, annotations: map[string]string{"networkservicemesh.io": testNSURL}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"ENABLE_NAMESPACE_MUTATION": tc.enabled, "LABELS": "nsm-injected:true"})
+			namespace := &corev1.Namespace{
+				TypeMeta:   v1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+				ObjectMeta: v1.ObjectMeta{Name: "app", Annotations: tc.annotations, Labels: map[string]string{"team": "nsm"}},
+			}
+			in := testRequest(t, tc.operation, namespace)
+			resp := s.Review(context.Background(), in)
+			if tc.wantLabels == nil {
+				if !resp.Allowed || resp.Patch != nil {
+					t.Fatalf("expected the namespace to be admitted without changes, got %+v", resp)
+				}
+				return
+			}
+
+			var patched corev1.Namespace
+			applyPatch(t, in, resp, &patched)
+			if !reflect.DeepEqual(patched.Labels, tc.wantLabels) {
+				t.Fatalf("expected labels %v, got %v", tc.wantLabels, patched.Labels)
+			}
+		})
+	}
+}
+
+func TestStablePatch(t *testing.T) {
+	env := map[string]string{
+		"ENVS":                    "NSM_LOG_LEVEL=DEBUG,NSM_LISTEN_ON=tcp://$(POD_IP):5001,NSM_NAMESPACE=$(POD_NAMESPACE)",
+		"LABELS":                  "a:1,b:2,c:3,d:4",
+		"LABEL_TO_ENV_MAPPING":    "app:NSM_LABELS,tier:NSM_LABELS,zone:NSM_ZONE,rack:NSM_RACK",
+		"INJECT_ANNOTATIONS":      "x:1,y:2,z:3",
+		"SIDECAR_LIMITS_MEMORY":   "128Mi",
+		"SIDECAR_REQUESTS_MEMORY": "64Mi",
+	}
+	// NSM_NAME of the pods with a name contains a random client ID, so the pod is created with generateName
+	pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+	pod.Name, pod.GenerateName = "", "app-"
+	pod.Labels = map[string]string{"app": "nginx", "tier": "web", "zone": "a", "rack": "1", "version": "v1"}
+
+	var first []byte
+	for i := 0; i < 20; i++ {
+		in := testRequest(t, admissionv1.Create, pod)
+		resp := newTestServer(t, env).Review(context.Background(), in)
+		if resp.Patch == nil {
+			t.Fatal("expected the pod to be mutated")
+		}
+		if first == nil {
+			first = resp.Patch
+			continue
+		}
+		if string(resp.Patch) != string(first) {
+			t.Fatalf("patch is not stable:\n%s\n%s", first, resp.Patch)
+		}
+	}
+}
+
+func TestInjectShareProcessNamespace(t *testing.T) {
+	yes, no := true, false
+	for _, tc := range []struct {
+		name    string
+		enabled string
+		set     *bool
+		hostPID bool
+		want    *bool
+	}{
+		{name: "set", enabled: "true", want: &yes},
+		{name: "already set", enabled: "true", set: &yes, want: &yes},
+		{name: "explicitly false", enabled: "true", set: &no, want: &no},
+		{name: "host PID", enabled: "true", hostPID: true},
+		{name: "disabled", enabled: "false"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"INJECT_SHARE_PROCESS_NAMESPACE": tc.enabled})
+			pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+			pod.Spec.ShareProcessNamespace = tc.set
+			pod.Spec.HostPID = tc.hostPID
+			in := testRequest(t, admissionv1.Create, pod)
+			var patched corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &patched)
+			if got := patched.Spec.ShareProcessNamespace; !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected shareProcessNamespace %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestOmitSidecarResources(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		omit         string
+		value        string
+		wantLimits   int
+		wantRequests int
+	}{
+		{name: "configured", omit: "false", wantLimits: 2, wantRequests: 2},
+		{name: "omitted by config", omit: "true"},
+		{name: "omitted by annotation", omit: "false", value: "none"},
+		{name: "annotation over omitted config", omit: "true", value: "cpu=500m", wantLimits: 1, wantRequests: 1},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"OMIT_SIDECAR_RESOURCES": tc.omit})
+			annotations := map[string]string{"networkservicemesh.io": testNSURL}
+			if tc.value != "" {
+				annotations["networkservicemesh.io/sidecar-resources"] = tc.value
+			}
+			in := testRequest(t, admissionv1.Create, testPod(annotations))
+			var pod corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &pod)
+
+			for _, c := range []*corev1.Container{
+				containerByName(t, pod.Spec.InitContainers, "cmd-nsc-init"),
+				containerByName(t, pod.Spec.Containers, "cmd-nsc"),
+			} {
+				if len(c.Resources.Limits) != tc.wantLimits || len(c.Resources.Requests) != tc.wantRequests {
+					t.Fatalf("expected %d limits and %d requests of container %s, got %v", tc.wantLimits, tc.wantRequests, c.Name, c.Resources)
+				}
+				if tc.value == "cpu=500m" && (c.Resources.Limits.Cpu().String() != "500m" || c.Resources.Requests.Cpu().String() != "500m") {
+					t.Fatalf("expected overridden cpu of container %s, got %v", c.Name, c.Resources)
+				}
+			}
+		})
+	}
+}
+
+func TestDaemonSetSidecarResources(t *testing.T) {
+	owned := func(kind string) *corev1.Pod {
+		pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+		controller := true
+		pod.OwnerReferences = []v1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: "app", UID: "owner-uid", Controller: &controller}}
+		return pod
+	}
+	daemonSet := func(annotations map[string]string) *appsv1.DaemonSet {
+		deployment := testDeployment(annotations)
+		return &appsv1.DaemonSet{
+			TypeMeta:   v1.TypeMeta{APIVersion: "apps/v1", Kind: "DaemonSet"},
+			ObjectMeta: deployment.ObjectMeta,
+			Spec:       appsv1.DaemonSetSpec{Template: deployment.Spec.Template},
+		}
+	}
+	withResources := owned("DaemonSet")
+	withResources.Annotations["networkservicemesh.io/sidecar-resources"] = "cpu=500m"
+	for _, tc := range []struct {
+		name    string
+		obj     runtime.Object
+		wantCPU string
+	}{
+		{name: "pod", obj: testPod(map[string]string{"networkservicemesh.io": testNSURL}), wantCPU: "200m"},
+		{name: "pod of replica set", obj: owned("ReplicaSet"), wantCPU: "200m"},
+		{name: "pod of daemon set", obj: owned("DaemonSet"), wantCPU: "50m"},
+		{name: "annotation over daemon set resources", obj: withResources, wantCPU: "500m"},
+		{name: "deployment", obj: testDeployment(map[string]string{"networkservicemesh.io": testNSURL}), wantCPU: "200m"},
+		{name: "daemon set", obj: daemonSet(map[string]string{"networkservicemesh.io": testNSURL}), wantCPU: "50m"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"DAEMON_SET_SIDECAR_RESOURCES": "cpu=50m"})
+			in := testRequest(t, admissionv1.Create, tc.obj)
+			resp := s.Review(context.Background(), in)
+			var containers []corev1.Container
+			switch tc.obj.(type) {
+			case *corev1.Pod:
+				var pod corev1.Pod
+				applyPatch(t, in, resp, &pod)
+				containers = pod.Spec.Containers
+			case *appsv1.Deployment:
+				var deployment appsv1.Deployment
+				applyPatch(t, in, resp, &deployment)
+				containers = deployment.Spec.Template.Spec.Containers
+			case *appsv1.DaemonSet:
+				var ds appsv1.DaemonSet
+				applyPatch(t, in, resp, &ds)
+				containers = ds.Spec.Template.Spec.Containers
+			}
+
+			resources := containerByName(t, containers, "cmd-nsc").Resources
+			if got := resources.Limits.Cpu().String(); got != tc.wantCPU {
+				t.Fatalf("expected cpu limit %s, got %s", tc.wantCPU, got)
+			}
+			if got := resources.Limits.Memory().String(); got != "80Mi" {
+				t.Fatalf("expected default memory limit, got %s", got)
+			}
+		})
+	}
+}
+
+func TestEmitPatchTests(t *testing.T) {
+	withVolume := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+	withVolume.Spec.Volumes = []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+	for _, tc := range []struct {
+		name    string
+		enabled string
+		obj     runtime.Object
+		want    []string
+	}{
+		{name: "pod", enabled: "true", obj: testPod(map[string]string{"networkservicemesh.io": testNSURL}), want: []string{"/spec/containers"}},
+		{name: "pod with volumes", enabled: "true", obj: withVolume, want: []string{"/spec/containers", "/spec/volumes"}},
+		{name: "deployment", enabled: "true", obj: testDeployment(map[string]string{"networkservicemesh.io": testNSURL}), want: []string{"/spec/template/spec/containers"}},
+		{name: "disabled", enabled: "false", obj: testPod(map[string]string{"networkservicemesh.io": testNSURL})},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"EMIT_PATCH_TESTS": tc.enabled})
+			in := testRequest(t, admissionv1.Create, tc.obj)
+			resp := s.Review(context.Background(), in)
+			if !resp.Allowed || resp.Patch == nil {
+				t.Fatalf("expected the patched object, got %+v", resp)
+			}
+
+			var ops []struct {
+				Op   string `json:"op"`
+				Path string `json:"path"`
+			}
+			if err := json.Unmarshal(resp.Patch, &ops); err != nil {
+				t.Fatalf("failed to unmarshal patch: %v", err)
+			}
+			var tests []string
+			for i := range ops {
+				if ops[i].Op != "test" {
+					continue
+				}
+				if i != len(tests) {
+					t.Fatalf("expected test operations before the changes, got %s at %d", ops[i].Path, i)
+				}
+				tests = append(tests, ops[i].Path)
+			}
+			if !reflect.DeepEqual(tests, tc.want) {
+				t.Fatalf("expected test operations %v, got %v", tc.want, tests)
+			}
+			if _, _, err := webhook.ApplyPatch(in.Object.Raw, resp.Patch); err != nil {
+				t.Fatalf("failed to apply patch to the admitted object: %v", err)
+			}
+		})
+	}
+}
+
+func TestEmitPatchTestsOfChangedObject(t *testing.T) {
+	s := newTestServer(t, map[string]string{"EMIT_PATCH_TESTS": "true"})
+	pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+	in := testRequest(t, admissionv1.Create, pod)
+	resp := s.Review(context.Background(), in)
+
+	pod.Spec.Containers[0].Image = "app:v2"
+	changed, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("failed to marshal pod: %v", err)
+	}
+	if _, _, err = webhook.ApplyPatch(changed, resp.Patch); err == nil {
+		t.Fatal("expected the patch to fail on the changed containers")
+	}
+}
+
+func TestLabelEnvs(t *testing.T) {
+	s := newTestServer(t, map[string]string{"LABEL_TO_ENV_MAPPING": "app:NSM_LABELS,tier:NSM_LABELS,zone:NSM_ZONE"})
+	pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+	pod.Labels = map[string]string{"tier": "web", "app": "nginx", "version": "v1"}
+	in := testRequest(t, admissionv1.Create, pod)
+	var patched corev1.Pod
+	applyPatch(t, in, s.Review(context.Background(), in), &patched)
+
+	for _, c := range []*corev1.Container{
+		containerByName(t, patched.Spec.InitContainers, "cmd-nsc-init"),
+		containerByName(t, patched.Spec.Containers, "cmd-nsc"),
+	} {
+		if env := envByName(c, "NSM_LABELS"); env == nil || env.Value != "app:nginx,tier:web" {
+			t.Fatalf("expected joined labels env of container %s, got %v", c.Name, env)
+		}
+		if env := envByName(c, "NSM_ZONE"); env != nil {
+			t.Fatalf("expected no env for the absent label of container %s, got %v", c.Name, env)
+		}
+	}
+}
+
+func TestExistingContainerOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		position       string
+		wantInit       []string
+		wantContainers []string
+	}{
+		{name: "append", position: "append", wantInit: []string{"setup", "migrate", "cmd-nsc-init"}, wantContainers: []string{"web", "db", "cache", "cmd-nsc"}},
+		{name: "prepend", position: "prepend", wantInit: []string{"setup", "migrate", "cmd-nsc-init"}, wantContainers: []string{"cmd-nsc", "web", "db", "cache"}},
+		{name: "native", position: "native", wantInit: []string{"setup", "migrate", "cmd-nsc-init", "cmd-nsc"}, wantContainers: []string{"web", "db", "cache"}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newVersionedTestServer(t, "v1.28.0", map[string]string{"CONTAINER_INJECTION_POSITION": tc.position})
+			pod := testPod(map[string]string{"networkservicemesh.io": testNSURL})
+			pod.Spec.InitContainers = []corev1.Container{{Name: "setup", Image: "setup:v1"}, {Name: "migrate", Image: "migrate:v1"}}
+			pod.Spec.Containers = []corev1.Container{{Name: "web", Image: "web:v1"}, {Name: "db", Image: "db:v1"}, {Name: "cache", Image: "cache:v1"}}
+			in := testRequest(t, admissionv1.Create, pod)
+			var patched corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &patched)
+
+			if got := containerNamesOf(patched.Spec.InitContainers); !reflect.DeepEqual(got, tc.wantInit) {
+				t.Fatalf("expected init containers %v, got %v", tc.wantInit, got)
+			}
+			if got := containerNamesOf(patched.Spec.Containers); !reflect.DeepEqual(got, tc.wantContainers) {
+				t.Fatalf("expected containers %v, got %v", tc.wantContainers, got)
+			}
+		})
+	}
+}
+
+func TestRequireCRD(t *testing.T) {
+	s := newTestServer(t, map[string]string{"REQUIRE_CRD": "networkservicemesh.io/v1/NetworkService"})
+	in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+
+	if err := s.requiredKind.Check(context.Background()); err == nil {
+		t.Fatal("expected an error for the absent kind")
+	}
+	if resp := s.Review(context.Background(), in); !resp.Allowed || resp.Patch != nil {
+		t.Fatalf("expected the request to be passed through while the kind is absent, got %+v", resp)
+	}
+
+	s.clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*v1.APIResourceList{{
+		GroupVersion: "networkservicemesh.io/v1",
+		APIResources: []v1.APIResource{{Name: "networkservices", Kind: "NetworkService"}},
+	}}
+	if err := s.requiredKind.Check(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var patched corev1.Pod
+	applyPatch(t, in, s.Review(context.Background(), in), &patched)
+	containerByName(t, patched.Spec.Containers, "cmd-nsc")
+}
+
+func TestDispatch(t *testing.T) {
+	const patch = `[{"op":"add","path":"/metadata/labels","value":{"mutated":"true"}}]`
+	for _, tc := range []struct {
+		name        string
+		kind        v1.GroupVersionKind
+		mutate      mutatorFunc
+		wantAllowed bool
+		wantPatch   string
+	}{
+		{
+			name:        "unregistered kind",
+			kind:        v1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"},
+			wantAllowed: true,
+		},
+		{
+			name: "patch",
+			kind: v1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			mutate: func(context.Context, *admissionv1.AdmissionRequest, *admissionv1.AdmissionResponse) ([]byte, error) {
+				return []byte(patch), nil
+			},
+			wantAllowed: true,
+			wantPatch:   patch,
+		},
+		{
+			name: "no patch",
+			kind: v1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			mutate: func(context.Context, *admissionv1.AdmissionRequest, *admissionv1.AdmissionResponse) ([]byte, error) {
+				return nil, nil
+			},
+			wantAllowed: true,
+		},
+		{
+			name: "denied",
+			kind: v1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			mutate: func(_ context.Context, _ *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) ([]byte, error) {
+				resp.Allowed = false
+				return nil, nil
+			},
+		},
+		{
+			name: "error",
+			kind: v1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			mutate: func(context.Context, *admissionv1.AdmissionRequest, *admissionv1.AdmissionResponse) ([]byte, error) {
+				return []byte(patch), errors.New("broken mutator")
+			},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"ON_MUTATION_ERROR": "deny"})
+			if tc.mutate != nil {
+				s.mutators[schema.GroupVersionKind(tc.kind)] = tc.mutate
+			}
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+			in.Kind = tc.kind
+			in.Resource = v1.GroupVersionResource{Group: tc.kind.Group, Version: tc.kind.Version, Resource: strings.ToLower(tc.kind.Kind) + "s"}
+
+			resp := s.Review(context.Background(), in)
+			if resp.Allowed != tc.wantAllowed || string(resp.Patch) != tc.wantPatch {
+				t.Fatalf("expected allowed %v with patch %q, got %+v", tc.wantAllowed, tc.wantPatch, resp)
+			}
+			if tc.wantPatch != "" && (resp.PatchType == nil || *resp.PatchType != admissionv1.PatchTypeJSONPatch) {
+				t.Fatalf("expected JSON patch type, got %v", resp.PatchType)
+			}
+			if tc.name == "error" && (resp.Result == nil || !strings.Contains(resp.Result.Message, "broken mutator")) {
+				t.Fatalf("expected the mutator error, got %+v", resp.Result)
+			}
+		})
+	}
+}
+
+func TestAppVolumeMounts(t *testing.T) {
+	const nsmSocket = "nsm-socket:/var/lib/networkservicemesh"
+	for _, tc := range []struct {
+		name        string
+		annotation  string
+		mounted     string
+		want        []string
+		wantWarning int
+	}{
+		{name: "configured", want: []string{"nsm-socket:/var/lib/networkservicemesh"}},
+		{name: "annotation", annotation: "data:/data,nsm-socket:/nsm", want: []string{"data:/data", "nsm-socket:/nsm"}},
+		{name: "absent volume", annotation: "missing:/missing,data:/data/", want: []string{"data:/data"}},
+		{name: "malformed annotation", annotation: "data", want: []string{"nsm-socket:/var/lib/networkservicemesh"}, wantWarning: 1},
+		{name: "existing mount path", mounted: "/var/lib/networkservicemesh", want: []string{"data:/var/lib/networkservicemesh"}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"APP_CONTAINER_VOLUME_MOUNTS": nsmSocket})
+			annotations := map[string]string{"networkservicemesh.io": testNSURL}
+			if tc.annotation != "" {
+				annotations["networkservicemesh.io/app-volume-mounts"] = tc.annotation
+			}
+			pod := testPod(annotations)
+			pod.Spec.Volumes = []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+			if tc.mounted != "" {
+				pod.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "data", MountPath: tc.mounted}}
+			}
+			in := testRequest(t, admissionv1.Create, pod)
+			resp := s.Review(context.Background(), in)
+			var patched corev1.Pod
+			applyPatch(t, in, resp, &patched)
+
+			var got []string
+			for _, m := range containerByName(t, patched.Spec.Containers, "app").VolumeMounts {
+				got = append(got, m.Name+":"+m.MountPath)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected volume mounts %v, got %v", tc.want, got)
+			}
+			if got := warnings(resp, "default app volume mounts are used"); got != tc.wantWarning {
+				t.Fatalf("expected %d warnings, got %v", tc.wantWarning, resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestNamespaceMetricLabel(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		enabled   string
+		namespace string
+		want      int64
+	}{
+		{name: "enabled", enabled: "true", namespace: "metric-label-enabled", want: 1},
+		{name: "disabled", enabled: "false", namespace: "metric-label-disabled"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			inNamespace := func(attrs attribute.Set) bool {
+				namespace, ok := attrs.Value("namespace")
+				return ok && namespace.AsString() == tc.namespace
+			}
+			s := newTestServer(t, map[string]string{"NAMESPACE_METRIC_LABEL": tc.enabled})
+			for _, annotations := range []map[string]string{{"networkservicemesh.io": testNSURL}, nil} {
+				pod := testPod(annotations)
+				pod.Namespace = tc.namespace
+				s.Review(context.Background(), testRequest(t, admissionv1.Create, pod))
+			}
+
+			if got := counterValue(t, "webhook_admission_reviews_total", inNamespace); got != 2*tc.want {
+				t.Fatalf("expected %d reviews with namespace label, got %d", 2*tc.want, got)
+			}
+			if got := counterValue(t, "webhook_skipped_total", inNamespace); got != tc.want {
+				t.Fatalf("expected %d skips with namespace label, got %d", tc.want, got)
+			}
+			if got := histogramCount(t, "webhook_patch_bytes", inNamespace); got != uint64(tc.want) {
+				t.Fatalf("expected %d patch sizes with namespace label, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestOnDecodeError(t *testing.T) {
+	const (
+		malformed  = `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": {"uid": "abc", "operation": 1}}`
+		withoutUID = `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": {"operation": 1}}`
+		truncated  = `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": {"uid": "abc"`
+	)
+	for _, tc := range []struct {
+		name        string
+		policy      string
+		body        string
+		wantCode    int
+		wantUID     string
+		wantAllowed bool
+	}{
+		{name: "deny", policy: "deny", body: malformed, wantCode: http.StatusOK, wantUID: "abc"},
+		{name: "allow", policy: "allow", body: malformed, wantCode: http.StatusOK, wantUID: "abc", wantAllowed: true},
+		{name: "allow without uid", policy: "allow", body: withoutUID, wantCode: http.StatusBadRequest, wantAllowed: true},
+		{name: "truncated", policy: "deny", body: truncated, wantCode: http.StatusBadRequest},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rec, review := postReview(t, newTestServer(t, map[string]string{"ON_DECODE_ERROR": tc.policy}), tc.body)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("expected status %d, got %d", tc.wantCode, rec.Code)
+			}
+			resp := review.Response
+			if string(resp.UID) != tc.wantUID || resp.Allowed != tc.wantAllowed || resp.Patch != nil {
+				t.Fatalf("expected uid %q and allowed %v without patch, got %+v", tc.wantUID, tc.wantAllowed, resp)
+			}
+			if resp.Result == nil || resp.Result.Code != http.StatusBadRequest || !strings.Contains(resp.Result.Message, "failed to decode") {
+				t.Fatalf("unexpected result: %+v", resp.Result)
+			}
+		})
+	}
+}
+
+func TestSidecarStartupProbe(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		position string
+	}{
+		{name: "container", position: "append"},
+		{name: "native sidecar", position: "native"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newVersionedTestServer(t, "v1.28.0", map[string]string{
+				"CONTAINER_INJECTION_POSITION": tc.position,
+				"SIDECAR_STARTUP_PROBE":        `{"tcpSocket": {"port": 5001}, "failureThreshold": 30, "periodSeconds": 2}`,
+			})
+			in := testRequest(t, admissionv1.Create, testPod(map[string]string{"networkservicemesh.io": testNSURL}))
+			var pod corev1.Pod
+			applyPatch(t, in, s.Review(context.Background(), in), &pod)
+
+			containers := pod.Spec.Containers
+			if tc.position == "native" {
+				containers = pod.Spec.InitContainers
+			}
+			sidecar := containerByName(t, containers, "cmd-nsc")
+			if probe := sidecar.StartupProbe; probe == nil || probe.TCPSocket == nil || probe.TCPSocket.Port.IntValue() != 5001 ||
+				probe.FailureThreshold != 30 || probe.PeriodSeconds != 2 {
+				t.Fatalf("unexpected startup probe: %+v", probe)
+			}
+			if init := containerByName(t, pod.Spec.InitContainers, "cmd-nsc-init"); init.StartupProbe != nil {
+				t.Fatalf("unexpected startup probe of the init container: %+v", init.StartupProbe)
+			}
+			if app := containerByName(t, pod.Spec.Containers, "app"); app.StartupProbe != nil {
+				t.Fatalf("unexpected startup probe of the app container: %+v", app.StartupProbe)
+			}
+		})
+	}
+}
+
+func TestAllowSidecarRemoval(t *testing.T) {
+	annotations := map[string]string{"networkservicemesh.io": testNSURL}
+	injected := testDeployment(annotations)
+	injected.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "cmd-nsc-init", Image: testInitImage}}
+	injected.Spec.Template.Spec.Containers = append(injected.Spec.Template.Spec.Containers,
+		corev1.Container{Name: "cmd-nsc", Image: testImage})
+	removed := injected.DeepCopy()
+	removed.Spec.Template.Spec.Containers = removed.Spec.Template.Spec.Containers[:1]
+
+	for _, tc := range []struct {
+		name           string
+		allow          string
+		wantContainers []string
+		wantAnnotation string
+	}{
+		{name: "allowed", allow: "true", wantContainers: []string{"app"}, wantAnnotation: "cmd-nsc"},
+		{name: "disallowed", allow: "false", wantContainers: []string{"app", "cmd-nsc"}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"MUTATE_ON_UPDATE": "true", "ALLOW_SIDECAR_REMOVAL": tc.allow})
+			in := testRequest(t, admissionv1.Update, removed)
+			in.OldObject = testRequest(t, admissionv1.Update, injected).Object
+			var patched appsv1.Deployment
+			applyPatch(t, in, s.Review(context.Background(), in), &patched)
+
+			spec := patched.Spec.Template.Spec
+			if got := containerNamesOf(spec.Containers); !reflect.DeepEqual(got, tc.wantContainers) {
+				t.Fatalf("expected containers %v, got %v", tc.wantContainers, got)
+			}
+			if got := containerNamesOf(spec.InitContainers); !reflect.DeepEqual(got, []string{"cmd-nsc-init"}) {
+				t.Fatalf("expected init containers [cmd-nsc-init], got %v", got)
+			}
+			if got := patched.Annotations["networkservicemesh.io/removed-sidecars"]; got != tc.wantAnnotation {
+				t.Fatalf("expected removed sidecars %q, got %q", tc.wantAnnotation, got)
+			}
+			if tc.wantAnnotation == "" {
+				return
+			}
+
+			// The removed sidecar isn't injected again by the following updates
+			next := testRequest(t, admissionv1.Update, &patched)
+			next.OldObject = next.Object
+			if resp := s.Review(context.Background(), next); !resp.Allowed || resp.Patch != nil {
+				t.Fatalf("expected the update to be admitted without changes, got %+v", resp)
+			}
+		})
+	}
+}
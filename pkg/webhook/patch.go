@@ -0,0 +1,179 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook provides helpers to inspect mutations produced by cmd-admission-webhook-k8s
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// object covers both pods and workloads with a pod template
+type object struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		corev1.PodSpec
+		Template *corev1.PodTemplateSpec `json:"template"`
+	} `json:"spec"`
+}
+
+func (o *object) pod() (*metav1.ObjectMeta, *corev1.PodSpec) {
+	if o.Spec.Template != nil {
+		return &o.Spec.Template.ObjectMeta, &o.Spec.Template.Spec
+	}
+	return &o.Metadata, &o.Spec.PodSpec
+}
+
+// ApplyPatch applies JSON patch returned by the admission webhook to the original object.
+// It returns the patched object and a human-readable description of the added, changed and removed containers, envs,
+// volumes, labels and annotations of the pod or the pod template.
+func ApplyPatch(original, patch []byte) ([]byte, string, error) {
+	decodedPatch, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to decode patch")
+	}
+	patched, err := decodedPatch.Apply(original)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to apply patch")
+	}
+
+	var before, after object
+	if err = json.Unmarshal(original, &before); err != nil {
+		return nil, "", errors.Wrap(err, "failed to unmarshal original object")
+	}
+	if err = json.Unmarshal(patched, &after); err != nil {
+		return nil, "", errors.Wrap(err, "failed to unmarshal patched object")
+	}
+	return patched, diff(&before, &after), nil
+}
+
+func diff(before, after *object) string {
+	beforeMeta, beforeSpec := before.pod()
+	afterMeta, afterSpec := after.pod()
+
+	var lines []string
+	lines = append(lines, diffContainers("initContainer", beforeSpec.InitContainers, afterSpec.InitContainers)...)
+	lines = append(lines, diffContainers("container", beforeSpec.Containers, afterSpec.Containers)...)
+	lines = append(lines, diffVolumes(beforeSpec.Volumes, afterSpec.Volumes)...)
+	lines = append(lines, diffMap("label", beforeMeta.Labels, afterMeta.Labels)...)
+	lines = append(lines, diffMap("annotation", beforeMeta.Annotations, afterMeta.Annotations)...)
+	return strings.Join(lines, "\n")
+}
+
+func diffContainers(kind string, before, after []corev1.Container) []string {
+	var lines []string
+	existing := make(map[string]*corev1.Container, len(before))
+	for i := range before {
+		existing[before[i].Name] = &before[i]
+	}
+	kept := make(map[string]bool, len(after))
+	for i := range after {
+		c := &after[i]
+		kept[c.Name] = true
+		old, ok := existing[c.Name]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("%s added: %s (%s)", kind, c.Name, c.Image))
+			continue
+		}
+		lines = append(lines, diffEnvs(fmt.Sprintf("%s %s", kind, c.Name), old.Env, c.Env)...)
+	}
+	for i := range before {
+		if !kept[before[i].Name] {
+			lines = append(lines, fmt.Sprintf("%s removed: %s (%s)", kind, before[i].Name, before[i].Image))
+		}
+	}
+	return lines
+}
+
+// diffEnvs compares the whole env vars, so the changes of valueFrom are reported as well.
+func diffEnvs(prefix string, before, after []corev1.EnvVar) []string {
+	var lines []string
+	existing := make(map[string]*corev1.EnvVar, len(before))
+	for i := range before {
+		existing[before[i].Name] = &before[i]
+	}
+	kept := make(map[string]bool, len(after))
+	for i := range after {
+		kept[after[i].Name] = true
+		if old, ok := existing[after[i].Name]; !ok {
+			lines = append(lines, fmt.Sprintf("%s: env added: %s", prefix, after[i].Name))
+		} else if !equality.Semantic.DeepEqual(old, &after[i]) {
+			lines = append(lines, fmt.Sprintf("%s: env changed: %s", prefix, after[i].Name))
+		}
+	}
+	for i := range before {
+		if !kept[before[i].Name] {
+			lines = append(lines, fmt.Sprintf("%s: env removed: %s", prefix, before[i].Name))
+		}
+	}
+	return lines
+}
+
+func diffVolumes(before, after []corev1.Volume) []string {
+	var lines []string
+	existing := make(map[string]bool, len(before))
+	for i := range before {
+		existing[before[i].Name] = true
+	}
+	kept := make(map[string]bool, len(after))
+	for i := range after {
+		kept[after[i].Name] = true
+		if !existing[after[i].Name] {
+			lines = append(lines, fmt.Sprintf("volume added: %s", after[i].Name))
+		}
+	}
+	for i := range before {
+		if !kept[before[i].Name] {
+			lines = append(lines, fmt.Sprintf("volume removed: %s", before[i].Name))
+		}
+	}
+	return lines
+}
+
+func diffMap(kind string, before, after map[string]string) []string {
+	var lines []string
+	for _, key := range sortedKeys(after) {
+		if value, ok := before[key]; !ok {
+			lines = append(lines, fmt.Sprintf("%s added: %s=%s", kind, key, after[key]))
+		} else if value != after[key] {
+			lines = append(lines, fmt.Sprintf("%s changed: %s=%s", kind, key, after[key]))
+		}
+	}
+	for _, key := range sortedKeys(before) {
+		if _, ok := after[key]; !ok {
+			lines = append(lines, fmt.Sprintf("%s removed: %s", kind, key))
+		}
+	}
+	return lines
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2026 Cisco and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/networkservicemesh/cmd-admission-webhook/pkg/webhook"
+)
+
+const pod = `{
+	"metadata": {"name": "app", "labels": {"app": "a", "tier": "web"}, "annotations": {"owner": "team"}},
+	"spec": {
+		"initContainers": [{"name": "init", "image": "init:v1"}],
+		"containers": [
+			{"name": "app", "image": "app:v1", "env": [
+				{"name": "KEEP", "value": "1"},
+				{"name": "VALUE", "value": "old"},
+				{"name": "FROM", "valueFrom": {"fieldRef": {"fieldPath": "metadata.name"}}},
+				{"name": "DROP", "value": "1"}
+			]},
+			{"name": "sidecar", "image": "sidecar:v1"}
+		],
+		"volumes": [{"name": "data", "emptyDir": {}}, {"name": "cache", "emptyDir": {}}]
+	}
+}`
+
+func TestApplyPatch(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{
+			name:  "container added",
+			patch: `[{"op": "add", "path": "/spec/containers/-", "value": {"name": "nsc", "image": "cmd-nsc:v1"}}]`,
+			want:  "container added: nsc (cmd-nsc:v1)",
+		},
+		{
+			name:  "container removed",
+			patch: `[{"op": "remove", "path": "/spec/containers/1"}]`,
+			want:  "container removed: sidecar (sidecar:v1)",
+		},
+		{
+			name:  "init container added",
+			patch: `[{"op": "add", "path": "/spec/initContainers/-", "value": {"name": "nsc-init", "image": "cmd-nsc-init:v1"}}]`,
+			want:  "initContainer added: nsc-init (cmd-nsc-init:v1)",
+		},
+		{
+			name:  "env added",
+			patch: `[{"op": "add", "path": "/spec/containers/0/env/-", "value": {"name": "NEW", "value": "1"}}]`,
+			want:  "container app: env added: NEW",
+		},
+		{
+			name:  "env value changed",
+			patch: `[{"op": "replace", "path": "/spec/containers/0/env/1/value", "value": "new"}]`,
+			want:  "container app: env changed: VALUE",
+		},
+		{
+			name:  "env valueFrom changed",
+			patch: `[{"op": "replace", "path": "/spec/containers/0/env/2/valueFrom/fieldRef/fieldPath", "value": "metadata.namespace"}]`,
+			want:  "container app: env changed: FROM",
+		},
+		{
+			name:  "env removed",
+			patch: `[{"op": "remove", "path": "/spec/containers/0/env/3"}]`,
+			want:  "container app: env removed: DROP",
+		},
+		{
+			name:  "volume added",
+			patch: `[{"op": "add", "path": "/spec/volumes/-", "value": {"name": "nsm-socket", "emptyDir": {}}}]`,
+			want:  "volume added: nsm-socket",
+		},
+		{
+			name:  "volume removed",
+			patch: `[{"op": "remove", "path": "/spec/volumes/1"}]`,
+			want:  "volume removed: cache",
+		},
+		{
+			name:  "labels changed",
+			patch: `[{"op": "add", "path": "/metadata/labels", "value": {"app": "b", "nsm": "true"}}]`,
+			want:  "label changed: app=b\nlabel added: nsm=true\nlabel removed: tier",
+		},
+		{
+			name:  "annotation removed",
+			patch: `[{"op": "remove", "path": "/metadata/annotations/owner"}]`,
+			want:  "annotation removed: owner",
+		},
+		{
+			name:  "no changes",
+			patch: `[]`,
+			want:  "",
+		},
+	} {
+		patch, want := tc.patch, tc.want
+		t.Run(tc.name, func(t *testing.T) {
+			_, got, err := webhook.ApplyPatch([]byte(pod), []byte(patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != want {
+				t.Errorf("unexpected diff:\n%s\nexpected:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyPatchTemplate(t *testing.T) {
+	deployment := `{"metadata": {"name": "app"}, "spec": {"template": {"spec": {"containers": [{"name": "app", "image": "app:v1"}]}}}}`
+	patch := `[{"op": "add", "path": "/spec/template/spec/containers/-", "value": {"name": "nsc", "image": "cmd-nsc:v1"}}]`
+
+	patched, got, err := webhook.ApplyPatch([]byte(deployment), []byte(patch))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "container added: nsc (cmd-nsc:v1)" {
+		t.Errorf("unexpected diff: %s", got)
+	}
+	if !strings.Contains(string(patched), `"cmd-nsc:v1"`) {
+		t.Errorf("patched object doesn't contain the added container: %s", patched)
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	if _, _, err := webhook.ApplyPatch([]byte(pod), []byte(`{`)); err == nil {
+		t.Error("expected an error for a malformed patch")
+	}
+	if _, _, err := webhook.ApplyPatch([]byte(pod), []byte(`[{"op": "remove", "path": "/spec/containers/5"}]`)); err == nil {
+		t.Error("expected an error for a patch that can't be applied")
+	}
+}